@@ -0,0 +1,129 @@
+// Package audit records security-relevant actions in the append-only audit log.
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+// Actions, grouped by the category before the dot
+const (
+	Login        = "auth.login"
+	LoginFailed  = "auth.login_failed"
+	Logout       = "auth.logout"
+	SessionEnded = "auth.session_revoked"
+	TOTPEnabled  = "auth.totp_enabled"
+	TOTPDisabled = "auth.totp_disabled"
+	AccessDenied = "auth.access_denied"
+	LockedOut    = "auth.locked_out"
+
+	ConfigUpdated     = "config.updated"
+	SSOUpdated        = "config.sso_updated"
+	SMTPUpdated       = "config.smtp_updated"
+	QuietHoursUpdated = "config.quiet_hours_updated"
+
+	NotifierCreated  = "notifier.created"
+	NotifierUpdated  = "notifier.updated"
+	NotifierDeleted  = "notifier.deleted"
+	AlertRuleCreated = "alert_rule.created"
+	AlertRuleUpdated = "alert_rule.updated"
+	AlertRuleDeleted = "alert_rule.deleted"
+
+	AssetUpdated = "asset.updated"
+	AssetDeleted = "asset.deleted"
+
+	ScanStarted   = "scan.started"
+	ScanCancelled = "scan.cancelled"
+
+	SuppressionCreated = "suppression.created"
+	SuppressionDeleted = "suppression.deleted"
+
+	UserCreated  = "user.created"
+	UserUpdated  = "user.updated"
+	UserDeleted  = "user.deleted"
+	TokenCreated = "token.created"
+	TokenDeleted = "token.deleted"
+)
+
+// System is the actor of scheduled work
+const System = "system"
+
+// Change is the before and after value of one changed field
+type Change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// Record appends an entry; a failure is logged rather than failing the action it describes
+func Record(e models.AuditEntry) {
+	if err := db.RecordAudit(db.DB, e); err != nil {
+		log.Printf("Failed to write audit log (%s by %q): %v", e.Action, e.Actor, err)
+	}
+}
+
+// Request records an action taken by u through r; detail is stored as JSON
+func Request(r *http.Request, u models.User, action, target string, detail any) {
+	Record(models.AuditEntry{
+		ActorID:    u.ID,
+		Actor:      u.Username,
+		Action:     action,
+		Target:     target,
+		Detail:     marshal(detail),
+		RemoteAddr: RemoteIP(r),
+	})
+}
+
+// Scheduled records an action taken by the scheduler rather than a user
+func Scheduled(action, target string, detail any) {
+	Record(models.AuditEntry{Actor: System, Action: action, Target: target, Detail: marshal(detail)})
+}
+
+// Diff lists the fields that differ between two values of the same struct, keyed by JSON name
+func Diff(before, after any) map[string]Change {
+	var b, a map[string]any
+	if err := json.Unmarshal(marshal(before), &b); err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(marshal(after), &a); err != nil {
+		return nil
+	}
+
+	changes := map[string]Change{}
+	for k, v := range a {
+		if !reflect.DeepEqual(b[k], v) {
+			changes[k] = Change{Before: b[k], After: v}
+		}
+	}
+	for k, v := range b {
+		if _, ok := a[k]; !ok {
+			changes[k] = Change{Before: v}
+		}
+	}
+	return changes
+}
+
+func marshal(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// RemoteIP is the client address of r without the port
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
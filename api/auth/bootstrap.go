@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/config"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+var ErrSetupDone = errors.New("setup already completed")
+var ErrInvalidSetupToken = errors.New("invalid setup token")
+
+var setup struct {
+	mu        sync.Mutex
+	tokenHash []byte
+}
+
+// Bootstrap makes sure the first account can be created. With no users yet it
+// creates one from the configured admin account, or otherwise logs a
+// one-time token for POST /api/setup.
+func Bootstrap() error {
+	n, err := db.CountUsers(db.DB)
+	if err != nil || n > 0 {
+		return err
+	}
+
+	if password := config.Current.Admin.Password; password != "" {
+		username := strings.TrimSpace(config.Current.Admin.Username)
+
+		hash, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+		if _, err := db.CreateUser(db.DB, username, models.RoleAdmin, hash); err != nil {
+			return err
+		}
+		log.Printf("Created initial user %q from the configured admin password", username)
+		audit.Scheduled(audit.UserCreated, "user:"+username, map[string]string{"role": models.RoleAdmin, "via": "config"})
+		return nil
+	}
+
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(raw)
+	sum := sha256.Sum256([]byte(token))
+
+	setup.mu.Lock()
+	setup.tokenHash = sum[:]
+	setup.mu.Unlock()
+
+	log.Printf("No users yet. Create the first one with POST /api/setup using setup token %s", token)
+	return nil
+}
+
+// CompleteSetup creates the first (admin) user if token matches the one logged at startup.
+// The token is single use.
+func CompleteSetup(token, username, password string) error {
+	setup.mu.Lock()
+	defer setup.mu.Unlock()
+
+	if setup.tokenHash == nil {
+		return ErrSetupDone
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(sum[:], setup.tokenHash) != 1 {
+		return ErrInvalidSetupToken
+	}
+
+	n, err := db.CountUsers(db.DB)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		setup.tokenHash = nil
+		return ErrSetupDone
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if _, err := db.CreateUser(db.DB, username, models.RoleAdmin, hash); err != nil {
+		return err
+	}
+
+	setup.tokenHash = nil
+	return nil
+}
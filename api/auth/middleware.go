@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+// Paths reachable without credentials
+var publicPaths = map[string]bool{
+	"/api/setup":      true,
+	"/api/auth/login": true,
+
+	"/api/auth/oidc":          true,
+	"/api/auth/oidc/login":    true,
+	"/api/auth/oidc/callback": true,
+}
+
+type ctxKey struct{}
+
+// UserFrom returns the authenticated user of a request
+func UserFrom(ctx context.Context) (models.User, bool) {
+	u, ok := ctx.Value(ctxKey{}).(models.User)
+	return u, ok
+}
+
+func WithUser(ctx context.Context, u models.User) context.Context {
+	return context.WithValue(ctx, ctxKey{}, u)
+}
+
+// bcrypt is deliberately slow and basic auth sends the password with every
+// request, so successful checks are remembered for a few minutes. The key
+// includes the stored hash, so a password change invalidates it.
+const verifiedTTL = 5 * time.Minute
+
+var verified = struct {
+	sync.Mutex
+	entries map[[32]byte]time.Time
+}{entries: map[[32]byte]time.Time{}}
+
+func credentialKey(u models.User, password string) [32]byte {
+	return sha256.Sum256([]byte(u.PasswordHash + "\x00" + password))
+}
+
+// Authenticate checks a username and password against the users table
+func Authenticate(username, password string) (models.User, bool) {
+	u, err := db.GetUserByName(db.DB, username)
+	if err != nil {
+		CheckPassword("", password)
+		return models.User{}, false
+	}
+
+	key := credentialKey(u, password)
+	now := time.Now()
+
+	verified.Lock()
+	expires, ok := verified.entries[key]
+	verified.Unlock()
+	if ok && now.Before(expires) {
+		return u, true
+	}
+
+	if !CheckPassword(u.PasswordHash, password) {
+		return models.User{}, false
+	}
+
+	verified.Lock()
+	for k, exp := range verified.entries {
+		if now.After(exp) {
+			delete(verified.entries, k)
+		}
+	}
+	verified.entries[key] = now.Add(verifiedTTL)
+	verified.Unlock()
+
+	return u, true
+}
+
+// Middleware requires a session cookie, HTTP basic auth or a bearer API token for
+// every API route except the public paths. The web UI itself is served to anyone
+// so it can show the login form.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := r.Header.Get("Authorization")
+
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			u, ok := AuthenticateToken(strings.TrimSpace(token))
+			if !ok {
+				audit.Request(r, models.User{}, audit.LoginFailed, "", map[string]string{"method": "token", "reason": "invalid or expired token"})
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), u)))
+			return
+		}
+
+		if username, password, ok := r.BasicAuth(); ok {
+			ip := audit.RemoteIP(r)
+			var locked *LockedError
+			if errors.As(CheckLogin(ip, username), &locked) {
+				TooManyRequests(w, locked.RetryAfter, locked.Error())
+				return
+			}
+
+			u, ok := Authenticate(username, password)
+			if !ok {
+				audit.Request(r, models.User{}, audit.LoginFailed, "user:"+username, map[string]string{"method": "basic", "reason": ErrInvalidCredentials.Error()})
+				failLogin(ip, username, nil)
+				unauthorized(w, r)
+				return
+			}
+			LoginSucceeded(username)
+			// A password alone must not get around two-factor
+			if u.TOTPEnabled {
+				http.Error(w, "two-factor enabled, sign in or use an API token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), u)))
+			return
+		}
+
+		if cookie, err := r.Cookie(SessionCookie); err == nil && header == "" {
+			u, s, ok := AuthenticateSession(cookie.Value)
+			if !ok {
+				ClearSessionCookie(w, r)
+				http.Error(w, "Session expired", http.StatusUnauthorized)
+				return
+			}
+			// Browsers attach cookies to cross-site requests, so changes need the CSRF token too
+			if !safeMethod(r.Method) && !validCSRF(s, r) {
+				http.Error(w, "invalid CSRF token", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(WithUser(r.Context(), u), sessionKey{}, s)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		unauthorized(w, r)
+	})
+}
+
+// TooManyRequests rejects a request with a Retry-After header in whole seconds
+func TooManyRequests(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
+
+// unauthorized challenges for basic auth, except on script requests from the web UI
+// where the challenge would pop up the browser's own login dialog
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	if mode := r.Header.Get("Sec-Fetch-Mode"); mode == "" || mode == "navigate" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
@@ -0,0 +1,460 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+const (
+	oidcLoginTTL    = 10 * time.Minute // time allowed at the provider's login page
+	oidcMaxPending  = 1000
+	oidcSkew        = time.Minute
+	discoveryTTL    = time.Hour
+	jwksMinInterval = time.Minute // unknown kids refetch the key set at most this often
+)
+
+var (
+	ErrOIDCDisabled   = errors.New("single sign-on is not enabled")
+	ErrOIDCState      = errors.New("login request expired or was not started here")
+	ErrNoRole         = errors.New("your account is not mapped to a Sentry role")
+	ErrUsernameTaken  = errors.New("username belongs to a local account")
+	ErrTooManyPending = errors.New("too many logins in progress, try again later")
+)
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+type provider struct {
+	issuer      string
+	authURL     string
+	tokenURL    string
+	jwksURL     string
+	authMethods []string
+	fetched     time.Time
+
+	mu          sync.Mutex
+	keys        map[string]verifyKey
+	keysFetched time.Time
+}
+
+var providers = struct {
+	sync.Mutex
+	byIssuer map[string]*provider
+}{byIssuer: map[string]*provider{}}
+
+// CheckIssuer requires https, except on loopback addresses so a local mock provider works
+func CheckIssuer(issuer string) error {
+	u, err := url.Parse(issuer)
+	if err != nil || u.Host == "" {
+		return errors.New("issuer must be an absolute URL")
+	}
+	if u.Scheme == "https" {
+		return nil
+	}
+
+	host := u.Hostname()
+	ip := net.ParseIP(host)
+	if u.Scheme == "http" && (host == "localhost" || (ip != nil && ip.IsLoopback())) {
+		return nil
+	}
+	return errors.New("issuer must use https")
+}
+
+func getJSON(ctx context.Context, rawURL string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// CheckProvider fetches the discovery document so settings can be validated before saving
+func CheckProvider(ctx context.Context, issuer string) error {
+	_, err := discover(ctx, issuer)
+	return err
+}
+
+// discover loads the provider's discovery document, cached for an hour
+func discover(ctx context.Context, issuer string) (*provider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	providers.Lock()
+	p, ok := providers.byIssuer[issuer]
+	providers.Unlock()
+	if ok && time.Since(p.fetched) < discoveryTTL {
+		return p, nil
+	}
+
+	var doc struct {
+		Issuer      string   `json:"issuer"`
+		AuthURL     string   `json:"authorization_endpoint"`
+		TokenURL    string   `json:"token_endpoint"`
+		JWKSURL     string   `json:"jwks_uri"`
+		AuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+		PKCEMethods []string `json:"code_challenge_methods_supported"`
+	}
+	if err := getJSON(ctx, issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("discovery: %v", err)
+	}
+
+	switch {
+	case strings.TrimSuffix(doc.Issuer, "/") != issuer:
+		return nil, fmt.Errorf("discovery: document is for issuer %q", doc.Issuer)
+	case doc.AuthURL == "" || doc.TokenURL == "" || doc.JWKSURL == "":
+		return nil, errors.New("discovery: missing endpoints")
+	case len(doc.PKCEMethods) > 0 && !slices.Contains(doc.PKCEMethods, "S256"):
+		return nil, errors.New("discovery: provider does not support PKCE with S256")
+	}
+
+	p = &provider{
+		issuer:      doc.Issuer,
+		authURL:     doc.AuthURL,
+		tokenURL:    doc.TokenURL,
+		jwksURL:     doc.JWKSURL,
+		authMethods: doc.AuthMethods,
+		fetched:     time.Now(),
+	}
+
+	providers.Lock()
+	providers.byIssuer[issuer] = p
+	providers.Unlock()
+	return p, nil
+}
+
+// key finds a signing key by kid, refetching the key set when the provider rotated keys
+func (p *provider) key(ctx context.Context, kid string) (verifyKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(p.keysFetched) < jwksMinInterval {
+		return verifyKey{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.jwksURL, nil)
+	if err != nil {
+		return verifyKey{}, err
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return verifyKey{}, fmt.Errorf("jwks: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return verifyKey{}, fmt.Errorf("jwks: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return verifyKey{}, err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return verifyKey{}, fmt.Errorf("jwks: %v", err)
+	}
+	p.keys, p.keysFetched = keys, time.Now()
+
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return verifyKey{}, fmt.Errorf("unknown signing key %q", kid)
+}
+
+type pendingLogin struct {
+	verifier string
+	nonce    string
+	expires  time.Time
+}
+
+// Logins waiting for the provider to redirect back, by state
+var pending = struct {
+	sync.Mutex
+	logins map[string]pendingLogin
+}{logins: map[string]pendingLogin{}}
+
+// OIDCAuthURL starts a login and returns the provider URL to redirect to, with
+// the state the callback must present
+func OIDCAuthURL(ctx context.Context, s models.OIDCSettings) (authURL, state string, err error) {
+	if !s.Enabled {
+		return "", "", ErrOIDCDisabled
+	}
+	p, err := discover(ctx, s.Issuer)
+	if err != nil {
+		return "", "", err
+	}
+
+	login := pendingLogin{expires: time.Now().Add(oidcLoginTTL)}
+	if state, err = randomToken(); err != nil {
+		return "", "", err
+	}
+	if login.verifier, err = randomToken(); err != nil {
+		return "", "", err
+	}
+	if login.nonce, err = randomToken(); err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	pending.Lock()
+	for k, l := range pending.logins {
+		if now.After(l.expires) {
+			delete(pending.logins, k)
+		}
+	}
+	if len(pending.logins) >= oidcMaxPending {
+		pending.Unlock()
+		return "", "", ErrTooManyPending
+	}
+	pending.logins[state] = login
+	pending.Unlock()
+
+	challenge := sha256.Sum256([]byte(login.verifier))
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", s.ClientID)
+	q.Set("redirect_uri", s.RedirectURL)
+	q.Set("scope", strings.Join(s.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", login.nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	return p.authURL + sep + q.Encode(), state, nil
+}
+
+// OIDCCallback redeems the authorization code, verifies the ID token and returns
+// the linked user, creating it on first login
+func OIDCCallback(ctx context.Context, s models.OIDCSettings, state, code string) (models.User, error) {
+	if !s.Enabled {
+		return models.User{}, ErrOIDCDisabled
+	}
+
+	pending.Lock()
+	login, ok := pending.logins[state]
+	delete(pending.logins, state)
+	pending.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return models.User{}, ErrOIDCState
+	}
+
+	p, err := discover(ctx, s.Issuer)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	idToken, err := p.exchange(ctx, s, code, login.verifier)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	claims, err := p.verifyIDToken(ctx, s, idToken, login.nonce)
+	if err != nil {
+		return models.User{}, fmt.Errorf("id token: %v", err)
+	}
+
+	return linkUser(s, p.issuer, claims)
+}
+
+func (p *provider) exchange(ctx context.Context, s models.OIDCSettings, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", s.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", s.ClientID)
+
+	// client_secret_basic is the default when the provider does not list its methods
+	basic := s.ClientSecret != "" && (len(p.authMethods) == 0 || slices.Contains(p.authMethods, "client_secret_basic"))
+	if s.ClientSecret != "" && !basic {
+		form.Set("client_secret", s.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(s.ClientID), url.QueryEscape(s.ClientSecret))
+	}
+
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("token response: %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("token request: %s %s", body.Error, body.Description)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+func (p *provider) verifyIDToken(ctx context.Context, s models.OIDCSettings, token, nonce string) (map[string]any, error) {
+	header, claims, signed, sig, err := splitJWT(token)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, k, signed, sig); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	iss, _ := claims["iss"].(string)
+	sub, _ := claims["sub"].(string)
+	gotNonce, _ := claims["nonce"].(string)
+	exp, _ := claims["exp"].(float64)
+	iat, _ := claims["iat"].(float64)
+
+	var aud []string
+	switch v := claims["aud"].(type) {
+	case string:
+		aud = []string{v}
+	case []any:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+	}
+	azp, _ := claims["azp"].(string)
+
+	switch {
+	case iss != p.issuer:
+		return nil, fmt.Errorf("issued by %q", iss)
+	case !slices.Contains(aud, s.ClientID):
+		return nil, errors.New("not issued for this client")
+	case len(aud) > 1 && azp != s.ClientID:
+		return nil, errors.New("authorized party mismatch")
+	case sub == "":
+		return nil, errors.New("missing subject")
+	case gotNonce != nonce:
+		return nil, errors.New("nonce mismatch")
+	case exp == 0 || now.After(time.Unix(int64(exp), 0).Add(oidcSkew)):
+		return nil, errors.New("expired")
+	case iat != 0 && time.Unix(int64(iat), 0).After(now.Add(oidcSkew)):
+		return nil, errors.New("issued in the future")
+	}
+	return claims, nil
+}
+
+// MapRole picks the highest role mapped from the role claim values, or the default role
+func MapRole(s models.OIDCSettings, claims map[string]any) string {
+	var values []string
+	switch v := claims[s.RoleClaim].(type) {
+	case string:
+		values = []string{v}
+	case []any:
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+	}
+
+	role := ""
+	for _, v := range values {
+		if r, ok := s.RoleMappings[v]; ok && roleRank[r] > roleRank[role] {
+			role = r
+		}
+	}
+	if role == "" {
+		role = s.DefaultRole
+	}
+	return role
+}
+
+// linkUser finds the account for an issuer and subject and keeps its role in step
+// with the provider. A new account never takes over a local one with the same name.
+func linkUser(s models.OIDCSettings, issuer string, claims map[string]any) (models.User, error) {
+	sub, _ := claims["sub"].(string)
+	subject := issuer + "|" + sub
+
+	role := MapRole(s, claims)
+	if role == "" {
+		return models.User{}, ErrNoRole
+	}
+
+	u, err := db.GetUserBySubject(db.DB, subject)
+	if err == nil {
+		if u.Role != role {
+			log.Printf("SSO user %s role changed from %s to %s by the provider", u.Username, u.Role, role)
+			if err := db.SetUserRole(db.DB, u.ID, role); err != nil {
+				return models.User{}, err
+			}
+			u.Role = role
+		}
+		return u, nil
+	}
+	if err != sql.ErrNoRows {
+		return models.User{}, err
+	}
+
+	username, _ := claims[s.UsernameClaim].(string)
+	username = strings.TrimSpace(username)
+	if username == "" || len(username) > 64 || strings.ContainsFunc(username, unicode.IsSpace) {
+		return models.User{}, fmt.Errorf("id token has no usable %s claim", s.UsernameClaim)
+	}
+	if _, err := db.GetUserByName(db.DB, username); err == nil {
+		return models.User{}, ErrUsernameTaken
+	}
+
+	id, err := db.CreateSSOUser(db.DB, username, role, subject)
+	if err != nil {
+		return models.User{}, err
+	}
+	log.Printf("Created SSO user %s (%s)", username, role)
+	return db.GetUser(db.DB, id)
+}
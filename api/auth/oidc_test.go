@@ -0,0 +1,780 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+const testClientID = "sentry"
+
+// openTestDB points db.DB at a fresh database for the duration of the test
+func openTestDB(t *testing.T) {
+	t.Helper()
+
+	conn, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(db.Schema); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	if err := db.Migrate(conn); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	prev := db.DB
+	db.DB = conn
+	t.Cleanup(func() {
+		db.DB = prev
+		conn.Close()
+	})
+}
+
+// grant is an authorization code the mock issuer handed out
+type grant struct {
+	challenge string
+	claims    map[string]any
+}
+
+// mockIssuer is an OpenID provider on an httptest server. Tests stand in for the
+// browser: they call authorize with what OIDCAuthURL asked for, then redeem the code.
+type mockIssuer struct {
+	*httptest.Server
+	t *testing.T
+
+	mu          sync.Mutex
+	docIssuer   string   // issuer in the discovery document, the server URL by default
+	pkce        []string // code_challenge_methods_supported
+	authMethods []string // token_endpoint_auth_methods_supported
+	published   map[string]*ecdsa.PrivateKey
+	signWith    string // kid that signs ID tokens
+	codes       map[string]grant
+	jwksFetches int
+	clientAuth  string // how the last token request authenticated
+	tokenFunc   func(claims map[string]any) string
+}
+
+func startIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	m := &mockIssuer{
+		t:         t,
+		pkce:      []string{"S256"},
+		published: map[string]*ecdsa.PrivateKey{"key-1": newECKey(t)},
+		signWith:  "key-1",
+		codes:     map[string]grant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/jwks", m.jwks)
+	mux.HandleFunc("/token", m.token)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		m.Close()
+		providers.Lock()
+		delete(providers.byIssuer, m.URL)
+		providers.Unlock()
+	})
+	return m
+}
+
+// fetches reports how often the key set was fetched and how the last token request authenticated
+func (m *mockIssuer) fetches() (int, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksFetches, m.clientAuth
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (m *mockIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	issuer := m.URL
+	if m.docIssuer != "" {
+		issuer = m.docIssuer
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                m.URL + "/authorize",
+		"token_endpoint":                        m.URL + "/token",
+		"jwks_uri":                              m.URL + "/jwks",
+		"code_challenge_methods_supported":      m.pkce,
+		"token_endpoint_auth_methods_supported": m.authMethods,
+	})
+}
+
+func (m *mockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.jwksFetches++
+	var keys []map[string]string
+	for kid, key := range m.published {
+		keys = append(keys, map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"kid": kid,
+			"use": "sig",
+			"alg": "ES256",
+			"x":   b64(key.X.FillBytes(make([]byte, 32))),
+			"y":   b64(key.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fail := func(code, desc string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": desc})
+	}
+
+	if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "authorization_code" {
+		fail("invalid_request", "not an authorization code grant")
+		return
+	}
+
+	m.clientAuth = "none"
+	if id, secret, ok := r.BasicAuth(); ok {
+		m.clientAuth = "basic:" + id + ":" + secret
+	} else if secret := r.Form.Get("client_secret"); secret != "" {
+		m.clientAuth = "post:" + secret
+	}
+
+	g, ok := m.codes[r.Form.Get("code")]
+	delete(m.codes, r.Form.Get("code"))
+	if !ok {
+		fail("invalid_grant", "unknown code")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if b64(sum[:]) != g.challenge {
+		fail("invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	token := m.sign(g.claims)
+	if m.tokenFunc != nil {
+		token = m.tokenFunc(g.claims)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": token, "access_token": "at", "token_type": "Bearer"})
+}
+
+// sign returns an ES256 ID token from the current signing key; m.mu must be held
+func (m *mockIssuer) sign(claims map[string]any) string {
+	return m.signWithKey(m.published[m.signWith], claims)
+}
+
+func (m *mockIssuer) signWithKey(key *ecdsa.PrivateKey, claims map[string]any) string {
+	signed := jwtPart(map[string]string{"alg": "ES256", "kid": m.signWith, "typ": "JWT"}) + "." + jwtPart(claims)
+
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signed + "." + b64(append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...))
+}
+
+func (m *mockIssuer) settings() models.OIDCSettings {
+	return models.OIDCSettings{
+		Enabled:       true,
+		Issuer:        m.URL,
+		ClientID:      testClientID,
+		RedirectURL:   "https://sentry.example.com/api/auth/oidc/callback",
+		Scopes:        []string{"openid", "profile"},
+		UsernameClaim: "preferred_username",
+		RoleClaim:     "groups",
+		RoleMappings:  map[string]string{"sec-admins": models.RoleAdmin, "sec-team": models.RoleViewer},
+	}
+}
+
+// claims are valid ID token claims for the subject; the nonce is added by authorize
+func (m *mockIssuer) claims(sub, username string, groups ...string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":                m.URL,
+		"sub":                sub,
+		"aud":                testClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"preferred_username": username,
+		"groups":             groups,
+	}
+}
+
+// authorize starts a login, checks the request the browser would carry to the
+// provider and issues a code for claims. It returns the state and code the
+// provider redirects back with.
+func (m *mockIssuer) authorize(t *testing.T, s models.OIDCSettings, claims map[string]any) (state, code string) {
+	t.Helper()
+
+	authURL, state, err := OIDCAuthURL(context.Background(), s)
+	if err != nil {
+		t.Fatalf("OIDCAuthURL: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil || !strings.HasPrefix(authURL, m.URL+"/authorize?") {
+		t.Fatalf("auth URL %q does not point at the provider", authURL)
+	}
+	q := u.Query()
+	if q.Get("state") != state || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" {
+		t.Fatalf("auth URL lacks state, nonce or an S256 challenge: %s", authURL)
+	}
+
+	if _, ok := claims["nonce"]; !ok {
+		claims["nonce"] = q.Get("nonce")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code = fmt.Sprintf("code-%d", len(m.codes)+1)
+	m.codes[code] = grant{challenge: q.Get("code_challenge"), claims: claims}
+	return state, code
+}
+
+// login runs a full authorization code flow for claims
+func (m *mockIssuer) login(t *testing.T, s models.OIDCSettings, claims map[string]any) (models.User, error) {
+	t.Helper()
+	state, code := m.authorize(t, s, claims)
+	return OIDCCallback(context.Background(), s, state, code)
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func jwtPart(v any) string {
+	data, _ := json.Marshal(v)
+	return b64(data)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	openTestDB(t)
+	m := startIssuer(t)
+	s := m.settings()
+
+	u, err := m.login(t, s, m.claims("u-1", "alice", "sec-team"))
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if u.Username != "alice" || u.Role != models.RoleViewer {
+		t.Fatalf("user = %s (%s), want alice as viewer", u.Username, u.Role)
+	}
+	if _, auth := m.fetches(); auth != "none" {
+		t.Errorf("public client authenticated with %s", auth)
+	}
+
+	// The next login finds the same account by issuer and subject
+	again, err := m.login(t, s, m.claims("u-1", "alice-renamed", "sec-team"))
+	if err != nil || again.ID != u.ID {
+		t.Fatalf("second login: user %d, err %v, want user %d", again.ID, err, u.ID)
+	}
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	m := startIssuer(t)
+	if err := CheckProvider(context.Background(), m.URL+"/"); err != nil {
+		t.Fatalf("discovery with a trailing slash: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		setup func(m *mockIssuer)
+		want  string
+	}{
+		{"issuer mismatch", func(m *mockIssuer) { m.docIssuer = "https://evil.example.com" }, "document is for issuer"},
+		{"no S256", func(m *mockIssuer) { m.pkce = []string{"plain"} }, "PKCE with S256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := startIssuer(t)
+			tt.setup(m)
+			if err := CheckProvider(context.Background(), m.URL); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	if err := CheckProvider(context.Background(), srv.URL); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("provider without discovery: err = %v", err)
+	}
+}
+
+func TestCheckIssuer(t *testing.T) {
+	for issuer, ok := range map[string]bool{
+		"https://login.example.com/realms/x": true,
+		"http://localhost:8080":              true,
+		"http://127.0.0.1:5556/dex":          true,
+		"http://[::1]:5556":                  true,
+		"http://login.example.com":           false,
+		"login.example.com":                  false,
+		"ftp://127.0.0.1":                    false,
+	} {
+		if err := CheckIssuer(issuer); (err == nil) != ok {
+			t.Errorf("CheckIssuer(%q) = %v, want ok %v", issuer, err, ok)
+		}
+	}
+}
+
+func TestOIDCPKCE(t *testing.T) {
+	openTestDB(t)
+	m := startIssuer(t)
+	s := m.settings()
+
+	// A code issued for a different challenge fails the verifier check at the provider
+	state, code := m.authorize(t, s, m.claims("u-1", "alice", "sec-team"))
+	m.mu.Lock()
+	g := m.codes[code]
+	g.challenge = b64(make([]byte, 32))
+	m.codes[code] = g
+	m.mu.Unlock()
+
+	_, err := OIDCCallback(context.Background(), s, state, code)
+	if err == nil || !strings.Contains(err.Error(), "PKCE") {
+		t.Fatalf("err = %v, want the provider's PKCE failure", err)
+	}
+}
+
+func TestOIDCClientAuth(t *testing.T) {
+	openTestDB(t)
+
+	for i, tt := range []struct {
+		methods []string
+		want    string
+	}{
+		{nil, "basic:sentry:shh"},
+		{[]string{"client_secret_basic", "client_secret_post"}, "basic:sentry:shh"},
+		{[]string{"client_secret_post"}, "post:shh"},
+	} {
+		m := startIssuer(t)
+		m.authMethods = tt.methods
+		s := m.settings()
+		s.ClientSecret = "shh"
+
+		if _, err := m.login(t, s, m.claims("u-1", fmt.Sprintf("user%d", i), "sec-team")); err != nil {
+			t.Fatal(err)
+		}
+		if _, auth := m.fetches(); auth != tt.want {
+			t.Errorf("methods %v: client authenticated as %s, want %s", tt.methods, auth, tt.want)
+		}
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	openTestDB(t)
+	m := startIssuer(t)
+	s := m.settings()
+
+	if _, err := m.login(t, s, m.claims("u-1", "alice", "sec-team")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider rotates to a new key
+	m.mu.Lock()
+	m.published = map[string]*ecdsa.PrivateKey{"key-2": newECKey(t)}
+	m.signWith = "key-2"
+	m.mu.Unlock()
+
+	// Right after a fetch, an unknown kid is refused without hammering the provider
+	_, err := m.login(t, s, m.claims("u-1", "alice", "sec-team"))
+	if err == nil || !strings.Contains(err.Error(), `unknown signing key "key-2"`) {
+		t.Fatalf("err = %v, want the unknown key refused", err)
+	}
+	if n, _ := m.fetches(); n != 1 {
+		t.Fatalf("key set fetched %d times, want 1", n)
+	}
+
+	// Once the refetch interval passed, the unknown kid triggers a refetch
+	p, err := discover(context.Background(), m.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	p.keysFetched = time.Now().Add(-jwksMinInterval - time.Second)
+	p.mu.Unlock()
+
+	if _, err := m.login(t, s, m.claims("u-1", "alice", "sec-team")); err != nil {
+		t.Fatalf("login after rotation: %v", err)
+	}
+	if n, _ := m.fetches(); n != 2 {
+		t.Errorf("key set fetched %d times, want 2", n)
+	}
+
+	// Known keys are served from the cache
+	if _, err := m.login(t, s, m.claims("u-1", "alice", "sec-team")); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := m.fetches(); n != 2 {
+		t.Errorf("key set fetched %d times for a known kid", n)
+	}
+}
+
+func TestOIDCBadSignature(t *testing.T) {
+	openTestDB(t)
+
+	t.Run("tampered claims", func(t *testing.T) {
+		m := startIssuer(t)
+		m.tokenFunc = func(claims map[string]any) string {
+			token := m.sign(claims)
+			parts := strings.Split(token, ".")
+			claims["groups"] = []string{"sec-admins"}
+			return parts[0] + "." + jwtPart(claims) + "." + parts[2]
+		}
+		if _, err := m.login(t, m.settings(), m.claims("u-1", "alice", "sec-team")); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+			t.Fatalf("err = %v, want the signature rejected", err)
+		}
+	})
+
+	t.Run("unpublished key", func(t *testing.T) {
+		m := startIssuer(t)
+		// The kid matches a published key, the signature comes from another one
+		m.tokenFunc = func(claims map[string]any) string {
+			return m.signWithKey(newECKey(t), claims)
+		}
+		if _, err := m.login(t, m.settings(), m.claims("u-1", "alice", "sec-team")); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+			t.Fatalf("err = %v, want the signature rejected", err)
+		}
+	})
+}
+
+func TestOIDCAlgConfusion(t *testing.T) {
+	openTestDB(t)
+	m := startIssuer(t)
+	key := m.published["key-1"]
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(claims map[string]any) string{
+		// HMAC keyed with the provider's public key, which an attacker knows
+		"HS256": func(claims map[string]any) string {
+			signed := jwtPart(map[string]string{"alg": "HS256", "kid": "key-1"}) + "." + jwtPart(claims)
+			mac := hmac.New(sha256.New, pub)
+			mac.Write([]byte(signed))
+			return signed + "." + b64(mac.Sum(nil))
+		},
+		"none": func(claims map[string]any) string {
+			return jwtPart(map[string]string{"alg": "none", "kid": "key-1"}) + "." + jwtPart(claims) + "."
+		},
+		// An RS256 header on a key set that pins ES256
+		"RS256": func(claims map[string]any) string {
+			token := m.sign(claims)
+			parts := strings.Split(token, ".")
+			return jwtPart(map[string]string{"alg": "RS256", "kid": "key-1"}) + "." + parts[1] + "." + parts[2]
+		},
+	}
+
+	for alg, forge := range tests {
+		t.Run(alg, func(t *testing.T) {
+			m.tokenFunc = forge
+			_, err := m.login(t, m.settings(), m.claims("u-1", "mallory", "sec-admins"))
+			if err == nil {
+				t.Fatalf("%s token was accepted", alg)
+			}
+			if _, err := db.GetUserByName(db.DB, "mallory"); err == nil {
+				t.Fatal("forged token created a user")
+			}
+		})
+	}
+
+	// A key that does not pin an algorithm still only verifies RS256 and ES256
+	unpinned := verifyKey{key: &key.PublicKey}
+	for _, alg := range []string{"HS256", "none", ""} {
+		if err := verifySignature(alg, unpinned, "header.claims", pub); err == nil || !strings.Contains(err.Error(), "unsupported algorithm") {
+			t.Errorf("%q on an unpinned key: err = %v", alg, err)
+		}
+	}
+}
+
+func TestOIDCClaims(t *testing.T) {
+	openTestDB(t)
+	m := startIssuer(t)
+
+	tests := []struct {
+		name   string
+		mutate func(c map[string]any)
+		want   string
+	}{
+		{"wrong issuer", func(c map[string]any) { c["iss"] = "https://evil.example.com" }, "issued by"},
+		{"wrong audience", func(c map[string]any) { c["aud"] = "other-app" }, "not issued for this client"},
+		{"audience list without azp", func(c map[string]any) { c["aud"] = []string{"other-app", testClientID} }, "authorized party mismatch"},
+		{"wrong azp", func(c map[string]any) {
+			c["aud"] = []string{"other-app", testClientID}
+			c["azp"] = "other-app"
+		}, "authorized party mismatch"},
+		{"no subject", func(c map[string]any) { delete(c, "sub") }, "missing subject"},
+		{"wrong nonce", func(c map[string]any) { c["nonce"] = "replayed" }, "nonce mismatch"},
+		{"expired", func(c map[string]any) { c["exp"] = time.Now().Add(-oidcSkew - time.Minute).Unix() }, "expired"},
+		{"no expiry", func(c map[string]any) { delete(c, "exp") }, "expired"},
+		{"issued in the future", func(c map[string]any) { c["iat"] = time.Now().Add(oidcSkew + time.Hour).Unix() }, "issued in the future"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := m.claims("u-1", "alice", "sec-team")
+			tt.mutate(claims)
+			if _, err := m.login(t, m.settings(), claims); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	// Within the clock skew and with a matching azp, the token is fine
+	claims := m.claims("u-1", "alice", "sec-team")
+	claims["exp"] = time.Now().Add(-oidcSkew / 2).Unix()
+	claims["aud"] = []string{"other-app", testClientID}
+	claims["azp"] = testClientID
+	if _, err := m.login(t, m.settings(), claims); err != nil {
+		t.Fatalf("token within the skew: %v", err)
+	}
+}
+
+func TestOIDCState(t *testing.T) {
+	openTestDB(t)
+	m := startIssuer(t)
+	s := m.settings()
+
+	state, code := m.authorize(t, s, m.claims("u-1", "alice", "sec-team"))
+	if _, err := OIDCCallback(context.Background(), s, state, code); err != nil {
+		t.Fatal(err)
+	}
+
+	// A state is good for one callback only
+	if _, err := OIDCCallback(context.Background(), s, state, code); err != ErrOIDCState {
+		t.Errorf("replayed state: err = %v, want ErrOIDCState", err)
+	}
+	if _, err := OIDCCallback(context.Background(), s, "never-issued", code); err != ErrOIDCState {
+		t.Errorf("unknown state: err = %v, want ErrOIDCState", err)
+	}
+
+	// A login left at the provider's page for too long expires
+	state, code = m.authorize(t, s, m.claims("u-1", "alice", "sec-team"))
+	pending.Lock()
+	login := pending.logins[state]
+	login.expires = time.Now().Add(-time.Second)
+	pending.logins[state] = login
+	pending.Unlock()
+	if _, err := OIDCCallback(context.Background(), s, state, code); err != ErrOIDCState {
+		t.Errorf("expired login: err = %v, want ErrOIDCState", err)
+	}
+
+	// Each login has its own verifier, so a code issued to one login fails another
+	_, code1 := m.authorize(t, s, m.claims("u-1", "alice", "sec-team"))
+	state2, _ := m.authorize(t, s, m.claims("u-1", "alice", "sec-team"))
+	if _, err := OIDCCallback(context.Background(), s, state2, code1); err == nil || !strings.Contains(err.Error(), "PKCE") {
+		t.Errorf("code of another login: err = %v, want the PKCE check to fail", err)
+	}
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	s := models.OIDCSettings{}
+	if _, _, err := OIDCAuthURL(context.Background(), s); err != ErrOIDCDisabled {
+		t.Errorf("OIDCAuthURL: err = %v", err)
+	}
+	if _, err := OIDCCallback(context.Background(), s, "state", "code"); err != ErrOIDCDisabled {
+		t.Errorf("OIDCCallback: err = %v", err)
+	}
+}
+
+func TestMapRole(t *testing.T) {
+	s := models.OIDCSettings{
+		RoleClaim: "groups",
+		RoleMappings: map[string]string{
+			"sec-team":   models.RoleViewer,
+			"sec-ops":    models.RoleOperator,
+			"sec-admins": models.RoleAdmin,
+		},
+	}
+
+	tests := []struct {
+		claim       any
+		defaultRole string
+		want        string
+	}{
+		{[]any{"sec-team", "sec-admins", "sec-ops"}, "", models.RoleAdmin},
+		{[]any{"sec-team", "unrelated"}, "", models.RoleViewer},
+		{"sec-ops", "", models.RoleOperator},
+		{[]any{"unrelated"}, models.RoleViewer, models.RoleViewer},
+		{[]any{"unrelated"}, "", ""},
+		{nil, "", ""},
+		{[]any{42, "sec-ops"}, "", models.RoleOperator},
+	}
+	for _, tt := range tests {
+		s.DefaultRole = tt.defaultRole
+		claims := map[string]any{}
+		if tt.claim != nil {
+			claims["groups"] = tt.claim
+		}
+		if got := MapRole(s, claims); got != tt.want {
+			t.Errorf("MapRole(%v, default %q) = %q, want %q", tt.claim, tt.defaultRole, got, tt.want)
+		}
+	}
+}
+
+func TestOIDCRoleFollowsProvider(t *testing.T) {
+	openTestDB(t)
+	m := startIssuer(t)
+	s := m.settings()
+
+	u, err := m.login(t, s, m.claims("u-1", "alice", "sec-team"))
+	if err != nil || u.Role != models.RoleViewer {
+		t.Fatalf("first login: %s, err %v", u.Role, err)
+	}
+
+	u, err = m.login(t, s, m.claims("u-1", "alice", "sec-admins"))
+	if err != nil || u.Role != models.RoleAdmin {
+		t.Fatalf("after joining sec-admins: %s, err %v", u.Role, err)
+	}
+	if stored, _ := db.GetUser(db.DB, u.ID); stored.Role != models.RoleAdmin {
+		t.Errorf("stored role = %s", stored.Role)
+	}
+
+	// Losing every mapped group locks the account out rather than keeping the old role
+	if _, err := m.login(t, s, m.claims("u-1", "alice")); err != ErrNoRole {
+		t.Errorf("without groups: err = %v, want ErrNoRole", err)
+	}
+}
+
+func TestOIDCLocalUsernameTakeover(t *testing.T) {
+	openTestDB(t)
+	m := startIssuer(t)
+	s := m.settings()
+
+	if _, err := db.CreateUser(db.DB, "admin", models.RoleAdmin, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Anyone who can pick their preferred_username at the provider must not become the local admin
+	_, err := m.login(t, s, m.claims("attacker", "admin", "sec-team"))
+	if err != ErrUsernameTaken {
+		t.Fatalf("err = %v, want ErrUsernameTaken", err)
+	}
+	local, _ := db.GetUserByName(db.DB, "admin")
+	if local.Role != models.RoleAdmin {
+		t.Errorf("local admin's role changed to %s", local.Role)
+	}
+
+	// The same holds for an SSO account of another subject or issuer
+	if _, err := m.login(t, s, m.claims("u-1", "alice", "sec-team")); err != nil {
+		t.Fatal(err)
+	}
+	other := startIssuer(t)
+	if _, err := other.login(t, other.settings(), other.claims("u-1", "alice", "sec-team")); err != ErrUsernameTaken {
+		t.Errorf("same username from another issuer: err = %v, want ErrUsernameTaken", err)
+	}
+}
+
+func TestOIDCUsernameClaim(t *testing.T) {
+	openTestDB(t)
+	m := startIssuer(t)
+
+	for _, name := range []any{"", "two words", strings.Repeat("a", 65), 42} {
+		claims := m.claims("u-1", "", "sec-team")
+		claims["preferred_username"] = name
+		if _, err := m.login(t, m.settings(), claims); err == nil || !strings.Contains(err.Error(), "no usable preferred_username") {
+			t.Errorf("username %v: err = %v", name, err)
+		}
+	}
+}
+
+func TestVerifyRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, _ := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA", "kid": "rsa", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	keys, err := parseJWKS(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := jwtPart(map[string]string{"alg": "RS256", "kid": "rsa"}) + "." + jwtPart(map[string]any{"sub": "u-1"})
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifySignature("RS256", keys["rsa"], signed, sig); err != nil {
+		t.Errorf("valid RS256 signature: %v", err)
+	}
+	if err := verifySignature("ES256", keys["rsa"], signed, sig); err == nil {
+		t.Error("RSA key verified an ES256 token")
+	}
+	sig[0] ^= 0xff
+	if err := verifySignature("RS256", keys["rsa"], signed, sig); err == nil {
+		t.Error("corrupted RS256 signature verified")
+	}
+}
+
+func TestParseJWKSSkipsUnusableKeys(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec := newECKey(t)
+
+	set, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "weak", "n": b64(weak.N.Bytes()), "e": "AQAB"},
+		{"kty": "EC", "kid": "enc", "use": "enc", "crv": "P-256", "x": b64(ec.X.FillBytes(make([]byte, 32))), "y": b64(ec.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AA", "y": "AA"},
+		{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+		{"kty": "EC", "kid": "ok", "crv": "P-256", "x": b64(ec.X.FillBytes(make([]byte, 32))), "y": b64(ec.Y.FillBytes(make([]byte, 32)))},
+	}})
+
+	keys, err := parseJWKS(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys["ok"].key == nil {
+		t.Errorf("parsed keys %v, want only the P-256 signing key", keys)
+	}
+
+	if _, err := parseJWKS([]byte(`{"keys": [{"kty": "oct", "kid": "hmac"}]}`)); err == nil {
+		t.Error("key set without usable keys was accepted")
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MinPasswordLength = 12
+	// bcrypt ignores everything past 72 bytes, reject instead of silently truncating
+	MaxPasswordLength = 72
+	bcryptCost        = 12
+)
+
+var ErrWeakPassword = fmt.Errorf("password must be %d to %d characters long", MinPasswordLength, MaxPasswordLength)
+
+// dummyHash is compared against for unknown users, so a login for a
+// missing account takes as long as one with a wrong password
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("sentry-timing-equalizer"), bcryptCost)
+
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return ErrWeakPassword
+	}
+	return nil
+}
+
+func HashPassword(password string) (string, error) {
+	if err := ValidatePassword(password); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	return string(hash), err
+}
+
+// CheckPassword compares in constant time; an empty hash still costs a full comparison
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+var roleRank = map[string]int{
+	models.RoleViewer:   1,
+	models.RoleOperator: 2,
+	models.RoleAdmin:    3,
+}
+
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// HasRole reports whether u has at least the given role
+func HasRole(u models.User, role string) bool {
+	return roleRank[u.Role] >= roleRank[role]
+}
+
+type routeRule struct {
+	method string // empty matches any method
+	prefix string
+	role   string // empty means public
+}
+
+// routePolicy is checked top to bottom, the first match decides. Anything not
+// listed falls through to admin, so new endpoints are locked down by default.
+var routePolicy = []routeRule{
+	{"", "/api/setup", ""},
+	{"", "/api/auth/login", ""},
+	{"", "/api/auth/oidc/settings", models.RoleAdmin},
+	{"", "/api/auth/oidc", ""}, // SSO status, redirect and callback
+
+	// Everyone manages their own sessions and two-factor
+	{"", "/api/auth/", models.RoleViewer},
+
+	// Reads that expose accounts, notifier settings or the server configuration
+	{http.MethodGet, "/api/users", models.RoleAdmin},
+	{http.MethodGet, "/api/forbidden", models.RoleAdmin},
+	{http.MethodGet, "/api/audit", models.RoleAdmin},
+	{http.MethodGet, "/api/system/", models.RoleAdmin},
+	{http.MethodGet, "/api/notifiers", models.RoleAdmin},
+	{http.MethodGet, "/api/notifications/smtp", models.RoleAdmin},
+
+	// Actions, on every method so a read handler added later can't reopen them
+	{"", "/api/scan/run", models.RoleOperator},
+	{"", "/api/feeds/refresh", models.RoleOperator},
+
+	{http.MethodGet, "/api/", models.RoleViewer},
+
+	// Everyone manages their own tokens, the handlers cap scopes at the caller's role
+	{"", "/api/tokens", models.RoleViewer},
+
+	{"", "/api/suppressions", models.RoleOperator},
+	{"", "/api/assets", models.RoleOperator},
+
+	{"", "/api/", models.RoleAdmin},
+}
+
+// RequiredRole returns the minimum role for a request, or "" for public routes
+func RequiredRole(method, path string) string {
+	for _, rule := range routePolicy {
+		if (rule.method == "" || rule.method == method) && strings.HasPrefix(path, rule.prefix) {
+			return rule.role
+		}
+	}
+	return models.RoleAdmin
+}
+
+// Authorize enforces routePolicy; it expects Middleware to have authenticated the request
+func Authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := RequiredRole(r.Method, r.URL.Path)
+		if role == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u, ok := UserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !HasRole(u, role) {
+			recordForbidden(u, r)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func recordForbidden(u models.User, r *http.Request) {
+	log.Printf("Forbidden: %s (%s) %s %s from %s", u.Username, u.Role, r.Method, r.URL.Path, r.RemoteAddr)
+
+	err := db.RecordForbidden(db.DB, models.ForbiddenAttempt{
+		UserID:     u.ID,
+		Username:   u.Username,
+		Role:       u.Role,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RemoteAddr: r.RemoteAddr,
+	})
+	if err != nil {
+		log.Printf("Failed to record forbidden attempt: %v", err)
+	}
+
+	audit.Request(r, u, audit.AccessDenied, r.Method+" "+r.URL.Path, map[string]string{"role": u.Role})
+}
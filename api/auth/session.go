@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+const (
+	SessionCookie = "sentry_session"
+	CSRFHeader    = "X-CSRF-Token"
+
+	// A session ends after sessionIdle without requests, and sessionMaxAge after login regardless
+	sessionIdle   = 30 * time.Minute
+	sessionMaxAge = 12 * time.Hour
+)
+
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+type sessionKey struct{}
+
+// SessionFrom returns the session of a request authenticated by cookie
+func SessionFrom(ctx context.Context) (models.Session, bool) {
+	s, ok := ctx.Value(sessionKey{}).(models.Session)
+	return s, ok
+}
+
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Login checks a password and, for users with two-factor enabled, a TOTP or recovery code.
+// Attempts from ip are throttled, see CheckLogin.
+func Login(ip, username, password, code string) (models.User, error) {
+	if err := CheckLogin(ip, username); err != nil {
+		return models.User{}, err
+	}
+
+	u, err := db.GetUserByName(db.DB, username)
+	if err != nil {
+		CheckPassword("", password)
+		return models.User{}, failLogin(ip, username, ErrInvalidCredentials)
+	}
+	if !CheckPassword(u.PasswordHash, password) {
+		return models.User{}, failLogin(ip, username, ErrInvalidCredentials)
+	}
+
+	if u.TOTPEnabled {
+		err := VerifySecondFactor(u, code)
+		if errors.Is(err, ErrInvalidCode) {
+			return models.User{}, failLogin(ip, username, err)
+		}
+		if err != nil {
+			return models.User{}, err
+		}
+	}
+
+	LoginSucceeded(username)
+	return u, nil
+}
+
+// failLogin counts a failed attempt and passes err through
+func failLogin(ip, username string, err error) error {
+	if LoginFailed(ip, username) {
+		log.Printf("Login locked for %s from %s after repeated failures", username, ip)
+		audit.Record(models.AuditEntry{
+			Action:     audit.LockedOut,
+			Target:     "user:" + username,
+			RemoteAddr: ip,
+		})
+	}
+	return err
+}
+
+// StartSession stores a new session for u and sets its cookie on w
+func StartSession(w http.ResponseWriter, r *http.Request, u models.User) (models.Session, error) {
+	token, err := randomToken()
+	if err != nil {
+		return models.Session{}, err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return models.Session{}, err
+	}
+
+	now := time.Now()
+	s := models.Session{
+		UserID:     u.ID,
+		Username:   u.Username,
+		IP:         audit.RemoteIP(r),
+		UserAgent:  truncate(r.UserAgent(), 256),
+		LastSeenAt: now.UTC().Format(time.DateTime),
+		ExpiresAt:  now.Add(sessionMaxAge).UTC().Format(time.DateTime),
+		CSRFToken:  csrf,
+		Current:    true,
+	}
+
+	if s.ID, err = db.CreateSession(db.DB, s, HashToken(token)); err != nil {
+		return models.Session{}, err
+	}
+
+	if err := db.PruneSessions(db.DB, sessionIdle, now); err != nil {
+		log.Printf("Failed to prune expired sessions: %v", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  now.Add(sessionMaxAge),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return s, nil
+}
+
+// ClearSessionCookie tells the browser to drop its session cookie
+func ClearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// AuthenticateSession resolves a session cookie to its user and slides the idle timeout
+func AuthenticateSession(token string) (models.User, models.Session, bool) {
+	now := time.Now()
+	s, err := db.GetSessionByHash(db.DB, HashToken(token), sessionIdle, now)
+	if err != nil {
+		return models.User{}, models.Session{}, false
+	}
+
+	u, err := db.GetUser(db.DB, s.UserID)
+	if err != nil {
+		return models.User{}, models.Session{}, false
+	}
+
+	if err := db.TouchSession(db.DB, s.ID, now); err != nil {
+		log.Printf("Failed to update session last use: %v", err)
+	}
+	s.Current = true
+	return u, s, true
+}
+
+// safeMethod requests do not change state and need no CSRF token
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func validCSRF(s models.Session, r *http.Request) bool {
+	token := r.Header.Get(CSRFHeader)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) == 1
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failed logins are tracked per client IP and per account. After a few
+// failures every further attempt has to wait progressively longer, and
+// enough failures lock the IP or account out for a while.
+const (
+	freeAttempts    = 3
+	maxDelay        = 30 * time.Second
+	accountLockout  = 10 // failures before an account is locked
+	ipLockout       = 30 // an IP may try several accounts before it is locked
+	lockoutDuration = 15 * time.Minute
+	failureWindow   = 15 * time.Minute // failures older than this are forgotten
+)
+
+// LockedError rejects a login attempt made before RetryAfter has passed
+type LockedError struct {
+	RetryAfter time.Duration
+	Locked     bool // locked out rather than just slowed down
+}
+
+func (e *LockedError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("too many failed logins, locked for %s", e.RetryAfter.Round(time.Second))
+	}
+	return "too many failed logins, slow down"
+}
+
+type failures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// next is when the following attempt is allowed
+func (f *failures) next() (time.Time, bool) {
+	if f.lockedUntil.After(f.last) {
+		return f.lockedUntil, true
+	}
+	if f.count < freeAttempts {
+		return time.Time{}, false
+	}
+
+	delay := time.Second << min(f.count-freeAttempts, 5)
+	return f.last.Add(min(delay, maxDelay)), false
+}
+
+var throttle = struct {
+	sync.Mutex
+	entries map[string]*failures
+	swept   time.Time
+}{entries: map[string]*failures{}}
+
+func throttleKeys(ip, username string) (string, string) {
+	return "ip:" + ip, "user:" + strings.ToLower(username)
+}
+
+// CheckLogin returns a *LockedError when ip or username must wait before trying again
+func CheckLogin(ip, username string) error {
+	now := time.Now()
+	ipKey, userKey := throttleKeys(ip, username)
+
+	throttle.Lock()
+	defer throttle.Unlock()
+
+	var wait time.Duration
+	locked := false
+	for _, key := range []string{ipKey, userKey} {
+		f, ok := throttle.entries[key]
+		if !ok {
+			continue
+		}
+		if at, l := f.next(); at.Sub(now) > wait {
+			wait, locked = at.Sub(now), l
+		}
+	}
+
+	if wait > 0 {
+		return &LockedError{RetryAfter: wait, Locked: locked}
+	}
+	return nil
+}
+
+// LoginFailed counts a failure and reports whether it just locked the account or IP
+func LoginFailed(ip, username string) (lockedOut bool) {
+	now := time.Now()
+	ipKey, userKey := throttleKeys(ip, username)
+
+	throttle.Lock()
+	defer throttle.Unlock()
+
+	if now.Sub(throttle.swept) > time.Minute {
+		for k, f := range throttle.entries {
+			if now.Sub(f.last) > failureWindow && now.After(f.lockedUntil) {
+				delete(throttle.entries, k)
+			}
+		}
+		throttle.swept = now
+	}
+
+	for key, limit := range map[string]int{ipKey: ipLockout, userKey: accountLockout} {
+		f, ok := throttle.entries[key]
+		if !ok || (now.Sub(f.last) > failureWindow && now.After(f.lockedUntil)) {
+			f = &failures{}
+			throttle.entries[key] = f
+		}
+
+		f.count++
+		f.last = now
+		if f.count >= limit && now.After(f.lockedUntil) {
+			f.lockedUntil = now.Add(lockoutDuration)
+			f.count = 0
+			lockedOut = true
+		}
+	}
+	return lockedOut
+}
+
+// LoginSucceeded clears the account's failures. The IP keeps its count, so one
+// valid account does not let an attacker keep guessing at the others.
+func LoginSucceeded(username string) {
+	_, userKey := throttleKeys("", username)
+
+	throttle.Lock()
+	delete(throttle.entries, userKey)
+	throttle.Unlock()
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+// TokenPrefix marks sentry API tokens so they are easy to spot in leaked secrets
+const TokenPrefix = "snt_"
+
+var scopeRole = map[string]string{
+	models.ScopeRead:  models.RoleViewer,
+	models.ScopeScan:  models.RoleOperator,
+	models.ScopeAdmin: models.RoleAdmin,
+}
+
+func ValidScope(scope string) bool {
+	_, ok := scopeRole[scope]
+	return ok
+}
+
+// ScopeRole is the highest role granted by a set of scopes
+func ScopeRole(scopes []string) string {
+	role := ""
+	for _, s := range scopes {
+		if r, ok := scopeRole[s]; ok && roleRank[r] > roleRank[role] {
+			role = r
+		}
+	}
+	return role
+}
+
+// GenerateToken returns a new random token and the hash to store for it
+func GenerateToken() (token, hash string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token = TokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashToken(token), nil
+}
+
+// HashToken is a plain SHA-256: tokens are random, so unlike passwords they need no slow hash
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuthenticateToken resolves a bearer token to its owner, with the role capped by the token scopes
+func AuthenticateToken(token string) (models.User, bool) {
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return models.User{}, false
+	}
+
+	now := time.Now()
+	t, err := db.GetTokenByHash(db.DB, HashToken(token), now)
+	if err != nil {
+		return models.User{}, false
+	}
+
+	u, err := db.GetUser(db.DB, t.UserID)
+	if err != nil {
+		return models.User{}, false
+	}
+
+	if role := ScopeRole(t.Scopes); roleRank[role] < roleRank[u.Role] {
+		u.Role = role
+	}
+
+	if err := db.TouchToken(db.DB, t.ID, now); err != nil {
+		log.Printf("Failed to update token last use: %v", err)
+	}
+	return u, true
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+// RFC 6238 parameters understood by every authenticator app
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 // accept one step either side for clock drift
+	totpIssuer = "Sentry"
+
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrTOTPRequired = errors.New("two-factor code required")
+	ErrInvalidCode  = errors.New("invalid two-factor code")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new 160-bit base32 secret
+func GenerateTOTPSecret() (string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(raw), nil
+}
+
+// TOTPURL is the otpauth:// link authenticator apps import, usually as a QR code
+func TOTPURL(username, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%1_000_000)
+}
+
+// matchTOTP returns the time step code is valid for around now
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// ConfirmTOTP checks a code against a pending secret and returns its time step
+func ConfirmTOTP(secret, code string) (int64, bool) {
+	return matchTOTP(secret, normalizeCode(code), time.Now())
+}
+
+// VerifySecondFactor accepts a current TOTP code or an unused recovery code.
+// Each TOTP code is accepted once, so an observed code cannot be replayed.
+func VerifySecondFactor(u models.User, code string) error {
+	code = normalizeCode(code)
+	if code == "" {
+		return ErrTOTPRequired
+	}
+
+	secret, enabled, lastStep, err := db.GetTOTP(db.DB, u.ID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	if step, ok := matchTOTP(secret, code, time.Now()); ok {
+		if step <= lastStep {
+			return ErrInvalidCode
+		}
+		fresh, err := db.UseTOTPStep(db.DB, u.ID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+
+	used, err := db.UseRecoveryCode(db.DB, u.ID, HashToken(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// GenerateRecoveryCodes returns codes formatted xxxxx-xxxxx and the hashes to store
+func GenerateRecoveryCodes() (codes, hashes []string, err error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz023456789" // 32 symbols, no i, l, o or 1
+
+	for range recoveryCodeCount {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+
+		var b strings.Builder
+		for i, c := range raw {
+			if i == 5 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(alphabet[int(c)%len(alphabet)])
+		}
+
+		code := b.String()
+		codes = append(codes, code)
+		hashes = append(hashes, HashToken(normalizeCode(code)))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeCode tolerates the spaces and dashes people type into codes
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code)))
+}
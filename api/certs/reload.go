@@ -0,0 +1,114 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	pollInterval  = 30 * time.Second
+	renewInterval = time.Hour
+)
+
+// Source serves a certificate loaded from files, reloading it when they change
+type Source struct {
+	certFile, keyFile string
+	selfSignedDir     string // set when the certificate is generated
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// FromFiles serves a configured certificate and key
+func FromFiles(certFile, keyFile string) (*Source, error) {
+	s := &Source{certFile: certFile, keyFile: keyFile}
+	return s, s.load()
+}
+
+// SelfSigned serves a certificate generated in dir by EnsureSelfSigned
+func SelfSigned(dir string) (*Source, error) {
+	certFile, keyFile, err := EnsureSelfSigned(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Source{certFile: certFile, keyFile: keyFile, selfSignedDir: dir}
+	return s, s.load()
+}
+
+func (s *Source) modified() time.Time {
+	var latest time.Time
+	for _, f := range []string{s.certFile, s.keyFile} {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (s *Source) load() error {
+	modTime := s.modified()
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.cert, s.modTime = &cert, modTime
+	s.mu.Unlock()
+	return nil
+}
+
+// TLSConfig uses the current certificate for every handshake
+func (s *Source) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return s.cert, nil
+		},
+	}
+}
+
+// Watch reloads the certificate when its files change, and keeps a generated
+// certificate renewed, until ctx is done. A bad file keeps the old certificate.
+func (s *Source) Watch(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		lastRenew := time.Now()
+
+		for {
+			select {
+			case <-ticker.C:
+				if s.selfSignedDir != "" && time.Since(lastRenew) > renewInterval {
+					lastRenew = time.Now()
+					if _, _, err := EnsureSelfSigned(s.selfSignedDir); err != nil {
+						log.Printf("Certificate renewal failed: %v", err)
+					}
+				}
+
+				s.mu.RLock()
+				changed := !s.modified().Equal(s.modTime)
+				s.mu.RUnlock()
+				if !changed {
+					continue
+				}
+
+				if err := s.load(); err != nil {
+					log.Printf("Certificate reload failed, keeping the current one: %v", err)
+					continue
+				}
+				log.Printf("Reloaded TLS certificate from %s", s.certFile)
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,232 @@
+// Package certs provides the HTTPS certificate: configured files, or a local CA
+// and server certificate generated on first start, reloaded when they change.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	caValidity     = 10 * 365 * 24 * time.Hour
+	serverValidity = 397 * 24 * time.Hour // the longest lifetime browsers accept
+	renewBefore    = 30 * 24 * time.Hour
+)
+
+// Files generated in the certificate directory. Trust ca.pem in browsers to
+// get rid of the certificate warning; ca-key.pem never leaves the server.
+const (
+	CAFile     = "ca.pem"
+	CAKeyFile  = "ca-key.pem"
+	CertFile   = "server.pem"
+	KeyFile    = "server-key.pem"
+	commonName = "Sentry"
+)
+
+// localNames are the SANs for this machine: its hostname, localhost and every interface address
+func localNames() (dns []string, ips []net.IP) {
+	dns = []string{"localhost"}
+	if host, err := os.Hostname(); err == nil && host != "localhost" {
+		dns = append(dns, host)
+	}
+
+	ips = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Printf("Failed to list interface addresses for the certificate: %v", err)
+		return dns, ips
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipnet.IP)
+	}
+	return dns, ips
+}
+
+// EnsureSelfSigned makes sure dir holds a CA and a current server certificate
+// signed by it. The server certificate is reissued when it nears expiry or the
+// machine gained an address it does not cover; the CA is kept so browsers that
+// trust it keep working.
+func EnsureSelfSigned(dir string) (certFile, keyFile string, err error) {
+	certFile, keyFile = filepath.Join(dir, CertFile), filepath.Join(dir, KeyFile)
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", err
+	}
+
+	ca, caKey, err := loadCA(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		ca, caKey, err = createCA(dir)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("certificate authority: %v", err)
+	}
+
+	dns, ips := localNames()
+	if current, err := readCert(certFile); err == nil && covers(current, dns, ips) &&
+		time.Until(current.NotAfter) > renewBefore && current.CheckSignatureFrom(ca) == nil {
+		return certFile, keyFile, nil
+	}
+
+	if err := createServerCert(certFile, keyFile, ca, caKey, dns, ips); err != nil {
+		return "", "", fmt.Errorf("server certificate: %v", err)
+	}
+	log.Printf("Issued self-signed server certificate for %v %v", dns, ips)
+	return certFile, keyFile, nil
+}
+
+func covers(cert *x509.Certificate, dns []string, ips []net.IP) bool {
+	for _, name := range dns {
+		if !slices.Contains(cert.DNSNames, name) {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if !slices.ContainsFunc(cert.IPAddresses, ip.Equal) {
+			return false
+		}
+	}
+	return true
+}
+
+func serial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func createCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	sn, err := serial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	host, _ := os.Hostname()
+	tmpl := &x509.Certificate{
+		SerialNumber:          sn,
+		Subject:               pkix.Name{CommonName: commonName + " local CA " + host, Organization: []string{commonName}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeKeyPEM(filepath.Join(dir, CAKeyFile), key); err != nil {
+		return nil, nil, err
+	}
+	if err := writeCertPEM(filepath.Join(dir, CAFile), der); err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("Created local certificate authority %s", filepath.Join(dir, CAFile))
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+func loadCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	cert, err := readCert(filepath.Join(dir, CAFile))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, CAKeyFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("invalid CA key")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	return cert, key, err
+}
+
+func createServerCert(certFile, keyFile string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, dns []string, ips []net.IP) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	sn, err := serial()
+	if err != nil {
+		return err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: sn,
+		Subject:      pkix.Name{CommonName: dns[len(dns)-1], Organization: []string{commonName}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(serverValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     dns,
+		IPAddresses:  ips,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+
+	// Key first: a reload that sees the new certificate must find its key
+	if err := writeKeyPEM(keyFile, key); err != nil {
+		return err
+	}
+	return writeCertPEM(certFile, der)
+}
+
+func readCert(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func writeKeyPEM(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+func writeCertPEM(path string, der []byte) error {
+	return writeFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+// writeFile replaces path atomically so a concurrent reload never reads half a file
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,373 @@
+// Package config loads the server settings. Each setting starts from its
+// default and is overridden in turn by the YAML file, the environment and
+// command line flags.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFile is read when it exists and no other file is given
+const DefaultFile = "sentry.yaml"
+
+const redacted = "********"
+
+type TLS struct {
+	Enabled      bool   `yaml:"enabled" json:"enabled"`
+	Cert         string `yaml:"cert" json:"cert"`
+	Key          string `yaml:"key" json:"key"`
+	CertDir      string `yaml:"cert_dir" json:"cert_dir"`
+	RedirectAddr string `yaml:"redirect_addr" json:"redirect_addr"`
+}
+
+// CORS lists the other origins allowed to call the API from a browser; none by default
+type CORS struct {
+	AllowedOrigins   []string `yaml:"allowed_origins" json:"allowed_origins"`
+	AllowCredentials bool     `yaml:"allow_credentials" json:"allow_credentials"`
+	AllowedMethods   []string `yaml:"allowed_methods" json:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers" json:"allowed_headers"`
+	MaxAge           int      `yaml:"max_age" json:"max_age"`
+}
+
+// Metrics configures /metrics; with a token set scrapers must send it as a Bearer token
+type Metrics struct {
+	Enabled bool   `yaml:"enabled" json:"enabled"`
+	Token   string `yaml:"token" json:"token"`
+}
+
+// SMTP is the fallback mail server, used when the one saved in the UI has no host or credentials
+type SMTP struct {
+	Host     string `yaml:"host" json:"host"`
+	Port     int    `yaml:"port" json:"port"`
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+}
+
+// Admin is the first account, created when the database has no users
+type Admin struct {
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+}
+
+type Config struct {
+	File      string `yaml:"-" json:"file"`
+	Addr      string `yaml:"addr" json:"addr"`
+	DBPath    string `yaml:"db_path" json:"db_path"`
+	StaticDir string `yaml:"static_dir" json:"static_dir"`
+	FeedsDir  string `yaml:"feeds_dir" json:"feeds_dir"`
+	NmapPath  string `yaml:"nmap_path" json:"nmap_path"`
+	// Seconds running scans and requests get to finish on shutdown
+	ShutdownTimeout int     `yaml:"shutdown_timeout" json:"shutdown_timeout"`
+	TLS             TLS     `yaml:"tls" json:"tls"`
+	CORS            CORS    `yaml:"cors" json:"cors"`
+	Metrics         Metrics `yaml:"metrics" json:"metrics"`
+	SMTP            SMTP    `yaml:"smtp" json:"smtp"`
+	Admin           Admin   `yaml:"admin" json:"admin"`
+}
+
+// Current is the configuration the server was started with
+var Current = Defaults()
+
+func Defaults() *Config {
+	return &Config{
+		DBPath:          "./results.db",
+		StaticDir:       "./web/dist",
+		FeedsDir:        "./feeds",
+		NmapPath:        "nmap",
+		ShutdownTimeout: 30,
+		TLS:             TLS{Enabled: true, CertDir: "./tls", RedirectAddr: ":8080"},
+		CORS: CORS{
+			AllowedOrigins: []string{},
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-CSRF-Token"},
+			MaxAge:         600,
+		},
+		Metrics: Metrics{Enabled: true},
+		SMTP:    SMTP{Port: 587},
+		Admin:   Admin{Username: "admin"},
+	}
+}
+
+// setting ties a config field to its environment variables and flag. Secrets
+// have no flag so they don't show up in the process list.
+type setting struct {
+	key   string
+	env   []string
+	flag  string
+	usage string
+	value any // *string, *int, *bool or *[]string (comma-separated)
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"addr", []string{"SENTRY_ADDR"}, "addr", "listen address (default :8443, or :8080 without TLS)", &c.Addr},
+		{"db_path", []string{"SENTRY_DB"}, "db", "SQLite database file", &c.DBPath},
+		{"static_dir", []string{"SENTRY_STATIC_DIR"}, "static", "directory with the built web UI", &c.StaticDir},
+		{"feeds_dir", []string{"SENTRY_FEEDS_DIR"}, "feeds", "directory watched for KEV and EPSS feeds", &c.FeedsDir},
+		{"nmap_path", []string{"SENTRY_NMAP"}, "nmap", "nmap binary", &c.NmapPath},
+		{"shutdown_timeout", []string{"SENTRY_SHUTDOWN_TIMEOUT"}, "shutdown-timeout", "seconds to let running scans finish on shutdown", &c.ShutdownTimeout},
+		{"tls.enabled", []string{"SENTRY_TLS"}, "tls", "serve HTTPS", &c.TLS.Enabled},
+		{"tls.cert", []string{"SENTRY_TLS_CERT"}, "tls-cert", "PEM certificate chain, self-signed when empty", &c.TLS.Cert},
+		{"tls.key", []string{"SENTRY_TLS_KEY"}, "tls-key", "PEM private key for tls-cert", &c.TLS.Key},
+		{"tls.cert_dir", []string{"SENTRY_CERT_DIR"}, "cert-dir", "directory for the self-signed CA and certificate", &c.TLS.CertDir},
+		{"tls.redirect_addr", []string{"SENTRY_HTTP_REDIRECT_ADDR"}, "redirect-addr", "HTTP to HTTPS redirect listener, off to disable", &c.TLS.RedirectAddr},
+		{"cors.allowed_origins", []string{"SENTRY_CORS_ORIGINS"}, "cors-origins", "comma-separated origins allowed to call the API", &c.CORS.AllowedOrigins},
+		{"cors.allow_credentials", []string{"SENTRY_CORS_CREDENTIALS"}, "cors-credentials", "let allowed origins send credentials", &c.CORS.AllowCredentials},
+		{"cors.allowed_methods", []string{"SENTRY_CORS_METHODS"}, "cors-methods", "comma-separated methods allowed cross-origin", &c.CORS.AllowedMethods},
+		{"cors.allowed_headers", []string{"SENTRY_CORS_HEADERS"}, "cors-headers", "comma-separated request headers allowed cross-origin", &c.CORS.AllowedHeaders},
+		{"cors.max_age", []string{"SENTRY_CORS_MAX_AGE"}, "cors-max-age", "seconds browsers may cache a preflight", &c.CORS.MaxAge},
+		{"metrics.enabled", []string{"SENTRY_METRICS"}, "metrics", "serve Prometheus metrics at /metrics", &c.Metrics.Enabled},
+		{"metrics.token", []string{"SENTRY_METRICS_TOKEN"}, "", "", &c.Metrics.Token},
+		{"smtp.host", []string{"SENTRY_SMTP_HOST"}, "smtp-host", "fallback SMTP host", &c.SMTP.Host},
+		{"smtp.port", []string{"SENTRY_SMTP_PORT"}, "smtp-port", "fallback SMTP port", &c.SMTP.Port},
+		{"smtp.username", []string{"SENTRY_SMTP_USERNAME", "EMAIL"}, "smtp-username", "fallback SMTP username", &c.SMTP.Username},
+		{"smtp.password", []string{"SENTRY_SMTP_PASSWORD", "EMAIL_PASS"}, "", "", &c.SMTP.Password},
+		{"admin.username", []string{"SENTRY_ADMIN_USER"}, "admin-user", "first admin account name", &c.Admin.Username},
+		{"admin.password", []string{"SENTRY_ADMIN_PASSWORD"}, "", "", &c.Admin.Password},
+	}
+}
+
+// Load builds the configuration from the defaults, the config file, the
+// environment and args, then validates it
+func Load(args []string) (*Config, error) {
+	c := Defaults()
+	settings := c.settings()
+
+	type override struct {
+		s   setting
+		raw string
+	}
+	var flags []override
+
+	fs := flag.NewFlagSet("sentry", flag.ContinueOnError)
+	file := fs.String("config", "", "YAML config file (default "+DefaultFile+" when present)")
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		s := s
+		set := func(raw string) error {
+			flags = append(flags, override{s, raw})
+			return nil
+		}
+		if _, ok := s.value.(*bool); ok {
+			fs.BoolFunc(s.flag, s.usage, set)
+		} else {
+			fs.Func(s.flag, s.usage, set)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	c.File = *file
+	if c.File == "" {
+		c.File = os.Getenv("SENTRY_CONFIG")
+	}
+	if c.File != "" {
+		if err := c.readFile(c.File); err != nil {
+			return nil, err
+		}
+	} else if err := c.readFile(DefaultFile); err == nil {
+		c.File = DefaultFile
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, s := range settings {
+		for _, env := range s.env {
+			raw, ok := os.LookupEnv(env)
+			if !ok {
+				continue
+			}
+			if err := s.set(raw); err != nil {
+				return nil, fmt.Errorf("%s: %v", env, err)
+			}
+			break
+		}
+	}
+
+	for _, o := range flags {
+		if err := o.s.set(o.raw); err != nil {
+			return nil, fmt.Errorf("-%s: %v", o.s.flag, err)
+		}
+	}
+
+	if c.Addr == "" {
+		c.Addr = ":8080"
+		if c.TLS.Enabled {
+			c.Addr = ":8443"
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Unknown keys are usually typos, better to refuse them than to silently use a default
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+func (s setting) set(raw string) error {
+	switch v := s.value.(type) {
+	case *string:
+		*v = strings.TrimSpace(raw)
+	case *int:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s must be a number", s.key)
+		}
+		*v = n
+	case *[]string:
+		*v = []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+	case *bool:
+		switch strings.ToLower(strings.TrimSpace(raw)) {
+		case "1", "true", "yes", "on":
+			*v = true
+		case "0", "false", "no", "off":
+			*v = false
+		default:
+			return fmt.Errorf("%s must be on or off", s.key)
+		}
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []string
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if err := checkAddr(c.Addr); err != nil {
+		fail("addr: %v", err)
+	}
+	if c.DBPath == "" {
+		fail("db_path is required")
+	} else if info, err := os.Stat(filepath.Dir(c.DBPath)); err != nil || !info.IsDir() {
+		fail("db_path: directory %s does not exist", filepath.Dir(c.DBPath))
+	}
+	if c.StaticDir == "" {
+		fail("static_dir is required")
+	}
+	if c.FeedsDir == "" {
+		fail("feeds_dir is required")
+	}
+	if c.NmapPath == "" {
+		fail("nmap_path is required")
+	}
+	if c.ShutdownTimeout < 1 {
+		fail("shutdown_timeout must be at least 1 second")
+	}
+
+	if c.TLS.Enabled {
+		switch {
+		case (c.TLS.Cert == "") != (c.TLS.Key == ""):
+			fail("tls.cert and tls.key must be set together")
+		case c.TLS.Cert != "":
+			for _, f := range []string{c.TLS.Cert, c.TLS.Key} {
+				if _, err := os.Stat(f); err != nil {
+					fail("tls: %v", err)
+				}
+			}
+		case c.TLS.CertDir == "":
+			fail("tls.cert_dir is required for the self-signed certificate")
+		}
+
+		if c.TLS.RedirectAddr != "off" {
+			if err := checkAddr(c.TLS.RedirectAddr); err != nil {
+				fail("tls.redirect_addr: %v", err)
+			} else if c.TLS.RedirectAddr == c.Addr {
+				fail("tls.redirect_addr must differ from addr")
+			}
+		}
+	}
+
+	for _, o := range c.CORS.AllowedOrigins {
+		if o == "*" {
+			if c.CORS.AllowCredentials {
+				fail("cors: allowed_origins \"*\" cannot be combined with allow_credentials")
+			}
+			continue
+		}
+		if u, err := url.Parse(o); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			fail("cors: invalid origin %q, expected scheme://host[:port]", o)
+		}
+	}
+	for _, m := range c.CORS.AllowedMethods {
+		if m == "" || strings.ToUpper(m) != m || strings.ContainsAny(m, " ,") {
+			fail("cors: invalid method %q", m)
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		fail("cors.max_age must not be negative")
+	}
+
+	if c.SMTP.Port < 1 || c.SMTP.Port > 65535 {
+		fail("smtp.port must be between 1 and 65535")
+	}
+	if c.Admin.Password != "" && strings.TrimSpace(c.Admin.Username) == "" {
+		fail("admin.username is required with admin.password")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+func checkAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// Redacted is a copy safe to show to admins, with secrets masked
+func (c *Config) Redacted() Config {
+	r := *c
+	for _, secret := range []*string{&r.SMTP.Password, &r.Admin.Password, &r.Metrics.Token} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	return r
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+const alertRuleColumns = `id, name, enabled, trigger_type, min_score, kev_only, port, service, targets,
+	channel_id, recipients, throttle_minutes, created_at`
+
+func scanAlertRule(row interface{ Scan(...any) error }) (models.AlertRule, error) {
+	var r models.AlertRule
+	var targets, recipients string
+
+	err := row.Scan(&r.ID, &r.Name, &r.Enabled, &r.Trigger, &r.MinScore, &r.KEVOnly, &r.Port, &r.Service,
+		&targets, &r.ChannelID, &recipients, &r.ThrottleMinutes, &r.CreatedAt)
+	r.Targets = splitList(targets)
+	r.Recipients = splitList(recipients)
+	return r, err
+}
+
+func GetAlertRules(db *sql.DB) ([]models.AlertRule, error) {
+	rows, err := db.Query("SELECT " + alertRuleColumns + " FROM alert_rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []models.AlertRule{}
+	for rows.Next() {
+		r, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func GetAlertRule(db *sql.DB, id int) (models.AlertRule, error) {
+	return scanAlertRule(db.QueryRow("SELECT "+alertRuleColumns+" FROM alert_rules WHERE id = ?", id))
+}
+
+func CreateAlertRule(db *sql.DB, r models.AlertRule) (int, error) {
+	res, err := db.Exec(`
+		INSERT INTO alert_rules (name, enabled, trigger_type, min_score, kev_only, port, service, targets,
+			channel_id, recipients, throttle_minutes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Name, r.Enabled, r.Trigger, r.MinScore, r.KEVOnly, r.Port, r.Service, strings.Join(r.Targets, ","),
+		r.ChannelID, strings.Join(r.Recipients, ","), r.ThrottleMinutes,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func UpdateAlertRule(db *sql.DB, r models.AlertRule) error {
+	_, err := db.Exec(`
+		UPDATE alert_rules
+		SET name = ?, enabled = ?, trigger_type = ?, min_score = ?, kev_only = ?, port = ?, service = ?, targets = ?,
+		    channel_id = ?, recipients = ?, throttle_minutes = ?
+		WHERE id = ?`,
+		r.Name, r.Enabled, r.Trigger, r.MinScore, r.KEVOnly, r.Port, r.Service, strings.Join(r.Targets, ","),
+		r.ChannelID, strings.Join(r.Recipients, ","), r.ThrottleMinutes, r.ID,
+	)
+	return err
+}
+
+func DeleteAlertRule(db *sql.DB, id int) (bool, error) {
+	res, err := db.Exec("DELETE FROM alert_rules WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	_, _ = db.Exec("DELETE FROM alert_history WHERE rule_id = ?", id)
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ShouldAlert records an alert for the rule and fingerprint unless one was
+// already sent within the throttle window
+func ShouldAlert(db *sql.DB, ruleID int, fingerprint string, window time.Duration, now time.Time) (bool, error) {
+	if window > 0 {
+		var recent bool
+		err := db.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM alert_history WHERE rule_id = ? AND fingerprint = ? AND sent_at > ?)",
+			ruleID, fingerprint, now.Add(-window).UTC().Format(time.DateTime),
+		).Scan(&recent)
+		if err != nil || recent {
+			return false, err
+		}
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO alert_history (rule_id, fingerprint, sent_at) VALUES (?, ?, ?)
+		ON CONFLICT (rule_id, fingerprint) DO UPDATE SET sent_at = excluded.sent_at`,
+		ruleID, fingerprint, now.UTC().Format(time.DateTime),
+	)
+	return err == nil, err
+}
+
+func GetQuietHours(db *sql.DB) (models.QuietHours, error) {
+	var q models.QuietHours
+	err := db.QueryRow("SELECT enabled, start_time, end_time, timezone FROM quiet_hours WHERE id = 1").
+		Scan(&q.Enabled, &q.Start, &q.End, &q.Timezone)
+	return q, err
+}
+
+func SaveQuietHours(db *sql.DB, q models.QuietHours) error {
+	_, err := db.Exec("UPDATE quiet_hours SET enabled = ?, start_time = ?, end_time = ?, timezone = ? WHERE id = 1",
+		q.Enabled, q.Start, q.End, q.Timezone)
+	return err
+}
+
+type QueuedAlert struct {
+	ID     int
+	RuleID int
+	Event  []byte
+}
+
+func QueueAlert(db *sql.DB, ruleID int, event []byte) error {
+	_, err := db.Exec("INSERT INTO alert_queue (rule_id, event) VALUES (?, ?)", ruleID, string(event))
+	return err
+}
+
+func QueuedAlerts(db *sql.DB) ([]QueuedAlert, error) {
+	rows, err := db.Query("SELECT id, rule_id, event FROM alert_queue ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []QueuedAlert
+	for rows.Next() {
+		var a QueuedAlert
+		var event string
+		if err := rows.Scan(&a.ID, &a.RuleID, &event); err != nil {
+			return nil, err
+		}
+		a.Event = []byte(event)
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// DeleteQueuedAlerts removes the queued alerts that were delivered or dropped
+func DeleteQueuedAlerts(db *sql.DB, ids []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("DELETE FROM alert_queue WHERE id = ?")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, id := range ids {
+		if _, err := stmt.Exec(id); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+func GetAssets(db *sql.DB) ([]models.Asset, error) {
+	rows, err := db.Query("SELECT address, name, criticality FROM assets ORDER BY address")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := []models.Asset{}
+	for rows.Next() {
+		var a models.Asset
+		if err := rows.Scan(&a.Address, &a.Name, &a.Criticality); err != nil {
+			return nil, err
+		}
+		assets = append(assets, a)
+	}
+	return assets, rows.Err()
+}
+
+func GetAsset(db *sql.DB, address string) (models.Asset, error) {
+	var a models.Asset
+	err := db.QueryRow("SELECT address, name, criticality FROM assets WHERE address = ?", address).
+		Scan(&a.Address, &a.Name, &a.Criticality)
+	return a, err
+}
+
+func SaveAsset(db *sql.DB, a models.Asset) error {
+	_, err := db.Exec(`
+		INSERT INTO assets (address, name, criticality) VALUES (?, ?, ?)
+		ON CONFLICT(address) DO UPDATE SET name = excluded.name, criticality = excluded.criticality
+	`, a.Address, a.Name, a.Criticality)
+	return err
+}
+
+func DeleteAsset(db *sql.DB, address string) error {
+	_, err := db.Exec("DELETE FROM assets WHERE address = ?", address)
+	return err
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+func RecordAudit(db *sql.DB, e models.AuditEntry) error {
+	_, err := db.Exec(
+		"INSERT INTO audit_log (actor_id, actor, action, target, detail, remote_addr) VALUES (?, ?, ?, ?, ?, ?)",
+		e.ActorID, e.Actor, e.Action, e.Target, string(e.Detail), e.RemoteAddr,
+	)
+	return err
+}
+
+// QueryAudit calls each for the entries matching f, so exports can stream
+// the whole log without holding it in memory
+func QueryAudit(db *sql.DB, f models.AuditFilter, each func(models.AuditEntry) error) error {
+	var where []string
+	var args []any
+
+	if f.Actor != "" {
+		where = append(where, "actor = ? COLLATE NOCASE")
+		args = append(args, f.Actor)
+	}
+	if f.Action != "" {
+		where = append(where, "(action = ? OR action LIKE ? ESCAPE '\\')")
+		args = append(args, f.Action, escapeLike(f.Action)+".%")
+	}
+	if f.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, f.Target)
+	}
+	if f.Since != "" {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.Since)
+	}
+	if f.Until != "" {
+		where = append(where, "created_at < ?")
+		args = append(args, f.Until)
+	}
+
+	query := "SELECT id, created_at, actor_id, actor, action, target, detail, remote_addr FROM audit_log"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if f.Oldest {
+		query += " ORDER BY id"
+	} else {
+		query += " ORDER BY id DESC"
+	}
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEntry
+		var detail string
+		if err := rows.Scan(&e.ID, &e.Time, &e.ActorID, &e.Actor, &e.Action, &e.Target, &detail, &e.RemoteAddr); err != nil {
+			return err
+		}
+		if detail != "" {
+			e.Detail = []byte(detail)
+		}
+		if err := each(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"strings"
 
 	"github.com/wiktoz/sentry/models"
 	_ "modernc.org/sqlite"
@@ -18,7 +19,9 @@ DROP TABLE IF EXISTS config;
 
 CREATE TABLE IF NOT EXISTS scans (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
-    created_at DATETIME
+    created_at DATETIME,
+    status TEXT NOT NULL DEFAULT 'running',
+    finished_at DATETIME
 );
 
 CREATE TABLE IF NOT EXISTS hosts (
@@ -53,12 +56,239 @@ CREATE TABLE vulnerabilities (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     port_id INTEGER NOT NULL,
     vuln_id TEXT NOT NULL,
+    vuln_type TEXT NOT NULL DEFAULT 'other',
+    cve_id TEXT,
+    exploit INTEGER NOT NULL DEFAULT 0,
     score REAL,
     url TEXT,
     description TEXT,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (port_id) REFERENCES ports(id) ON DELETE CASCADE
 );
+
+CREATE TABLE IF NOT EXISTS smtp_settings (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    host TEXT NOT NULL DEFAULT '',
+    port INTEGER NOT NULL DEFAULT 587,
+    security TEXT NOT NULL DEFAULT 'starttls' CHECK (security IN ('tls', 'starttls', 'none')),
+    auth TEXT NOT NULL DEFAULT 'plain' CHECK (auth IN ('plain', 'login', 'cram-md5', 'none')),
+    username TEXT NOT NULL DEFAULT '',
+    password TEXT NOT NULL DEFAULT '',
+    from_address TEXT NOT NULL DEFAULT '',
+    recipients TEXT NOT NULL DEFAULT '',
+    ui_url TEXT NOT NULL DEFAULT '',
+    attach_csv INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT OR IGNORE INTO smtp_settings (id) VALUES (1);
+
+CREATE TABLE IF NOT EXISTS notifiers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    enabled INTEGER NOT NULL DEFAULT 1,
+    events TEXT NOT NULL DEFAULT '',
+    config TEXT NOT NULL DEFAULT '{}',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS assets (
+    address TEXT PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '',
+    criticality INTEGER NOT NULL DEFAULT 3 CHECK (criticality BETWEEN 1 AND 5)
+);
+
+CREATE TABLE IF NOT EXISTS suppressions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    address TEXT,
+    port INTEGER,
+    protocol TEXT,
+    vuln_id TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('false_positive', 'accepted_risk')),
+    justification TEXT NOT NULL,
+    owner TEXT NOT NULL,
+    expires_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS kev (
+    cve_id TEXT PRIMARY KEY,
+    vendor TEXT,
+    product TEXT,
+    name TEXT,
+    date_added TEXT,
+    due_date TEXT,
+    known_ransomware TEXT,
+    description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS epss (
+    cve_id TEXT PRIMARY KEY,
+    epss REAL NOT NULL,
+    percentile REAL NOT NULL,
+    score_date TEXT
+);
+
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    enabled INTEGER NOT NULL DEFAULT 1,
+    trigger_type TEXT NOT NULL CHECK (trigger_type IN ('finding', 'new_host', 'new_port')),
+    min_score REAL NOT NULL DEFAULT 0,
+    kev_only INTEGER NOT NULL DEFAULT 0,
+    port INTEGER NOT NULL DEFAULT 0,
+    service TEXT NOT NULL DEFAULT '',
+    targets TEXT NOT NULL DEFAULT '',
+    channel_id INTEGER NOT NULL DEFAULT 0,
+    recipients TEXT NOT NULL DEFAULT '',
+    throttle_minutes INTEGER NOT NULL DEFAULT 1440,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Last time each rule alerted on a finding/host/port, for throttling
+CREATE TABLE IF NOT EXISTS alert_history (
+    rule_id INTEGER NOT NULL,
+    fingerprint TEXT NOT NULL,
+    sent_at DATETIME NOT NULL,
+    PRIMARY KEY (rule_id, fingerprint)
+);
+
+CREATE TABLE IF NOT EXISTS quiet_hours (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    enabled INTEGER NOT NULL DEFAULT 0,
+    start_time TEXT NOT NULL DEFAULT '22:00',
+    end_time TEXT NOT NULL DEFAULT '07:00',
+    timezone TEXT NOT NULL DEFAULT ''
+);
+
+INSERT OR IGNORE INTO quiet_hours (id) VALUES (1);
+
+-- Alerts held back by quiet hours: emailing rules batch them into the next
+-- digest, the others send them to their channel when quiet hours end
+CREATE TABLE IF NOT EXISTS alert_queue (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    rule_id INTEGER NOT NULL,
+    event TEXT NOT NULL,
+    queued_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Delivery log of every outbound notification; target identifies the notifier
+-- (channel:<id>, rule:<id> or email) so failed ones can be retried after a restart
+CREATE TABLE IF NOT EXISTS notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    target TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed', 'dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_retry ON notifications (status, next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    password_hash TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT 'viewer' CHECK (role IN ('viewer', 'operator', 'admin')),
+    totp_secret TEXT NOT NULL DEFAULT '',
+    totp_enabled INTEGER NOT NULL DEFAULT 0,
+    totp_last_step INTEGER NOT NULL DEFAULT 0,
+    oidc_subject TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Bearer tokens for automation; only the SHA-256 of the token is stored
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    expires_at TEXT,
+    last_used_at TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS oidc_settings (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    enabled INTEGER NOT NULL DEFAULT 0,
+    name TEXT NOT NULL DEFAULT 'SSO',
+    issuer TEXT NOT NULL DEFAULT '',
+    client_id TEXT NOT NULL DEFAULT '',
+    client_secret TEXT NOT NULL DEFAULT '',
+    redirect_url TEXT NOT NULL DEFAULT '',
+    scopes TEXT NOT NULL DEFAULT 'openid,profile,email',
+    username_claim TEXT NOT NULL DEFAULT 'preferred_username',
+    role_claim TEXT NOT NULL DEFAULT 'groups',
+    role_mappings TEXT NOT NULL DEFAULT '{}',
+    default_role TEXT NOT NULL DEFAULT ''
+);
+
+INSERT OR IGNORE INTO oidc_settings (id) VALUES (1);
+
+-- Browser logins; the cookie only carries a token whose SHA-256 is stored here
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    csrf_token TEXT NOT NULL,
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TEXT NOT NULL,
+    expires_at TEXT NOT NULL
+);
+
+-- Single-use TOTP recovery codes, stored hashed
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at TEXT
+);
+
+-- Append-only record of security-relevant actions; the triggers refuse edits
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    actor_id INTEGER NOT NULL DEFAULT 0,
+    actor TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    target TEXT NOT NULL DEFAULT '',
+    detail TEXT NOT NULL DEFAULT '',
+    remote_addr TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit log is append-only');
+END;
+
+-- Requests refused by role checks
+CREATE TABLE IF NOT EXISTS forbidden_attempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    username TEXT NOT NULL,
+    role TEXT NOT NULL,
+    method TEXT NOT NULL,
+    path TEXT NOT NULL,
+    remote_addr TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
 `
 
 func GetConfig(db *sql.DB) (models.Config, error) {
@@ -74,3 +304,46 @@ func SaveConfig(db *sql.DB, cfg models.Config) error {
 	`, cfg.ScanFrequency, cfg.Email, cfg.ScanTarget)
 	return err
 }
+
+func GetSMTPSettings(db *sql.DB) (models.SMTPSettings, error) {
+	var s models.SMTPSettings
+	var recipients string
+	err := db.QueryRow(`
+		SELECT host, port, security, auth, username, password, from_address, recipients, ui_url, attach_csv
+		FROM smtp_settings WHERE id = 1`).
+		Scan(&s.Host, &s.Port, &s.Security, &s.Auth, &s.Username, &s.Password, &s.From, &recipients, &s.UIURL, &s.AttachCSV)
+	s.Recipients = splitList(recipients)
+	return s, err
+}
+
+func SaveSMTPSettings(db *sql.DB, s models.SMTPSettings) error {
+	_, err := db.Exec(`
+		UPDATE smtp_settings
+		SET host = ?, port = ?, security = ?, auth = ?, username = ?, password = ?, from_address = ?, recipients = ?,
+		    ui_url = ?, attach_csv = ?
+		WHERE id = 1
+	`, s.Host, s.Port, s.Security, s.Auth, s.Username, s.Password, s.From, strings.Join(s.Recipients, ","), s.UIURL, s.AttachCSV)
+	return err
+}
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// CheckWritable makes a schema change and rolls it back, failing if the database is read-only
+func CheckWritable(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("CREATE TABLE write_probe (id INTEGER)")
+	return err
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+// Queryer is satisfied by both *sql.DB and *sql.Tx
+type Queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func ReplaceKEV(db *sql.DB, entries []models.KEVEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM kev"); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT OR REPLACE INTO kev (cve_id, vendor, product, name, date_added, due_date, known_ransomware, description)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range entries {
+		_, err := stmt.Exec(e.CVEID, e.VendorProject, e.Product, e.Name, e.DateAdded, e.DueDate, e.KnownRansomware, e.Description)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func ReplaceEPSS(db *sql.DB, entries []models.EPSSEntry, scoreDate string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM epss"); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO epss (cve_id, epss, percentile, score_date) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range entries {
+		if _, err := stmt.Exec(e.CVEID, e.EPSS, e.Percentile, scoreDate); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// LookupEnrichment returns KEV and EPSS data for a CVE, zero values if unknown
+func LookupEnrichment(q Queryer, cveID string) (models.Enrichment, error) {
+	var e models.Enrichment
+	var kevDate sql.NullString
+
+	err := q.QueryRow(`
+		SELECT k.date_added, COALESCE(e.epss, 0), COALESCE(e.percentile, 0)
+		FROM (SELECT ? AS cve_id) c
+		LEFT JOIN kev k ON k.cve_id = c.cve_id
+		LEFT JOIN epss e ON e.cve_id = c.cve_id`, cveID).
+		Scan(&kevDate, &e.EPSS, &e.EPSSPercentile)
+	if err != nil {
+		return models.Enrichment{}, err
+	}
+
+	e.KEV = kevDate.Valid
+	e.KEVDateAdded = kevDate.String
+	return e, nil
+}
+
+func GetFeedStatus(db *sql.DB) (models.FeedStatus, error) {
+	var s models.FeedStatus
+	var epssDate sql.NullString
+
+	err := db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM kev), (SELECT COUNT(*) FROM epss), (SELECT MAX(score_date) FROM epss)`).
+		Scan(&s.KEVCount, &s.EPSSCount, &epssDate)
+	s.EPSSDate = epssDate.String
+	return s, err
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+func LatestScanID(db *sql.DB) (int, error) {
+	var id int
+	err := db.QueryRow("SELECT id FROM scans ORDER BY created_at DESC, id DESC LIMIT 1").Scan(&id)
+	return id, err
+}
+
+// GetFindings returns every vulnerability of a scan flattened together with its host and port
+func GetFindings(db *sql.DB, scanID int) ([]models.Finding, error) {
+	sups, err := ActiveSuppressions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT h.scan_id, h.address, p.port_id, p.protocol, p.service_name,
+		       v.vuln_id, v.vuln_type, COALESCE(v.cve_id, ''), v.exploit,
+		       v.description, v.score, v.url, k.date_added,
+		       COALESCE(e.epss, 0), COALESCE(e.percentile, 0)
+		FROM vulnerabilities v
+		JOIN ports p ON p.id = v.port_id
+		JOIN hosts h ON h.id = p.host_id
+		LEFT JOIN kev k ON k.cve_id = v.cve_id
+		LEFT JOIN epss e ON e.cve_id = v.cve_id
+		WHERE h.scan_id = ?
+		ORDER BY v.score DESC, h.address, p.port_id`, scanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var findings []models.Finding
+	for rows.Next() {
+		var f models.Finding
+		var kevDate sql.NullString
+
+		err := rows.Scan(&f.ScanID, &f.Address, &f.PortNum, &f.Protocol, &f.ServiceName,
+			&f.ID, &f.Type, &f.CVE, &f.Exploit,
+			&f.Description, &f.Score, &f.URL, &kevDate,
+			&f.EPSS, &f.EPSSPercentile)
+		if err != nil {
+			return nil, err
+		}
+
+		f.KEV = kevDate.Valid
+		f.KEVDateAdded = kevDate.String
+		models.ApplySuppressions(sups, f.Address, f.PortNum, f.Protocol, &f.VulnerabilityData)
+		findings = append(findings, f)
+	}
+
+	return findings, rows.Err()
+}
+
+// RecentScanIDs returns up to n scan IDs, newest first
+func RecentScanIDs(db *sql.DB, n int) ([]int, error) {
+	rows, err := db.Query("SELECT id FROM scans ORDER BY created_at DESC, id DESC LIMIT ?", n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,50 @@
+package db
+
+import "database/sql"
+
+// CountScansByStatus counts every scan ever run by its lifecycle state
+func CountScansByStatus(db *sql.DB) (map[string]int, error) {
+	return countBy(db, "SELECT status, COUNT(*) FROM scans GROUP BY status")
+}
+
+// LatestFinishedScanID returns the newest finished scan, or sql.ErrNoRows if there is none
+func LatestFinishedScanID(db *sql.DB) (int, error) {
+	var id int
+	err := db.QueryRow("SELECT id FROM scans WHERE status = ? ORDER BY id DESC LIMIT 1", ScanFinished).Scan(&id)
+	return id, err
+}
+
+func CountHosts(db *sql.DB, scanID int) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM hosts WHERE scan_id = ?", scanID).Scan(&n)
+	return n, err
+}
+
+// CountOpenPortsByService counts a scan's open ports by detected service, "unknown" when nmap found none
+func CountOpenPortsByService(db *sql.DB, scanID int) (map[string]int, error) {
+	return countBy(db, `
+		SELECT COALESCE(NULLIF(p.service_name, ''), 'unknown'), COUNT(*)
+		FROM ports p
+		JOIN hosts h ON h.id = p.host_id
+		WHERE h.scan_id = ? AND p.state = 'open'
+		GROUP BY 1`, scanID)
+}
+
+func countBy(db *sql.DB, query string, args ...any) (map[string]int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var key string
+		var n int
+		if err := rows.Scan(&key, &n); err != nil {
+			return nil, err
+		}
+		counts[key] = n
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type column struct {
+	table      string
+	name       string
+	definition string
+}
+
+// Columns added to persistent tables after their first release. The schema only
+// creates those tables IF NOT EXISTS, so databases from older versions lack them.
+var addedColumns = []column{
+	{"smtp_settings", "ui_url", "TEXT NOT NULL DEFAULT ''"},
+	{"smtp_settings", "attach_csv", "INTEGER NOT NULL DEFAULT 0"},
+	// Accounts created before roles existed had full access
+	{"users", "role", "TEXT NOT NULL DEFAULT 'admin' CHECK (role IN ('viewer', 'operator', 'admin'))"},
+	{"users", "totp_secret", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "oidc_subject", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// Indexes on added columns, created once the columns exist
+var addedIndexes = []string{
+	"CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_subject ON users(oidc_subject) WHERE oidc_subject != ''",
+}
+
+// Migrate brings an existing database up to the current schema
+func Migrate(db *sql.DB) error {
+	for _, c := range addedColumns {
+		exists, err := hasColumn(db, c.table, c.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.name, c.definition)); err != nil {
+			return fmt.Errorf("add column %s.%s: %v", c.table, c.name, err)
+		}
+	}
+
+	for _, stmt := range addedIndexes {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasColumn(db *sql.DB, table, name string) (bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return false, err
+		}
+		if col == name {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+func CreateNotification(db *sql.DB, n models.Notification) (int, error) {
+	res, err := db.Exec(
+		"INSERT INTO notifications (target, channel, event, payload, status) VALUES (?, ?, ?, ?, ?)",
+		n.Target, n.Channel, n.Event, string(n.Payload), models.DeliveryPending,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func GetNotifications(db *sql.DB, status string, limit int) ([]models.Notification, error) {
+	query := `
+		SELECT id, target, channel, event, payload, status, attempts, last_error,
+		       COALESCE(next_attempt_at, ''), created_at, updated_at
+		FROM notifications`
+	args := []any{}
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, rows.Err()
+}
+
+func GetNotification(db *sql.DB, id int) (models.Notification, error) {
+	return scanNotification(db.QueryRow(`
+		SELECT id, target, channel, event, payload, status, attempts, last_error,
+		       COALESCE(next_attempt_at, ''), created_at, updated_at
+		FROM notifications WHERE id = ?`, id))
+}
+
+func scanNotification(row interface{ Scan(...any) error }) (models.Notification, error) {
+	var n models.Notification
+	var payload string
+
+	err := row.Scan(&n.ID, &n.Target, &n.Channel, &n.Event, &payload, &n.Status, &n.Attempts, &n.LastError,
+		&n.NextAttempt, &n.CreatedAt, &n.UpdatedAt)
+	n.Payload = []byte(payload)
+	return n, err
+}
+
+func MarkNotificationSent(db *sql.DB, id int) error {
+	_, err := db.Exec(`
+		UPDATE notifications
+		SET status = 'sent', attempts = attempts + 1, last_error = '', next_attempt_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, id)
+	return err
+}
+
+// FailNotification records a failed attempt and returns the attempt count so far
+func FailNotification(db *sql.DB, id int, reason string) (int, error) {
+	var attempts int
+	err := db.QueryRow(`
+		UPDATE notifications
+		SET status = 'failed', attempts = attempts + 1, last_error = ?, next_attempt_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		RETURNING attempts`, reason, id).Scan(&attempts)
+	return attempts, err
+}
+
+// ScheduleRetry sets when a failed notification is due again
+func ScheduleRetry(db *sql.DB, id int, at time.Time) error {
+	_, err := db.Exec("UPDATE notifications SET next_attempt_at = ? WHERE id = ?", at.UTC().Format(time.DateTime), id)
+	return err
+}
+
+func MarkNotificationDead(db *sql.DB, id int) error {
+	_, err := db.Exec("UPDATE notifications SET status = 'dead', updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	return err
+}
+
+// ClaimNotification moves a notification back to pending for another attempt. It
+// returns false when it is already pending, so a retry is never queued twice.
+func ClaimNotification(db *sql.DB, id int, resetAttempts bool) (bool, error) {
+	res, err := db.Exec(`
+		UPDATE notifications
+		SET status = 'pending', next_attempt_at = NULL, updated_at = CURRENT_TIMESTAMP,
+		    attempts = CASE WHEN ? THEN 0 ELSE attempts END
+		WHERE id = ? AND status != 'pending'`, resetAttempts, id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DueNotifications lists failed notifications whose next attempt is due
+func DueNotifications(db *sql.DB, now time.Time) ([]models.Notification, error) {
+	rows, err := db.Query(`
+		SELECT id, target, channel, event, payload, status, attempts, last_error,
+		       COALESCE(next_attempt_at, ''), created_at, updated_at
+		FROM notifications
+		WHERE status = 'failed' AND next_attempt_at IS NOT NULL AND next_attempt_at <= ?
+		ORDER BY id`, now.UTC().Format(time.DateTime))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []models.Notification
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, n)
+	}
+	return due, rows.Err()
+}
+
+// RequeueInterrupted makes notifications that were pending when the process
+// stopped due for retry
+func RequeueInterrupted(db *sql.DB, now time.Time) error {
+	_, err := db.Exec(`
+		UPDATE notifications
+		SET status = 'failed', last_error = 'interrupted by restart', next_attempt_at = ?
+		WHERE status = 'pending'`, now.UTC().Format(time.DateTime))
+	return err
+}
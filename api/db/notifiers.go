@@ -0,0 +1,79 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+func scanChannel(row interface{ Scan(...any) error }) (models.Channel, error) {
+	var ch models.Channel
+	var events, config string
+
+	err := row.Scan(&ch.ID, &ch.Name, &ch.Type, &ch.Enabled, &events, &config, &ch.CreatedAt)
+	ch.Events = splitList(events)
+	ch.Config = []byte(config)
+	return ch, err
+}
+
+func GetChannels(db *sql.DB) ([]models.Channel, error) {
+	rows, err := db.Query("SELECT id, name, type, enabled, events, config, created_at FROM notifiers ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := []models.Channel{}
+	for rows.Next() {
+		ch, err := scanChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, ch)
+	}
+	return channels, rows.Err()
+}
+
+func GetChannel(db *sql.DB, id int) (models.Channel, error) {
+	return scanChannel(db.QueryRow("SELECT id, name, type, enabled, events, config, created_at FROM notifiers WHERE id = ?", id))
+}
+
+func CreateChannel(db *sql.DB, ch models.Channel) (int, error) {
+	res, err := db.Exec(
+		"INSERT INTO notifiers (name, type, enabled, events, config) VALUES (?, ?, ?, ?, ?)",
+		ch.Name, ch.Type, ch.Enabled, strings.Join(ch.Events, ","), string(ch.Config),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func UpdateChannel(db *sql.DB, ch models.Channel) error {
+	_, err := db.Exec(
+		"UPDATE notifiers SET name = ?, enabled = ?, events = ?, config = ? WHERE id = ?",
+		ch.Name, ch.Enabled, strings.Join(ch.Events, ","), string(ch.Config), ch.ID,
+	)
+	return err
+}
+
+func DeleteChannel(db *sql.DB, id int) (bool, error) {
+	res, err := db.Exec("DELETE FROM notifiers WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+
+	// Rules routed to this channel only would never fire again; ones with recipients keep working
+	if _, err := db.Exec("DELETE FROM alert_rules WHERE channel_id = ? AND recipients = ''", id); err != nil {
+		return false, err
+	}
+	if _, err := db.Exec("UPDATE alert_rules SET channel_id = 0 WHERE channel_id = ?", id); err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+func GetOIDCSettings(db *sql.DB) (models.OIDCSettings, error) {
+	var s models.OIDCSettings
+	var scopes, mappings string
+	err := db.QueryRow(`
+		SELECT enabled, name, issuer, client_id, client_secret, redirect_url, scopes,
+		       username_claim, role_claim, role_mappings, default_role
+		FROM oidc_settings WHERE id = 1`).
+		Scan(&s.Enabled, &s.Name, &s.Issuer, &s.ClientID, &s.ClientSecret, &s.RedirectURL, &scopes,
+			&s.UsernameClaim, &s.RoleClaim, &mappings, &s.DefaultRole)
+	if err != nil {
+		return s, err
+	}
+
+	s.Scopes = splitList(scopes)
+	err = json.Unmarshal([]byte(mappings), &s.RoleMappings)
+	return s, err
+}
+
+func SaveOIDCSettings(db *sql.DB, s models.OIDCSettings) error {
+	mappings, err := json.Marshal(s.RoleMappings)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		UPDATE oidc_settings
+		SET enabled = ?, name = ?, issuer = ?, client_id = ?, client_secret = ?, redirect_url = ?, scopes = ?,
+		    username_claim = ?, role_claim = ?, role_mappings = ?, default_role = ?
+		WHERE id = 1
+	`, s.Enabled, s.Name, s.Issuer, s.ClientID, s.ClientSecret, s.RedirectURL, strings.Join(s.Scopes, ","),
+		s.UsernameClaim, s.RoleClaim, string(mappings), s.DefaultRole)
+	return err
+}
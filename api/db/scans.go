@@ -0,0 +1,147 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/wiktoz/sentry/models"
+	"github.com/wiktoz/sentry/risk"
+)
+
+// Scan lifecycle states
+const (
+	ScanRunning  = "running"
+	ScanFinished = "finished"
+	ScanFailed   = "failed"
+)
+
+func CreateScan(db *sql.DB) (int, error) {
+	res, err := db.Exec("INSERT INTO scans (created_at, status) VALUES (datetime('now'), ?)", ScanRunning)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func SetScanStatus(db *sql.DB, scanID int, status string) error {
+	_, err := db.Exec("UPDATE scans SET status = ?, finished_at = datetime('now') WHERE id = ?", status, scanID)
+	return err
+}
+
+// PreviousScanID returns the finished scan preceding scanID, or sql.ErrNoRows if there is none
+func PreviousScanID(db *sql.DB, scanID int) (int, error) {
+	var id int
+	err := db.QueryRow(`
+		SELECT id FROM scans WHERE id < ? AND status = ? ORDER BY id DESC LIMIT 1`, scanID, ScanFinished).Scan(&id)
+	return id, err
+}
+
+// GetScanData loads a scan with its hosts, ports and findings, scoring each host
+func GetScanData(db *sql.DB, scanID int) (models.ScanData, error) {
+	var scan models.ScanData
+
+	// Fetch scan metadata
+	err := db.QueryRow(`SELECT id, created_at, status FROM scans WHERE id = ?`, scanID).Scan(&scan.ID, &scan.Date, &scan.Status)
+	if err != nil {
+		return models.ScanData{}, err
+	}
+
+	sups, err := ActiveSuppressions(db)
+	if err != nil {
+		return models.ScanData{}, err
+	}
+
+	// Fetch hosts for the scan along with their asset criticality
+	hostRows, err := db.Query(`
+		SELECT h.id, h.address, COALESCE(a.criticality, ?)
+		FROM hosts h
+		LEFT JOIN assets a ON a.address = h.address
+		WHERE h.scan_id = ?`, risk.DefaultCriticality, scanID)
+	if err != nil {
+		return models.ScanData{}, err
+	}
+	defer hostRows.Close()
+
+	for hostRows.Next() {
+		var host models.HostData
+		var hostID int
+
+		if err := hostRows.Scan(&hostID, &host.Address, &host.Criticality); err != nil {
+			return models.ScanData{}, err
+		}
+
+		ports, err := fetchPortsWithVulns(db, hostID, host.Address, sups)
+		if err != nil {
+			return models.ScanData{}, err
+		}
+
+		host.Ports = ports
+		host.Risk = risk.HostScore(ports, host.Criticality)
+		scan.Hosts = append(scan.Hosts, host)
+	}
+
+	if err := hostRows.Err(); err != nil {
+		return models.ScanData{}, err
+	}
+
+	scan.Risk = risk.NetworkScore(scan.Hosts)
+
+	return scan, nil
+}
+
+func fetchPortsWithVulns(db *sql.DB, hostID int, address string, sups []models.Suppression) ([]models.PortData, error) {
+	portRows, err := db.Query(`
+		SELECT id, port_id, service_name, protocol, state 
+		FROM ports 
+		WHERE host_id = ?`, hostID)
+	if err != nil {
+		return nil, err
+	}
+	defer portRows.Close()
+
+	var ports []models.PortData
+
+	for portRows.Next() {
+		var p models.PortData
+		var portID int
+
+		if err := portRows.Scan(&portID, &p.PortNum, &p.ServiceName, &p.Protocol, &p.State); err != nil {
+			return nil, err
+		}
+
+		vulnRows, err := db.Query(`
+			SELECT v.vuln_id, v.vuln_type, COALESCE(v.cve_id, ''), v.exploit,
+			       v.description, v.score, v.url, k.date_added,
+			       COALESCE(e.epss, 0), COALESCE(e.percentile, 0)
+			FROM vulnerabilities v
+			LEFT JOIN kev k ON k.cve_id = v.cve_id
+			LEFT JOIN epss e ON e.cve_id = v.cve_id
+			WHERE v.port_id = ?`, portID)
+		if err != nil {
+			return nil, err
+		}
+
+		for vulnRows.Next() {
+			var v models.VulnerabilityData
+			var kevDate sql.NullString
+			if err := vulnRows.Scan(&v.ID, &v.Type, &v.CVE, &v.Exploit, &v.Description, &v.Score, &v.URL, &kevDate, &v.EPSS, &v.EPSSPercentile); err != nil {
+				vulnRows.Close()
+				return nil, err
+			}
+			v.KEV = kevDate.Valid
+			v.KEVDateAdded = kevDate.String
+			models.ApplySuppressions(sups, address, p.PortNum, p.Protocol, &v)
+			p.Vulnerabilities = append(p.Vulnerabilities, v)
+		}
+		vulnRows.Close()
+
+		ports = append(ports, p)
+	}
+
+	if err := portRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ports, nil
+}
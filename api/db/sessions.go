@@ -0,0 +1,103 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+const sessionColumns = `s.id, s.user_id, u.username, s.ip, s.user_agent, s.created_at,
+	s.last_seen_at, s.expires_at, s.csrf_token`
+
+func scanSession(row interface{ Scan(...any) error }) (models.Session, error) {
+	var s models.Session
+	err := row.Scan(&s.ID, &s.UserID, &s.Username, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.CSRFToken)
+	return s, err
+}
+
+// GetSessions lists the live sessions of one user, or of everyone when userID is 0
+func GetSessions(db *sql.DB, userID int, now time.Time) ([]models.Session, error) {
+	query := "SELECT " + sessionColumns + " FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.expires_at > ?"
+	args := []any{now.UTC().Format(time.DateTime)}
+	if userID != 0 {
+		query += " AND s.user_id = ?"
+		args = append(args, userID)
+	}
+
+	rows, err := db.Query(query+" ORDER BY s.last_seen_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+func GetSession(db *sql.DB, id int) (models.Session, error) {
+	return scanSession(db.QueryRow("SELECT "+sessionColumns+" FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.id = ?", id))
+}
+
+// GetSessionByHash finds a session that has neither expired nor sat idle for longer than idle
+func GetSessionByHash(db *sql.DB, hash string, idle time.Duration, now time.Time) (models.Session, error) {
+	return scanSession(db.QueryRow(
+		"SELECT "+sessionColumns+` FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ? AND s.last_seen_at > ?`,
+		hash, now.UTC().Format(time.DateTime), now.Add(-idle).UTC().Format(time.DateTime),
+	))
+}
+
+func CreateSession(db *sql.DB, s models.Session, hash string) (int, error) {
+	res, err := db.Exec(
+		"INSERT INTO sessions (user_id, token_hash, csrf_token, ip, user_agent, last_seen_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		s.UserID, hash, s.CSRFToken, s.IP, s.UserAgent, s.LastSeenAt, s.ExpiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// TouchSession slides the idle timeout, writing at most once a minute per session
+func TouchSession(db *sql.DB, id int, now time.Time) error {
+	_, err := db.Exec(
+		"UPDATE sessions SET last_seen_at = ? WHERE id = ? AND last_seen_at < ?",
+		now.UTC().Format(time.DateTime), id, now.Add(-time.Minute).UTC().Format(time.DateTime),
+	)
+	return err
+}
+
+func DeleteSession(db *sql.DB, id int) (bool, error) {
+	res, err := db.Exec("DELETE FROM sessions WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteUserSessions signs a user out everywhere except the session keepID (0 for none)
+func DeleteUserSessions(db *sql.DB, userID, keepID int) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE user_id = ? AND id != ?", userID, keepID)
+	return err
+}
+
+// PruneSessions drops sessions that can no longer be used
+func PruneSessions(db *sql.DB, idle time.Duration, now time.Time) error {
+	_, err := db.Exec(
+		"DELETE FROM sessions WHERE expires_at <= ? OR last_seen_at <= ?",
+		now.UTC().Format(time.DateTime), now.Add(-idle).UTC().Format(time.DateTime),
+	)
+	return err
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+const suppressionColumns = `
+	id, COALESCE(address, ''), COALESCE(port, 0), COALESCE(protocol, ''), vuln_id, status,
+	justification, owner, COALESCE(expires_at, ''), created_at,
+	expires_at IS NULL OR expires_at > datetime('now')`
+
+func scanSuppressions(rows *sql.Rows) ([]models.Suppression, error) {
+	defer rows.Close()
+
+	sups := []models.Suppression{}
+	for rows.Next() {
+		var s models.Suppression
+		err := rows.Scan(&s.ID, &s.Address, &s.Port, &s.Protocol, &s.VulnID, &s.Status,
+			&s.Justification, &s.Owner, &s.ExpiresAt, &s.CreatedAt, &s.Active)
+		if err != nil {
+			return nil, err
+		}
+		sups = append(sups, s)
+	}
+	return sups, rows.Err()
+}
+
+func GetSuppressions(db *sql.DB) ([]models.Suppression, error) {
+	rows, err := db.Query("SELECT " + suppressionColumns + " FROM suppressions ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	return scanSuppressions(rows)
+}
+
+// ActiveSuppressions returns the suppressions that have not expired,
+// host-specific ones first so they take precedence over network-wide ones
+func ActiveSuppressions(db *sql.DB) ([]models.Suppression, error) {
+	rows, err := db.Query(`SELECT ` + suppressionColumns + ` FROM suppressions
+		WHERE expires_at IS NULL OR expires_at > datetime('now')
+		ORDER BY address IS NULL, id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanSuppressions(rows)
+}
+
+func CreateSuppression(db *sql.DB, s models.Suppression) (int, error) {
+	res, err := db.Exec(`
+		INSERT INTO suppressions (address, port, protocol, vuln_id, status, justification, owner, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullString(s.Address), nullInt(s.Port), nullString(s.Protocol), s.VulnID, s.Status,
+		s.Justification, s.Owner, nullString(s.ExpiresAt),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func DeleteSuppression(db *sql.DB, id int) (bool, error) {
+	res, err := db.Exec("DELETE FROM suppressions WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullInt(i int) any {
+	if i == 0 {
+		return nil
+	}
+	return i
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+const tokenColumns = `t.id, t.user_id, u.username, t.name, t.prefix, t.scopes,
+	COALESCE(t.expires_at, ''), COALESCE(t.last_used_at, ''), t.created_at`
+
+func scanToken(row interface{ Scan(...any) error }) (models.APIToken, error) {
+	var t models.APIToken
+	var scopes string
+
+	err := row.Scan(&t.ID, &t.UserID, &t.Username, &t.Name, &t.Prefix, &scopes, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt)
+	t.Scopes = splitList(scopes)
+	return t, err
+}
+
+// GetTokens lists the tokens of one user, or of everyone when userID is 0
+func GetTokens(db *sql.DB, userID int) ([]models.APIToken, error) {
+	query := "SELECT " + tokenColumns + " FROM api_tokens t JOIN users u ON u.id = t.user_id"
+	args := []any{}
+	if userID != 0 {
+		query += " WHERE t.user_id = ?"
+		args = append(args, userID)
+	}
+
+	rows, err := db.Query(query+" ORDER BY t.id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func GetToken(db *sql.DB, id int) (models.APIToken, error) {
+	return scanToken(db.QueryRow("SELECT "+tokenColumns+" FROM api_tokens t JOIN users u ON u.id = t.user_id WHERE t.id = ?", id))
+}
+
+// GetTokenByHash finds a token that has not expired
+func GetTokenByHash(db *sql.DB, hash string, now time.Time) (models.APIToken, error) {
+	return scanToken(db.QueryRow(
+		"SELECT "+tokenColumns+` FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ? AND (t.expires_at IS NULL OR t.expires_at > ?)`,
+		hash, now.UTC().Format(time.DateTime),
+	))
+}
+
+func CreateToken(db *sql.DB, t models.APIToken, hash string) (int, error) {
+	res, err := db.Exec(
+		"INSERT INTO api_tokens (user_id, name, prefix, token_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		t.UserID, t.Name, t.Prefix, hash, strings.Join(t.Scopes, ","), nullString(t.ExpiresAt),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// TouchToken updates last_used_at, at most once a minute per token
+func TouchToken(db *sql.DB, id int, now time.Time) error {
+	_, err := db.Exec(
+		"UPDATE api_tokens SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)",
+		now.UTC().Format(time.DateTime), id, now.Add(-time.Minute).UTC().Format(time.DateTime),
+	)
+	return err
+}
+
+func DeleteToken(db *sql.DB, id int) (bool, error) {
+	res, err := db.Exec("DELETE FROM api_tokens WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
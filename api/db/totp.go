@@ -0,0 +1,79 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// GetTOTP returns a user's TOTP secret, whether it is enabled and the last time step accepted
+func GetTOTP(db *sql.DB, userID int) (secret string, enabled bool, lastStep int64, err error) {
+	err = db.QueryRow("SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = ?", userID).
+		Scan(&secret, &enabled, &lastStep)
+	return
+}
+
+// SetTOTPSecret stores a pending secret; it is not checked at login until EnableTOTP
+func SetTOTPSecret(db *sql.DB, userID int, secret string) error {
+	_, err := db.Exec("UPDATE users SET totp_secret = ?, totp_enabled = 0, totp_last_step = 0 WHERE id = ?", secret, userID)
+	return err
+}
+
+// EnableTOTP turns two-factor on and replaces the user's recovery codes
+func EnableTOTP(db *sql.DB, userID int, step int64, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = 1, totp_last_step = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", step, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, h); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func DisableTOTP(db *sql.DB, userID int) error {
+	if _, err := db.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	_, err := db.Exec("UPDATE users SET totp_secret = '', totp_enabled = 0, totp_last_step = 0, updated_at = CURRENT_TIMESTAMP WHERE id = ?", userID)
+	return err
+}
+
+// UseTOTPStep records an accepted code; it fails when step was already used, so a code works only once
+func UseTOTPStep(db *sql.DB, userID int, step int64) (bool, error) {
+	res, err := db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UseRecoveryCode consumes an unused recovery code
+func UseRecoveryCode(db *sql.DB, userID int, codeHash string) (bool, error) {
+	res, err := db.Exec(
+		"UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		userID, codeHash,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func CountRecoveryCodes(db *sql.DB, userID int) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&n)
+	return n, err
+}
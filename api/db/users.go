@@ -0,0 +1,133 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+const userColumns = "id, username, role, totp_enabled, oidc_subject != '', password_hash, created_at, updated_at"
+
+func scanUser(row interface{ Scan(...any) error }) (models.User, error) {
+	var u models.User
+	err := row.Scan(&u.ID, &u.Username, &u.Role, &u.TOTPEnabled, &u.SSO, &u.PasswordHash, &u.CreatedAt, &u.UpdatedAt)
+	return u, err
+}
+
+func GetUsers(db *sql.DB) ([]models.User, error) {
+	rows, err := db.Query("SELECT " + userColumns + " FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func GetUser(db *sql.DB, id int) (models.User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+// GetUserByName looks a user up case-insensitively
+func GetUserByName(db *sql.DB, username string) (models.User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
+func CountUsers(db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&n)
+	return n, err
+}
+
+func CountAdmins(db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", models.RoleAdmin).Scan(&n)
+	return n, err
+}
+
+func CreateUser(db *sql.DB, username, role, passwordHash string) (int, error) {
+	res, err := db.Exec("INSERT INTO users (username, role, password_hash) VALUES (?, ?, ?)", username, role, passwordHash)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// GetUserBySubject finds the account linked to an OpenID Connect issuer and subject
+func GetUserBySubject(db *sql.DB, subject string) (models.User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE oidc_subject = ? AND oidc_subject != ''", subject))
+}
+
+// CreateSSOUser adds an account that signs in through OpenID Connect and has no local password
+func CreateSSOUser(db *sql.DB, username, role, subject string) (int, error) {
+	res, err := db.Exec("INSERT INTO users (username, role, password_hash, oidc_subject) VALUES (?, ?, '', ?)", username, role, subject)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func SetUserPassword(db *sql.DB, id int, passwordHash string) error {
+	_, err := db.Exec("UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", passwordHash, id)
+	return err
+}
+
+func SetUserRole(db *sql.DB, id int, role string) error {
+	_, err := db.Exec("UPDATE users SET role = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", role, id)
+	return err
+}
+
+func DeleteUser(db *sql.DB, id int) (bool, error) {
+	res, err := db.Exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	for _, table := range []string{"api_tokens", "sessions", "recovery_codes"} {
+		if _, err := db.Exec("DELETE FROM "+table+" WHERE user_id = ?", id); err != nil {
+			return false, err
+		}
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func RecordForbidden(db *sql.DB, a models.ForbiddenAttempt) error {
+	_, err := db.Exec(
+		"INSERT INTO forbidden_attempts (user_id, username, role, method, path, remote_addr) VALUES (?, ?, ?, ?, ?, ?)",
+		a.UserID, a.Username, a.Role, a.Method, a.Path, a.RemoteAddr,
+	)
+	return err
+}
+
+func GetForbiddenAttempts(db *sql.DB, limit int) ([]models.ForbiddenAttempt, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, username, role, method, path, remote_addr, created_at
+		FROM forbidden_attempts ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.ForbiddenAttempt{}
+	for rows.Next() {
+		var a models.ForbiddenAttempt
+		if err := rows.Scan(&a.ID, &a.UserID, &a.Username, &a.Role, &a.Method, &a.Path, &a.RemoteAddr, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
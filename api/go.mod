@@ -2,7 +2,11 @@ module github.com/wiktoz/sentry
 
 go 1.23.4
 
-require modernc.org/sqlite v1.37.1
+require (
+	golang.org/x/crypto v0.38.0
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.37.1
+)
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
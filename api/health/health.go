@@ -0,0 +1,148 @@
+// Package health checks that sentry can do its job: the database takes writes,
+// nmap and the vulners script are installed, and nmap may use raw sockets.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wiktoz/sentry/config"
+	"github.com/wiktoz/sentry/db"
+)
+
+// Check outcomes; a warning doesn't affect readiness
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+const (
+	checkTimeout = 10 * time.Second
+	cacheFor     = 15 * time.Second
+)
+
+type Check struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Detail   string `json:"detail"`
+	Required bool   `json:"required"`
+}
+
+type Report struct {
+	Ready     bool    `json:"ready"`
+	Checks    []Check `json:"checks"`
+	CheckedAt string  `json:"checked_at"`
+}
+
+// Failed lists the names of required checks that failed
+func (r Report) Failed() []string {
+	var names []string
+	for _, c := range r.Checks {
+		if c.Required && c.Status == StatusFail {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+var nmapVersionRegex = regexp.MustCompile(`Nmap version (\S+)`)
+
+// Run performs every check now
+func Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	report := Report{CheckedAt: time.Now().UTC().Format(time.RFC3339)}
+	add := func(name string, required bool, status, detail string) {
+		report.Checks = append(report.Checks, Check{Name: name, Status: status, Detail: detail, Required: required})
+	}
+
+	if err := db.CheckWritable(db.DB); err != nil {
+		add("database", true, StatusFail, "not writable: "+err.Error())
+	} else {
+		add("database", true, StatusOK, "writable")
+	}
+
+	version, err := nmapVersion(ctx)
+	if err != nil {
+		add("nmap", true, StatusFail, err.Error())
+		add("vulners", true, StatusFail, "needs nmap")
+	} else {
+		add("nmap", true, StatusOK, "version "+version+" at "+config.Current.NmapPath)
+		if err := vulnersInstalled(ctx); err != nil {
+			add("vulners", true, StatusFail, err.Error())
+		} else {
+			add("vulners", true, StatusOK, "NSE script installed")
+		}
+	}
+
+	if ok, detail := rawSockets(); ok {
+		add("raw_sockets", false, StatusOK, detail)
+	} else {
+		add("raw_sockets", false, StatusWarn, detail+"; nmap falls back to slower TCP connect scans and cannot do SYN or OS detection scans")
+	}
+
+	report.Ready = len(report.Failed()) == 0
+	return report
+}
+
+var (
+	cacheMu sync.Mutex
+	cached  Report
+	cacheAt time.Time
+)
+
+// Cached reuses a recent report so frequent probes don't spawn nmap each time
+func Cached(ctx context.Context) Report {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	if time.Since(cacheAt) > cacheFor {
+		cached, cacheAt = Run(ctx), time.Now()
+	}
+	return cached
+}
+
+// ScanPreflight tells why a scan cannot run, or returns nil when it can
+func ScanPreflight(ctx context.Context) error {
+	report := Cached(ctx)
+	for _, c := range report.Checks {
+		if c.Required && c.Status == StatusFail {
+			return fmt.Errorf("%s check failed: %s", c.Name, c.Detail)
+		}
+	}
+	return nil
+}
+
+func nmapVersion(ctx context.Context) (string, error) {
+	path, err := exec.LookPath(config.Current.NmapPath)
+	if err != nil {
+		return "", fmt.Errorf("not found: %v", err)
+	}
+
+	out, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s --version failed: %v", path, err)
+	}
+	m := nmapVersionRegex.FindSubmatch(out)
+	if m == nil {
+		return "", errors.New(path + " does not look like nmap")
+	}
+	return string(m[1]), nil
+}
+
+// vulnersInstalled asks nmap itself, so custom script directories are honored
+func vulnersInstalled(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, config.Current.NmapPath, "--script-help", "vulners").CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "vulners") {
+		return errors.New("vulners NSE script not found, install it into nmap's scripts directory")
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package health
+
+// rawSockets can't be probed here; on Windows nmap relies on Npcap instead
+func rawSockets() (bool, string) {
+	return false, "raw socket privileges cannot be checked on this platform"
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package health
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// rawSockets tries to open a raw socket, which nmap needs for SYN and OS scans.
+// nmap runs as a child process and inherits the same privileges.
+func rawSockets() (bool, string) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_TCP)
+	if err != nil {
+		return false, fmt.Sprintf("cannot open raw sockets as uid %d: %v", os.Geteuid(), err)
+	}
+	syscall.Close(fd)
+	return true, "raw sockets available"
+}
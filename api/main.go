@@ -3,104 +3,137 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/wiktoz/sentry/auth"
+	"github.com/wiktoz/sentry/certs"
+	"github.com/wiktoz/sentry/config"
 	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/metrics"
+	"github.com/wiktoz/sentry/middleware"
+	"github.com/wiktoz/sentry/notify"
 	"github.com/wiktoz/sentry/routes"
 	"github.com/wiktoz/sentry/scripts"
 
 	_ "modernc.org/sqlite"
 )
 
-func enableCORS(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*") // change to specific origin in production
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-}
-
-func withCORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w, r)
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
-func basicAuthMiddleware(next http.Handler, username, password string) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Add CORS headers here so they are included on every response
-		enableCORS(w, r)
-
-		// Allow OPTIONS requests without auth for CORS preflight
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		auth := r.Header.Get("Authorization")
-		const prefix = "Basic "
-		if !strings.HasPrefix(auth, prefix) {
-			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		payload, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		pair := strings.SplitN(string(payload), ":", 2)
-		if len(pair) != 2 || pair[0] != username || pair[1] != password {
-			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
+// notifyFlushTimeout bounds how long shutdown waits for queued notifications
+const notifyFlushTimeout = 10 * time.Second
 
 func main() {
-	// Credentials for basic auth (hardcoded here, replace with env/config for production)
-	const (
-		authUsername = "admin"
-		authPassword = "secret"
-	)
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	config.Current = cfg
+	if cfg.File != "" {
+		log.Printf("Loaded configuration from %s", cfg.File)
+	}
 
 	// DB setup
-	var err error
-	db.DB, err = sql.Open("sqlite", "./results.db")
+	db.DB, err = sql.Open("sqlite", cfg.DBPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.DB.Close()
 
 	if _, err = db.DB.Exec(db.Schema); err != nil {
 		log.Fatalf("failed to exec schema: %v", err)
 	}
+	if err = db.Migrate(db.DB); err != nil {
+		log.Fatalf("failed to migrate database: %v", err)
+	}
+
+	// First user from the environment or a one-time setup token
+	if err := auth.Bootstrap(); err != nil {
+		log.Fatalf("failed to bootstrap users: %v", err)
+	}
+
+	// Notification channels
+	if err := notify.Reload(); err != nil {
+		log.Fatalf("failed to load notifiers: %v", err)
+	}
+
+	// SIGTERM from docker/tini or Ctrl-C stops the background tasks and starts a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Retry failed notifications, including ones interrupted by the last shutdown
+	notify.StartRetry(ctx)
 
 	// Start auto scan
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
 	scripts.StartAutoScan(ctx)
 
-	// Wrap handlers with CORS and BasicAuth
+	// Watch feeds directory for KEV/EPSS updates
+	scripts.StartFeedRefresh(ctx)
+
 	apiMux := http.NewServeMux()
 
-	apiMux.Handle("/api/scan/run", withCORS(http.HandlerFunc(routes.RunScan)))
-	apiMux.Handle("/api/scan/", withCORS(http.HandlerFunc(routes.GetScanById)))
-	apiMux.Handle("/api/scans", withCORS(http.HandlerFunc(routes.GetScans)))
+	// Per-client limits on endpoints that start scans or load whole scan histories
+	scanLimit := middleware.NewLimiter(middleware.PerMinute(1), 3)
+	reportLimit := middleware.NewLimiter(middleware.PerMinute(60), 20)
+
+	apiMux.Handle("/api/scan/run", scanLimit.Wrap(http.HandlerFunc(routes.RunScan)))
+	apiMux.Handle("/api/scan/", http.HandlerFunc(routes.GetScanById))
+	apiMux.Handle("/api/scans", reportLimit.Wrap(http.HandlerFunc(routes.GetScans)))
+	apiMux.Handle("/api/findings", reportLimit.Wrap(http.HandlerFunc(routes.GetFindings)))
+	apiMux.Handle("/api/dashboard", reportLimit.Wrap(http.HandlerFunc(routes.GetDashboard)))
+
+	apiMux.Handle("/api/suppressions", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			routes.GetSuppressions(w, r)
+		case http.MethodPost:
+			routes.CreateSuppression(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	apiMux.Handle("/api/suppressions/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		routes.DeleteSuppression(w, r)
+	}))
+
+	apiMux.Handle("/api/assets", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			routes.GetAssets(w, r)
+		case http.MethodPut:
+			routes.SaveAsset(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	apiMux.Handle("/api/assets/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		routes.DeleteAsset(w, r)
+	}))
+
+	apiMux.Handle("/api/feeds", http.HandlerFunc(routes.GetFeeds))
+	apiMux.Handle("/api/feeds/refresh", http.HandlerFunc(routes.RefreshFeeds))
 
-	apiMux.Handle("/api/config", withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	apiMux.Handle("/api/config", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
 			routes.GetConfig(w, r)
@@ -109,24 +142,248 @@ func main() {
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
-	})))
+	}))
+
+	apiMux.Handle("/api/notifications/smtp", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			routes.GetSMTPSettings(w, r)
+		case http.MethodPut:
+			routes.UpdateSMTPSettings(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	apiMux.Handle("/api/notifications/test", http.HandlerFunc(routes.TestNotification))
+	apiMux.Handle("/api/notifications", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		routes.GetNotifications(w, r)
+	}))
+	apiMux.Handle("/api/notifications/", http.HandlerFunc(routes.ResendNotification))
+
+	apiMux.Handle("/api/notifiers", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			routes.GetNotifiers(w, r)
+		case http.MethodPost:
+			routes.CreateNotifier(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	apiMux.Handle("/api/notifiers/", http.HandlerFunc(routes.NotifierByID))
+
+	apiMux.Handle("/api/alerts/rules", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			routes.GetAlertRules(w, r)
+		case http.MethodPost:
+			routes.CreateAlertRule(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	apiMux.Handle("/api/alerts/rules/", http.HandlerFunc(routes.AlertRuleByID))
+	apiMux.Handle("/api/alerts/quiet-hours", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			routes.GetQuietHours(w, r)
+		case http.MethodPut:
+			routes.UpdateQuietHours(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	apiMux.Handle("/api/setup", http.HandlerFunc(routes.Setup))
+	apiMux.Handle("/api/auth/login", http.HandlerFunc(routes.Login))
+	apiMux.Handle("/api/auth/logout", http.HandlerFunc(routes.Logout))
+	apiMux.Handle("/api/auth/session", http.HandlerFunc(routes.CurrentSession))
+	apiMux.Handle("/api/auth/sessions", http.HandlerFunc(routes.GetSessions))
+	apiMux.Handle("/api/auth/sessions/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		routes.DeleteSession(w, r)
+	}))
+	apiMux.Handle("/api/auth/totp/enroll", http.HandlerFunc(routes.EnrollTOTP))
+	apiMux.Handle("/api/auth/totp/enable", http.HandlerFunc(routes.EnableTOTP))
+	apiMux.Handle("/api/auth/totp/disable", http.HandlerFunc(routes.DisableTOTP))
+	apiMux.Handle("/api/auth/oidc", http.HandlerFunc(routes.OIDCInfo))
+	apiMux.Handle("/api/auth/oidc/login", http.HandlerFunc(routes.OIDCLogin))
+	apiMux.Handle("/api/auth/oidc/callback", http.HandlerFunc(routes.OIDCCallback))
+	apiMux.Handle("/api/auth/oidc/settings", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			routes.GetOIDCSettings(w, r)
+		case http.MethodPut:
+			routes.UpdateOIDCSettings(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	apiMux.Handle("/api/users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			routes.GetUsers(w, r)
+		case http.MethodPost:
+			routes.CreateUser(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	apiMux.Handle("/api/users/", http.HandlerFunc(routes.UserByID))
+	apiMux.Handle("/api/tokens", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			routes.GetTokens(w, r)
+		case http.MethodPost:
+			routes.CreateToken(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	apiMux.Handle("/api/tokens/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		routes.DeleteToken(w, r)
+	}))
+	apiMux.Handle("/api/system/config", http.HandlerFunc(routes.GetSystemConfig))
+	apiMux.Handle("/api/system/diagnostics", http.HandlerFunc(routes.GetDiagnostics))
+	apiMux.Handle("/api/forbidden", http.HandlerFunc(routes.GetForbiddenAttempts))
+	apiMux.Handle("/api/audit", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		routes.GetAudit(w, r)
+	}))
 
 	// Static files
-	fs := http.FileServer(http.Dir("./web/dist"))
+	fs := http.FileServer(http.Dir(cfg.StaticDir))
 
 	mainMux := http.NewServeMux()
-	mainMux.Handle("/api/", apiMux) // apiMux has no withCORS wrapping now
+	mainMux.Handle("/api/", auth.Authorize(apiMux)) // role checks for every API route
 	mainMux.Handle("/", fs)
+	mainMux.HandleFunc("/healthz", routes.Healthz)
+	mainMux.HandleFunc("/readyz", routes.Readyz)
+	if cfg.Metrics.Enabled {
+		mainMux.Handle("/metrics", metrics.Handler(cfg.Metrics.Token)) // own token, not user accounts
+	}
 
-	// Wrap with CORS and basic auth in order
-	protectedMux := withCORS(basicAuthMiddleware(mainMux, authUsername, authPassword))
+	// Label request metrics with the route pattern, not the raw path
+	route := func(r *http.Request) string {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			if _, pattern := apiMux.Handler(r); pattern != "" {
+				return pattern
+			}
+			return "unmatched"
+		}
+		switch r.URL.Path {
+		case "/metrics", "/healthz", "/readyz":
+			return r.URL.Path
+		}
+		return "static"
+	}
+
+	// CORS first so preflights are answered without credentials, then authentication
+	protectedMux := middleware.CORS(middleware.CORSPolicy(cfg.CORS), auth.Middleware(mainMux))
 
 	srv := &http.Server{
-		Addr:              ":8080",
-		Handler:           protectedMux,
+		Handler:           metrics.Instrument(route, middleware.SecurityHeaders(protectedMux)),
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
-	log.Println("Server started on :8080")
-	log.Fatal(srv.ListenAndServe())
+	servers := []*http.Server{srv}
+	serveErr := make(chan error, 2)
+
+	// HTTPS unless turned off, with a configured certificate or a self-signed one
+	srv.Addr = cfg.Addr
+	if cfg.TLS.Enabled {
+		var cert *certs.Source
+		if cfg.TLS.Cert != "" {
+			cert, err = certs.FromFiles(cfg.TLS.Cert, cfg.TLS.Key)
+		} else {
+			cert, err = certs.SelfSigned(cfg.TLS.CertDir)
+			log.Printf("Using a self-signed certificate, trust %s in your browser", filepath.Join(cfg.TLS.CertDir, certs.CAFile))
+		}
+		if err != nil {
+			log.Fatalf("failed to load TLS certificate: %v", err)
+		}
+		cert.Watch(ctx)
+		srv.TLSConfig = cert.TLSConfig()
+
+		if redirect := cfg.TLS.RedirectAddr; redirect != "off" {
+			_, port, _ := net.SplitHostPort(srv.Addr)
+			redirectSrv := &http.Server{
+				Addr:              redirect,
+				Handler:           middleware.RedirectHTTPS(port),
+				ReadHeaderTimeout: 5 * time.Second,
+			}
+			servers = append(servers, redirectSrv)
+			go func() { serveErr <- redirectSrv.ListenAndServe() }()
+			log.Printf("Redirecting HTTP on %s to HTTPS", redirect)
+		}
+
+		go func() { serveErr <- srv.ListenAndServeTLS("", "") }()
+		log.Printf("Server started on %s", srv.Addr)
+	} else {
+		go func() { serveErr <- srv.ListenAndServe() }()
+		log.Printf("Server started on %s without TLS", srv.Addr)
+	}
+
+	// Run until a signal arrives or a listener fails
+	select {
+	case <-ctx.Done():
+		log.Println("Shutting down")
+	case err = <-serveErr:
+		log.Printf("Server failed: %v", err)
+	}
+	stop() // a second signal kills the process right away
+
+	shutdown(servers, time.Duration(cfg.ShutdownTimeout)*time.Second)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// shutdown stops accepting requests and scans, lets running ones finish within
+// timeout, flushes queued notifications and closes the database
+func shutdown(servers []*http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				log.Printf("HTTP server on %s did not stop in time: %v", s.Addr, err)
+			}
+		}()
+	}
+
+	if err := scripts.DrainScans(ctx); err != nil {
+		log.Printf("Running scans were interrupted: %v", err)
+	}
+	wg.Wait()
+
+	// Undelivered notifications stay in the delivery log and are retried on the next start
+	notifyCtx, cancelNotify := context.WithTimeout(context.Background(), notifyFlushTimeout)
+	defer cancelNotify()
+	if err := notify.Close(notifyCtx); err != nil {
+		log.Printf("Notifications still queued, they will be retried on next start: %v", err)
+	}
+
+	if err := db.DB.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+	log.Println("Shutdown complete")
 }
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"database/sql"
+	"sort"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+	"github.com/wiktoz/sentry/risk"
+)
+
+// Gauges describing the stored scans, read from the database on every scrape
+func init() {
+	NewGaugeFunc("sentry_scans", "Scans by status.", func() ([]Sample, error) {
+		counts, err := db.CountScansByStatus(db.DB)
+		if err != nil {
+			return nil, err
+		}
+		// Always report every state so absent ones read as 0 rather than missing
+		for _, status := range []string{db.ScanRunning, db.ScanFinished, db.ScanFailed} {
+			if _, ok := counts[status]; !ok {
+				counts[status] = 0
+			}
+		}
+		return samples(counts), nil
+	}, "status")
+
+	NewGaugeFunc("sentry_hosts_up", "Hosts found up in the latest finished scan.", func() ([]Sample, error) {
+		return fromLatestScan(func(scanID int) ([]Sample, error) {
+			n, err := db.CountHosts(db.DB, scanID)
+			return []Sample{{Value: float64(n)}}, err
+		})
+	})
+
+	NewGaugeFunc("sentry_open_ports", "Open ports in the latest finished scan by service.", func() ([]Sample, error) {
+		return fromLatestScan(func(scanID int) ([]Sample, error) {
+			counts, err := db.CountOpenPortsByService(db.DB, scanID)
+			return samples(counts), err
+		})
+	}, "service")
+
+	NewGaugeFunc("sentry_findings", "Findings in the latest finished scan by severity and status.", func() ([]Sample, error) {
+		return fromLatestScan(func(scanID int) ([]Sample, error) {
+			findings, err := db.GetFindings(db.DB, scanID)
+			if err != nil {
+				return nil, err
+			}
+
+			counts := map[[2]string]int{}
+			for _, f := range findings {
+				counts[[2]string{risk.Severity(f.Score), f.Status}]++
+			}
+			for _, sev := range []string{"critical", "high", "medium", "low", "none"} {
+				if k := [2]string{sev, models.StatusOpen}; counts[k] == 0 {
+					counts[k] = 0
+				}
+			}
+
+			var list []Sample
+			for k, n := range counts {
+				list = append(list, Sample{Labels: []string{k[0], k[1]}, Value: float64(n)})
+			}
+			sort.Slice(list, func(i, j int) bool {
+				a, b := list[i].Labels, list[j].Labels
+				return a[0] < b[0] || (a[0] == b[0] && a[1] < b[1])
+			})
+			return list, nil
+		})
+	}, "severity", "status")
+}
+
+// fromLatestScan collects samples for the newest finished scan, none before the first one
+func fromLatestScan(collect func(scanID int) ([]Sample, error)) ([]Sample, error) {
+	scanID, err := db.LatestFinishedScanID(db.DB)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return collect(scanID)
+}
+
+func samples(counts map[string]int) []Sample {
+	list := make([]Sample, 0, len(counts))
+	for _, k := range sortedKeys(counts) {
+		list = append(list, Sample{Labels: []string{k}, Value: float64(counts[k])})
+	}
+	return list
+}
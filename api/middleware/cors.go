@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// exposedHeaders are response headers cross-origin clients need to read
+const exposedHeaders = "Retry-After, X-Sentry-OTP"
+
+// CORSPolicy says which other origins may call the API from a browser
+type CORSPolicy struct {
+	AllowedOrigins   []string // "*" allows any origin, but never with credentials
+	AllowCredentials bool
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	MaxAge           int // seconds browsers may cache a preflight response
+}
+
+// CORS answers preflight requests and adds CORS headers for allowed origins.
+// Requests from any other origin get no CORS headers, so browsers block them.
+func CORS(p CORSPolicy, next http.Handler) http.Handler {
+	origins := map[string]bool{}
+	for _, o := range p.AllowedOrigins {
+		origins[strings.ToLower(o)] = true
+	}
+	methods := strings.Join(p.AllowedMethods, ", ")
+	headers := strings.Join(p.AllowedHeaders, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if origin != "" {
+			w.Header().Add("Vary", "Origin")
+		}
+
+		allow := ""
+		switch {
+		case origin == "":
+		case origins[strings.ToLower(origin)]:
+			allow = origin
+		case origins["*"]:
+			allow = "*"
+		}
+
+		if allow != "" {
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", allow)
+			if p.AllowCredentials && allow != "*" {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if preflight {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				h.Set("Access-Control-Allow-Methods", methods)
+				h.Set("Access-Control-Allow-Headers", headers)
+				if p.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(p.MaxAge))
+				}
+			} else {
+				h.Set("Access-Control-Expose-Headers", exposedHeaders)
+			}
+		}
+
+		// Preflights carry no credentials, answer them before authentication
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
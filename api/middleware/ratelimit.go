@@ -0,0 +1,88 @@
+// Package middleware holds HTTP handler wrappers shared by the API routes.
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/auth"
+)
+
+// idleBucket is how long an untouched bucket is kept; by then it would be full anyway
+const idleBucket = 10 * time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket per client: each one may make burst requests at
+// once, refilled at rate requests per second. Clients are told by user when
+// authenticated and by IP otherwise.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{rate: rate, burst: float64(burst), buckets: map[string]*bucket{}}
+}
+
+// PerMinute is a rate of n requests a minute
+func PerMinute(n int) float64 {
+	return float64(n) / 60
+}
+
+// allow takes a token for key, or returns how long until one is available
+func (l *Limiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > idleBucket {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > idleBucket {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// Wrap rejects requests over the limit with 429 and a Retry-After header
+func (l *Limiter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := "ip:" + audit.RemoteIP(r)
+		if u, ok := auth.UserFrom(r.Context()); ok {
+			key = fmt.Sprintf("user:%d", u.ID)
+		}
+
+		if ok, wait := l.allow(key, time.Now()); !ok {
+			auth.TooManyRequests(w, wait, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
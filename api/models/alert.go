@@ -0,0 +1,97 @@
+package models
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Alert rule triggers
+const (
+	TriggerFinding = "finding"
+	TriggerNewHost = "new_host"
+	TriggerNewPort = "new_port"
+)
+
+const DefaultThrottleMinutes = 24 * 60
+
+// AlertRule routes matching findings, new hosts or newly opened ports to a
+// notifier channel and/or a list of email recipients
+type AlertRule struct {
+	ID              int      `json:"id"`
+	Name            string   `json:"name"`
+	Enabled         bool     `json:"enabled"`
+	Trigger         string   `json:"trigger"`
+	MinScore        float64  `json:"min_score"`
+	KEVOnly         bool     `json:"kev_only"`
+	Port            int      `json:"port,omitempty"`
+	Service         string   `json:"service,omitempty"`
+	Targets         []string `json:"targets"`
+	ChannelID       int      `json:"channel_id,omitempty"`
+	Recipients      []string `json:"recipients"`
+	ThrottleMinutes int      `json:"throttle_minutes"`
+	CreatedAt       string   `json:"created_at"`
+}
+
+// InTargets reports whether address is one of the rule's addresses or CIDR ranges;
+// an empty target group matches every host
+func (r AlertRule) InTargets(address string) bool {
+	if len(r.Targets) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(address)
+	for _, t := range r.Targets {
+		if t == address {
+			return true
+		}
+		if prefix, perr := netip.ParsePrefix(t); perr == nil && err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesPort checks the rule's port and service filters
+func (r AlertRule) MatchesPort(port int, service string) bool {
+	if r.Port != 0 && r.Port != port {
+		return false
+	}
+	return r.Service == "" || strings.EqualFold(r.Service, service)
+}
+
+// QuietHours hold back non-critical rule alerts until the next scan digest.
+// Start and End are HH:MM; a window where End is before Start spans midnight.
+type QuietHours struct {
+	Enabled  bool   `json:"enabled"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+func (q QuietHours) Active(t time.Time) bool {
+	if !q.Enabled {
+		return false
+	}
+
+	if q.Timezone != "" {
+		if loc, err := time.LoadLocation(q.Timezone); err == nil {
+			t = t.In(loc)
+		}
+	}
+
+	start, err1 := time.Parse("15:04", q.Start)
+	end, err2 := time.Parse("15:04", q.End)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+
+	now := t.Hour()*60 + t.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+
+	if from <= to {
+		return now >= from && now < to
+	}
+	return now >= from || now < to
+}
@@ -0,0 +1,28 @@
+package models
+
+import "encoding/json"
+
+// AuditEntry is one row of the append-only audit log. Actor is empty for
+// anonymous requests and "system" for scheduled work.
+type AuditEntry struct {
+	ID         int             `json:"id"`
+	Time       string          `json:"time"`
+	ActorID    int             `json:"actor_id,omitempty"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	Target     string          `json:"target,omitempty"`
+	Detail     json.RawMessage `json:"detail,omitempty"`
+	RemoteAddr string          `json:"remote_addr,omitempty"`
+}
+
+// AuditFilter narrows an audit log query; zero values match everything.
+// Action matches exactly or as a category, so "auth" matches "auth.login".
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  string
+	Until  string
+	Limit  int
+	Oldest bool // oldest entries first, for exports
+}
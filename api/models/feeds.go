@@ -0,0 +1,40 @@
+package models
+
+// Threat intelligence feeds joined onto findings
+
+type KEVEntry struct {
+	CVEID           string `json:"cveID"`
+	VendorProject   string `json:"vendorProject"`
+	Product         string `json:"product"`
+	Name            string `json:"vulnerabilityName"`
+	DateAdded       string `json:"dateAdded"`
+	DueDate         string `json:"dueDate"`
+	KnownRansomware string `json:"knownRansomwareCampaignUse"`
+	Description     string `json:"shortDescription"`
+}
+
+type KEVCatalog struct {
+	CatalogVersion  string     `json:"catalogVersion"`
+	DateReleased    string     `json:"dateReleased"`
+	Vulnerabilities []KEVEntry `json:"vulnerabilities"`
+}
+
+type EPSSEntry struct {
+	CVEID      string
+	EPSS       float64
+	Percentile float64
+}
+
+type Enrichment struct {
+	KEV            bool    `json:"kev"`
+	KEVDateAdded   string  `json:"kev_date_added,omitempty"`
+	EPSS           float64 `json:"epss"`
+	EPSSPercentile float64 `json:"epss_percentile"`
+}
+
+type FeedStatus struct {
+	KEVCount   int    `json:"kev_count"`
+	EPSSCount  int    `json:"epss_count"`
+	EPSSDate   string `json:"epss_date"`
+	LastImport string `json:"last_import,omitempty"`
+}
@@ -0,0 +1,19 @@
+package models
+
+// OIDCSettings configure single sign-on through an OpenID Connect provider.
+// RoleMappings map values of RoleClaim (usually group names) to Sentry roles;
+// users matching none get DefaultRole, or are refused when it is empty.
+type OIDCSettings struct {
+	Enabled         bool              `json:"enabled"`
+	Name            string            `json:"name"`
+	Issuer          string            `json:"issuer"`
+	ClientID        string            `json:"client_id"`
+	ClientSecret    string            `json:"client_secret,omitempty"`
+	ClientSecretSet bool              `json:"client_secret_set"`
+	RedirectURL     string            `json:"redirect_url"`
+	Scopes          []string          `json:"scopes"`
+	UsernameClaim   string            `json:"username_claim"`
+	RoleClaim       string            `json:"role_claim"`
+	RoleMappings    map[string]string `json:"role_mappings"`
+	DefaultRole     string            `json:"default_role"`
+}
@@ -1,23 +1,35 @@
 package models
 
+import "encoding/json"
+
 // Data structures for JSON responses
 
 type ScanData struct {
-	ID    int        `json:"id"`
-	Date  string     `json:"date"`
-	Hosts []HostData `json:"hosts"`
+	ID     int        `json:"id"`
+	Date   string     `json:"date"`
+	Status string     `json:"status"`
+	Risk   float64    `json:"risk"`
+	Hosts  []HostData `json:"hosts"`
 }
 
 type HostData struct {
-	Address string     `json:"address"`
-	Ports   []PortData `json:"ports"`
+	Address     string     `json:"address"`
+	Criticality int        `json:"criticality"`
+	Risk        float64    `json:"risk"`
+	Ports       []PortData `json:"ports"`
 }
 
 type VulnerabilityData struct {
-	CVE         string  `json:"cve"`
+	ID          string  `json:"id"`
+	Type        string  `json:"type"`
+	CVE         string  `json:"cve,omitempty"`
+	Exploit     bool    `json:"exploit"`
 	Description string  `json:"description"`
 	Score       float64 `json:"score"`
 	URL         string  `json:"url"`
+	Status      string  `json:"status"`
+	Suppression int     `json:"suppression_id,omitempty"`
+	Enrichment
 }
 
 type PortData struct {
@@ -33,3 +45,199 @@ type Config struct {
 	Email         string `json:"email"`
 	ScanTarget    string `json:"target"`
 }
+
+// SMTP security modes and auth mechanisms
+const (
+	SMTPSecurityTLS      = "tls"
+	SMTPSecurityStartTLS = "starttls"
+	SMTPSecurityNone     = "none"
+
+	SMTPAuthPlain   = "plain"
+	SMTPAuthLogin   = "login"
+	SMTPAuthCRAMMD5 = "cram-md5"
+	SMTPAuthNone    = "none"
+)
+
+type SMTPSettings struct {
+	Host        string   `json:"host"`
+	Port        int      `json:"port"`
+	Security    string   `json:"security"`
+	Auth        string   `json:"auth"`
+	Username    string   `json:"username"`
+	Password    string   `json:"password,omitempty"`
+	PasswordSet bool     `json:"password_set"`
+	From        string   `json:"from"`
+	Recipients  []string `json:"recipients"`
+	UIURL       string   `json:"ui_url"`
+	AttachCSV   bool     `json:"attach_csv"`
+}
+
+type Finding struct {
+	ScanID      int    `json:"scan_id"`
+	Address     string `json:"address"`
+	PortNum     int    `json:"port_num"`
+	Protocol    string `json:"protocol"`
+	ServiceName string `json:"service_name"`
+	New         bool   `json:"new,omitempty"`
+	VulnerabilityData
+}
+
+// PortChange describes a port that opened or closed compared to the previous scan
+type PortChange struct {
+	PortNum     int    `json:"port_num"`
+	Protocol    string `json:"protocol"`
+	ServiceName string `json:"service_name"`
+	Change      string `json:"change"`
+}
+
+// Notification channel as stored in the DB; Config holds type-specific JSON settings
+type Channel struct {
+	ID        int             `json:"id"`
+	Name      string          `json:"name"`
+	Type      string          `json:"type"`
+	Enabled   bool            `json:"enabled"`
+	Events    []string        `json:"events"`
+	Config    json.RawMessage `json:"config"`
+	CreatedAt string          `json:"created_at"`
+}
+
+type AffectedPort struct {
+	Address  string `json:"address"`
+	PortNum  int    `json:"port_num"`
+	Protocol string `json:"protocol"`
+}
+
+type CVEGroup struct {
+	CVE      string         `json:"cve,omitempty"`
+	Score    float64        `json:"score"`
+	Exploit  bool           `json:"exploit"`
+	IDs      []string       `json:"ids"`
+	Affected []AffectedPort `json:"affected"`
+	Enrichment
+}
+
+type Asset struct {
+	Address     string `json:"address"`
+	Name        string `json:"name"`
+	Criticality int    `json:"criticality"`
+}
+
+type HostRisk struct {
+	Address     string  `json:"address"`
+	Criticality int     `json:"criticality"`
+	Risk        float64 `json:"risk"`
+	OpenPorts   int     `json:"open_ports"`
+	Findings    int     `json:"findings"`
+	MaxScore    float64 `json:"max_score"`
+	KEV         int     `json:"kev"`
+	Exploits    int     `json:"exploits"`
+}
+
+type TrendPoint struct {
+	ScanID   int     `json:"scan_id"`
+	Date     string  `json:"date"`
+	Risk     float64 `json:"risk"`
+	Hosts    int     `json:"hosts"`
+	Findings int     `json:"findings"`
+}
+
+type Dashboard struct {
+	ScanID           int            `json:"scan_id"`
+	Date             string         `json:"date"`
+	Risk             float64        `json:"risk"`
+	TopHosts         []HostRisk     `json:"top_hosts"`
+	FindingSeverity  map[string]int `json:"finding_severity"`
+	HostSeverity     map[string]int `json:"host_severity"`
+	Trend            []TrendPoint   `json:"trend"`
+	NewFindings      int            `json:"new_findings"`
+	ResolvedFindings int            `json:"resolved_findings"`
+	NewHosts         int            `json:"new_hosts"`
+}
+
+// Notification delivery states; failed ones are retried until they go dead
+const (
+	DeliveryPending = "pending"
+	DeliverySent    = "sent"
+	DeliveryFailed  = "failed"
+	DeliveryDead    = "dead"
+)
+
+// Notification is one event delivered, or being delivered, to one notifier
+type Notification struct {
+	ID          int             `json:"id"`
+	Target      string          `json:"target"`
+	Channel     string          `json:"channel"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	NextAttempt string          `json:"next_attempt_at,omitempty"`
+	CreatedAt   string          `json:"created_at"`
+	UpdatedAt   string          `json:"updated_at"`
+}
+
+// User roles, each one includes the permissions of the previous
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+type User struct {
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	SSO          bool   `json:"sso"`
+	PasswordHash string `json:"-"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+// Session is a browser login; the cookie token and CSRF token are never listed
+type Session struct {
+	ID         int    `json:"id"`
+	UserID     int    `json:"user_id"`
+	Username   string `json:"username"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	ExpiresAt  string `json:"expires_at"`
+	Current    bool   `json:"current"`
+	CSRFToken  string `json:"-"`
+}
+
+type ForbiddenAttempt struct {
+	ID         int    `json:"id"`
+	UserID     int    `json:"user_id"`
+	Username   string `json:"username"`
+	Role       string `json:"role"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	RemoteAddr string `json:"remote_addr"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// API token scopes, mapped onto the viewer, operator and admin roles
+const (
+	ScopeRead  = "read"
+	ScopeScan  = "scan"
+	ScopeAdmin = "admin"
+)
+
+type APIToken struct {
+	ID         int      `json:"id"`
+	UserID     int      `json:"user_id"`
+	Username   string   `json:"username"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	CreatedAt  string   `json:"created_at"`
+
+	// Only set in the create response, it cannot be retrieved later
+	Token string `json:"token,omitempty"`
+}
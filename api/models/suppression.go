@@ -0,0 +1,55 @@
+package models
+
+import "strings"
+
+// Finding statuses; anything other than open is excluded from alerts and risk scores
+const (
+	StatusOpen          = "open"
+	StatusFalsePositive = "false_positive"
+	StatusAcceptedRisk  = "accepted_risk"
+)
+
+// Suppression silences a vulnerability on one host port, or network-wide when Address is empty
+type Suppression struct {
+	ID            int    `json:"id"`
+	Address       string `json:"address,omitempty"`
+	Port          int    `json:"port,omitempty"`
+	Protocol      string `json:"protocol,omitempty"`
+	VulnID        string `json:"vuln_id"`
+	Status        string `json:"status"`
+	Justification string `json:"justification"`
+	Owner         string `json:"owner"`
+	ExpiresAt     string `json:"expires_at,omitempty"`
+	CreatedAt     string `json:"created_at"`
+	Active        bool   `json:"active"`
+}
+
+func (s Suppression) Matches(address string, port int, protocol string, v VulnerabilityData) bool {
+	if !strings.EqualFold(s.VulnID, v.ID) && (v.CVE == "" || !strings.EqualFold(s.VulnID, v.CVE)) {
+		return false
+	}
+	if s.Address == "" {
+		return true
+	}
+	if s.Address != address {
+		return false
+	}
+	if s.Port != 0 && s.Port != port {
+		return false
+	}
+	return s.Protocol == "" || s.Protocol == protocol
+}
+
+// ApplySuppressions sets the status of v from the first matching active suppression
+func ApplySuppressions(sups []Suppression, address string, port int, protocol string, v *VulnerabilityData) {
+	v.Status = StatusOpen
+	v.Suppression = 0
+
+	for _, s := range sups {
+		if s.Matches(address, port, protocol, *v) {
+			v.Status = s.Status
+			v.Suppression = s.ID
+			return
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package notify
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/wiktoz/sentry/models"
+	"github.com/wiktoz/sentry/risk"
+)
+
+const maxListedFindings = 5
+
+var severityRank = map[string]int{
+	"none":     0,
+	"low":      1,
+	"medium":   2,
+	"high":     3,
+	"critical": 4,
+}
+
+// chatConfig holds the settings shared by all chat channels
+type chatConfig struct {
+	MinSeverity string `json:"min_severity"`
+	UIURL       string `json:"ui_url"`
+}
+
+func (c chatConfig) validate() error {
+	if c.MinSeverity != "" {
+		if _, ok := severityRank[c.MinSeverity]; !ok {
+			return fmt.Errorf("min_severity must be one of none, low, medium, high, critical")
+		}
+	}
+	if c.UIURL != "" {
+		if err := validateURL(c.UIURL); err != nil {
+			return fmt.Errorf("invalid ui_url: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c chatConfig) passes(score float64) bool {
+	return severityRank[risk.Severity(score)] >= severityRank[c.MinSeverity]
+}
+
+func (c chatConfig) scanLink(scanID int) string {
+	return scanLink(c.UIURL, scanID)
+}
+
+// scanLink points at the scans page of the web UI
+func scanLink(uiURL string, scanID int) string {
+	if uiURL == "" || scanID == 0 {
+		return ""
+	}
+	return strings.TrimRight(uiURL, "/") + "/?page=Scans&scan=" + strconv.Itoa(scanID)
+}
+
+type field struct {
+	Name  string
+	Value string
+}
+
+// message is the channel-neutral form of an event that each chat format renders
+type message struct {
+	Title    string
+	Text     string
+	Severity string
+	Fields   []field
+	Lines    []string
+	Link     string
+}
+
+// buildMessage turns an event into a chat message, or returns false if the
+// channel's severity filter drops it
+func buildMessage(ev Event, cfg chatConfig) (message, bool) {
+	msg := message{
+		Text:     ev.Message,
+		Severity: "none",
+		Link:     cfg.scanLink(ev.ScanID),
+	}
+
+	switch ev.Type {
+	case EventScanStarted:
+		msg.Title = fmt.Sprintf("Scan #%d started", ev.ScanID)
+
+	case EventScanFailed:
+		msg.Title = fmt.Sprintf("Scan #%d failed", ev.ScanID)
+		msg.Severity = "high"
+
+	case EventScanFinished:
+		msg.Title = fmt.Sprintf("Scan #%d finished", ev.ScanID)
+		if ev.ScanID == 0 {
+			msg.Title = "Sentry test notification"
+		}
+
+		counts := map[string]int{}
+		for _, f := range ev.Findings {
+			if !cfg.passes(f.Score) {
+				continue
+			}
+			sev := risk.Severity(f.Score)
+			counts[sev]++
+			if severityRank[sev] > severityRank[msg.Severity] {
+				msg.Severity = sev
+			}
+			if len(msg.Lines) < maxListedFindings {
+				msg.Lines = append(msg.Lines, findingLine(f))
+			}
+		}
+
+		for _, sev := range []string{"critical", "high", "medium", "low"} {
+			if counts[sev] > 0 {
+				msg.Fields = append(msg.Fields, field{strings.ToUpper(sev[:1]) + sev[1:], strconv.Itoa(counts[sev])})
+			}
+		}
+
+	case EventNewFinding:
+		f := ev.Finding
+		if f == nil || !cfg.passes(f.Score) {
+			return message{}, false
+		}
+
+		msg.Severity = risk.Severity(f.Score)
+		msg.Title = fmt.Sprintf("New %s finding: %s", msg.Severity, f.ID)
+		msg.Text = f.Description
+		msg.Fields = []field{
+			{"Host", f.Address},
+			{"Port", fmt.Sprintf("%d/%s %s", f.PortNum, f.Protocol, f.ServiceName)},
+			{"CVSS", fmt.Sprintf("%.1f", f.Score)},
+		}
+		if f.CVE != "" && f.CVE != f.ID {
+			msg.Fields = append(msg.Fields, field{"CVE", f.CVE})
+		}
+		if f.KEV {
+			msg.Fields = append(msg.Fields, field{"Known exploited", "yes, since " + f.KEVDateAdded})
+		}
+		if f.Exploit {
+			msg.Fields = append(msg.Fields, field{"Public exploit", "yes"})
+		}
+		if f.EPSS > 0 {
+			msg.Fields = append(msg.Fields, field{"EPSS", fmt.Sprintf("%.1f%%", f.EPSS*100)})
+		}
+		if f.URL != "" {
+			msg.Fields = append(msg.Fields, field{"Reference", f.URL})
+		}
+
+	case EventNewHost:
+		msg.Title = "New host: " + ev.Host
+
+	case EventBaselineDeviation:
+		msg.Title = "Port changes on " + ev.Host
+		msg.Severity = "medium"
+		for _, c := range ev.Changes {
+			msg.Lines = append(msg.Lines, fmt.Sprintf("%d/%s %s %s", c.PortNum, c.Protocol, c.ServiceName, c.Change))
+		}
+
+	default:
+		msg.Title = string(ev.Type)
+	}
+
+	return msg, true
+}
+
+func findingLine(f models.Finding) string {
+	line := fmt.Sprintf("%s (%.1f) on %s:%d", f.ID, f.Score, f.Address, f.PortNum)
+	if f.KEV {
+		line += " - actively exploited"
+	}
+	return line
+}
+
+func severityColor(sev string) int {
+	switch sev {
+	case "critical":
+		return 0x8b0000
+	case "high":
+		return 0xd9534f
+	case "medium":
+		return 0xf0ad4e
+	case "low":
+		return 0x5bc0de
+	default:
+		return 0x6c757d
+	}
+}
+
+// withPath appends to a base URL, for APIs that take credentials in the path
+func withPath(base, path string) string {
+	u, err := url.Parse(strings.TrimRight(base, "/"))
+	if err != nil {
+		return base + path
+	}
+	u.Path += path
+	return u.String()
+}
@@ -0,0 +1,370 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+// chatRequest is one request received by a chatServer
+type chatRequest struct {
+	Path string
+	Body map[string]any
+}
+
+// chatServer records the JSON posted to it and answers with the status codes
+// from replies in turn, then 200
+type chatServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []chatRequest
+	replies  []int
+}
+
+func startChat(t *testing.T, replies ...int) *chatServer {
+	t.Helper()
+
+	s := &chatServer{replies: replies}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("request body is not JSON: %s", data)
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, chatRequest{Path: r.URL.Path, Body: body})
+		status := http.StatusOK
+		if len(s.replies) > 0 {
+			status, s.replies = s.replies[0], s.replies[1:]
+		}
+		s.mu.Unlock()
+
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"status": %d}`, status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *chatServer) received() []chatRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]chatRequest(nil), s.requests...)
+}
+
+// chatNotifier builds a channel of type typ whose webhook points at srv
+func chatNotifier(t *testing.T, typ string, srv *chatServer, extra string) Notifier {
+	t.Helper()
+
+	config := fmt.Sprintf(`{"webhook_url": %q, "ui_url": "https://sentry.example.com/"%s}`, srv.URL+"/hook", extra)
+	if typ == "telegram" {
+		config = fmt.Sprintf(`{"bot_token": "123:abc", "chat_id": "-42", "api_url": %q, "ui_url": "https://sentry.example.com/"%s}`, srv.URL, extra)
+	}
+
+	n, err := Build(models.Channel{Name: typ, Type: typ, Config: json.RawMessage(config)})
+	if err != nil {
+		t.Fatalf("build %s: %v", typ, err)
+	}
+	return n
+}
+
+func findingEvent(score float64) Event {
+	ev := NewEvent(EventNewFinding, 12)
+	ev.Finding = &models.Finding{
+		Address:     "10.0.0.5",
+		PortNum:     22,
+		Protocol:    "tcp",
+		ServiceName: "ssh",
+		VulnerabilityData: models.VulnerabilityData{
+			ID:          "CVE-2024-6387",
+			Score:       score,
+			Description: "regreSSHion <race>",
+			URL:         "https://vulners.com/cve/CVE-2024-6387",
+			Enrichment:  models.Enrichment{KEV: true, KEVDateAdded: "2024-07-01"},
+		},
+	}
+	return ev
+}
+
+// path walks nested JSON objects and arrays, e.g. path(body, "embeds", 0, "title")
+func path(v any, keys ...any) any {
+	for _, k := range keys {
+		switch k := k.(type) {
+		case string:
+			m, _ := v.(map[string]any)
+			v = m[k]
+		case int:
+			a, _ := v.([]any)
+			if k >= len(a) {
+				return nil
+			}
+			v = a[k]
+		}
+	}
+	return v
+}
+
+const scanURL = "https://sentry.example.com/?page=Scans&scan=12"
+
+func TestSlackPayload(t *testing.T) {
+	srv := startChat(t)
+	if err := chatNotifier(t, "slack", srv, "").Notify(context.Background(), findingEvent(9.8)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := srv.received()
+	if len(got) != 1 || got[0].Path != "/hook" {
+		t.Fatalf("requests = %+v, want one POST to the webhook", got)
+	}
+	body := got[0].Body
+
+	if title := path(body, "text"); title != "New critical finding: CVE-2024-6387" {
+		t.Errorf("text = %v", title)
+	}
+	if typ := path(body, "blocks", 0, "type"); typ != "header" {
+		t.Errorf("first block is %v, want header", typ)
+	}
+	if text := path(body, "blocks", 1, "text", "text"); text != "regreSSHion &lt;race&gt;" {
+		t.Errorf("section text = %v, want it escaped for mrkdwn", text)
+	}
+	if host := path(body, "blocks", 2, "fields", 0, "text"); host != "*Host*\n10.0.0.5" {
+		t.Errorf("first field = %v", host)
+	}
+
+	blocks, _ := body["blocks"].([]any)
+	if link := path(blocks[len(blocks)-1], "elements", 0, "url"); link != scanURL {
+		t.Errorf("button url = %v, want %s", link, scanURL)
+	}
+}
+
+func TestTeamsPayload(t *testing.T) {
+	srv := startChat(t)
+	if err := chatNotifier(t, "teams", srv, "").Notify(context.Background(), findingEvent(9.8)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := srv.received()
+	if len(got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(got))
+	}
+	body := got[0].Body
+
+	if typ := path(body, "type"); typ != "message" {
+		t.Errorf("type = %v", typ)
+	}
+	attachment := path(body, "attachments", 0)
+	if ct := path(attachment, "contentType"); ct != "application/vnd.microsoft.card.adaptive" {
+		t.Errorf("contentType = %v", ct)
+	}
+
+	card := path(attachment, "content")
+	if typ := path(card, "type"); typ != "AdaptiveCard" {
+		t.Errorf("card type = %v", typ)
+	}
+	if color := path(card, "body", 0, "color"); color != "Attention" {
+		t.Errorf("title color = %v, want Attention for a critical finding", color)
+	}
+	if text := path(card, "body", 1, "text"); text != "regreSSHion <race>" {
+		t.Errorf("description = %v", text)
+	}
+	if fact := path(card, "body", 2, "facts", 0); path(fact, "title") != "Host" || path(fact, "value") != "10.0.0.5" {
+		t.Errorf("first fact = %v", fact)
+	}
+	if link := path(card, "actions", 0, "url"); link != scanURL {
+		t.Errorf("action url = %v, want %s", link, scanURL)
+	}
+}
+
+func TestDiscordPayload(t *testing.T) {
+	srv := startChat(t)
+	ev := findingEvent(9.8)
+	if err := chatNotifier(t, "discord", srv, "").Notify(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+
+	got := srv.received()
+	if len(got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(got))
+	}
+	body := got[0].Body
+
+	if user := path(body, "username"); user != "Sentry" {
+		t.Errorf("username = %v", user)
+	}
+	embed := path(body, "embeds", 0)
+	if title := path(embed, "title"); title != "New critical finding: CVE-2024-6387" {
+		t.Errorf("title = %v", title)
+	}
+	if color := path(embed, "color"); color != float64(severityColor("critical")) {
+		t.Errorf("color = %v", color)
+	}
+	if link := path(embed, "url"); link != scanURL {
+		t.Errorf("url = %v, want %s", link, scanURL)
+	}
+	if ts := path(embed, "timestamp"); ts != ev.Time.Format(time.RFC3339) {
+		t.Errorf("timestamp = %v", ts)
+	}
+	if field := path(embed, "fields", 0); path(field, "name") != "Host" || path(field, "inline") != true {
+		t.Errorf("first field = %v", field)
+	}
+}
+
+func TestTelegramPayload(t *testing.T) {
+	srv := startChat(t)
+	if err := chatNotifier(t, "telegram", srv, "").Notify(context.Background(), findingEvent(9.8)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := srv.received()
+	if len(got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(got))
+	}
+	if got[0].Path != "/bot123:abc/sendMessage" {
+		t.Errorf("path = %s, want the bot token in the path", got[0].Path)
+	}
+
+	body := got[0].Body
+	if path(body, "chat_id") != "-42" || path(body, "parse_mode") != "HTML" || path(body, "disable_web_page_preview") != true {
+		t.Errorf("body = %v", body)
+	}
+	text, _ := body["text"].(string)
+	for _, want := range []string{
+		"<b>New critical finding: CVE-2024-6387</b>",
+		"regreSSHion &lt;race&gt;",
+		"<b>Host:</b> 10.0.0.5",
+		`<a href="https://sentry.example.com/?page=Scans&amp;scan=12">Open in Sentry</a>`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text lacks %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestChatNoLinkWithoutUIURL(t *testing.T) {
+	srv := startChat(t)
+	n, err := Build(models.Channel{Name: "slack", Type: "slack", Config: json.RawMessage(`{"webhook_url": "` + srv.URL + `"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), findingEvent(9.8)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range path(srv.received()[0].Body, "blocks").([]any) {
+		if path(block, "type") == "actions" {
+			t.Error("message has a link button without ui_url")
+		}
+	}
+}
+
+func TestChatSeverityFilter(t *testing.T) {
+	for _, typ := range []string{"slack", "teams", "discord", "telegram"} {
+		t.Run(typ, func(t *testing.T) {
+			srv := startChat(t)
+			n := chatNotifier(t, typ, srv, `, "min_severity": "high"`)
+
+			// A medium finding is dropped without a request
+			if err := n.Notify(context.Background(), findingEvent(5.3)); err != nil {
+				t.Fatal(err)
+			}
+			if got := srv.received(); len(got) != 0 {
+				t.Fatalf("medium finding was sent: %+v", got)
+			}
+
+			if err := n.Notify(context.Background(), findingEvent(7.5)); err != nil {
+				t.Fatal(err)
+			}
+			if got := srv.received(); len(got) != 1 {
+				t.Fatalf("high finding: got %d requests, want 1", len(got))
+			}
+		})
+	}
+}
+
+func TestChatSeverityFilterScanSummary(t *testing.T) {
+	ev := NewEvent(EventScanFinished, 12)
+	for i, score := range []float64{9.8, 7.5, 5.3, 2.1} {
+		f := findingEvent(score).Finding
+		f.ID = fmt.Sprintf("CVE-2024-000%d", i)
+		ev.Findings = append(ev.Findings, *f)
+	}
+
+	msg, ok := buildMessage(ev, chatConfig{MinSeverity: "high"})
+	if !ok {
+		t.Fatal("scan summary was dropped")
+	}
+	if len(msg.Lines) != 2 || len(msg.Fields) != 2 {
+		t.Errorf("summary lists %d findings in %d severities, want the critical and high one only", len(msg.Lines), len(msg.Fields))
+	}
+	if msg.Severity != "critical" {
+		t.Errorf("severity = %s", msg.Severity)
+	}
+}
+
+func TestChatClientError(t *testing.T) {
+	for _, typ := range []string{"slack", "teams", "discord", "telegram"} {
+		t.Run(typ, func(t *testing.T) {
+			srv := startChat(t, http.StatusBadRequest)
+
+			err := chatNotifier(t, typ, srv, "").Notify(context.Background(), findingEvent(9.8))
+			if err == nil || !strings.Contains(err.Error(), "400 Bad Request") {
+				t.Fatalf("err = %v, want the 400 reported", err)
+			}
+			if n := len(srv.received()); n != 1 {
+				t.Errorf("got %d requests, a 4xx must not be retried", n)
+			}
+		})
+	}
+}
+
+func TestChatRateLimited(t *testing.T) {
+	srv := startChat(t, http.StatusTooManyRequests)
+
+	start := time.Now()
+	if err := chatNotifier(t, "discord", srv, "").Notify(context.Background(), findingEvent(9.8)); err != nil {
+		t.Fatalf("429 then 200: %v", err)
+	}
+	if n := len(srv.received()); n != 2 {
+		t.Errorf("got %d requests, want a retry after the 429", n)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, before Retry-After elapsed", elapsed)
+	}
+}
+
+func TestChatRetryGivesUp(t *testing.T) {
+	srv := startChat(t, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err := chatNotifier(t, "slack", srv, "").Notify(ctx, findingEvent(9.8))
+	if err == nil || !strings.Contains(err.Error(), "429") || !strings.Contains(err.Error(), "gave up") {
+		t.Fatalf("err = %v, want the 429 and that the retry gave up", err)
+	}
+}
+
+func TestChatServerError(t *testing.T) {
+	srv := startChat(t, http.StatusBadGateway)
+
+	if err := chatNotifier(t, "teams", srv, "").Notify(context.Background(), findingEvent(9.8)); err != nil {
+		t.Fatalf("502 then 200: %v", err)
+	}
+	if n := len(srv.received()); n != 2 {
+		t.Errorf("got %d requests, want the 5xx retried", n)
+	}
+}
@@ -0,0 +1,171 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/metrics"
+	"github.com/wiktoz/sentry/models"
+)
+
+const (
+	maxAttempts  = 8
+	retryBackoff = time.Minute
+	maxRetryWait = 6 * time.Hour
+	retryPoll    = 30 * time.Second
+)
+
+// Targets identify a notifier in the delivery log across restarts and reloads
+const emailTarget = "email"
+
+func channelTarget(id int) string { return "channel:" + strconv.Itoa(id) }
+func ruleTarget(id int) string    { return "rule:" + strconv.Itoa(id) }
+
+var deliveries = metrics.NewCounterVec("sentry_notifications_total",
+	"Notification delivery attempts by notifier and result (sent, failed or dead).", "notifier", "result")
+
+var ErrTargetGone = errors.New("notifier no longer exists or is disabled")
+
+// delivery is one queued attempt; id is its notifications row, 0 if logging failed
+type delivery struct {
+	id int
+	ev Event
+}
+
+// retryDelay doubles from one minute per failed attempt, capped at six hours
+func retryDelay(attempts int) time.Duration {
+	delay := retryBackoff
+	for i := 1; i < attempts && delay < maxRetryWait; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryWait)
+}
+
+// finish records the outcome of a delivery and schedules a retry or dead-letters it
+func finish(name string, d delivery, deliveryErr error) {
+	if deliveryErr == nil {
+		deliveries.Inc(name, models.DeliverySent)
+		if d.id != 0 {
+			if err := db.MarkNotificationSent(db.DB, d.id); err != nil {
+				log.Printf("Notification %d: failed to mark as sent: %v", d.id, err)
+			}
+		}
+		return
+	}
+
+	log.Printf("Notifier %s failed on %s: %v", name, d.ev.Type, deliveryErr)
+	if d.id == 0 {
+		deliveries.Inc(name, models.DeliveryFailed)
+		return
+	}
+
+	attempts, err := db.FailNotification(db.DB, d.id, deliveryErr.Error())
+	result := models.DeliveryFailed
+	switch {
+	case err != nil:
+		log.Printf("Notification %d: failed to record error: %v", d.id, err)
+	case attempts >= maxAttempts:
+		log.Printf("Notification %d to %s gave up after %d attempts", d.id, name, attempts)
+		result = models.DeliveryDead
+		err = db.MarkNotificationDead(db.DB, d.id)
+	default:
+		err = db.ScheduleRetry(db.DB, d.id, time.Now().Add(retryDelay(attempts)))
+	}
+	deliveries.Inc(name, result)
+	if err != nil {
+		log.Printf("Notification %d: %v", d.id, err)
+	}
+}
+
+// retry queues a logged notification on its notifier again
+func (r *Registry) retry(id int, target string, payload []byte, resetAttempts bool) error {
+	var ev Event
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		return fmt.Errorf("invalid payload: %v", err)
+	}
+
+	// Hold the lock until the push: Reload and Close close the old workers' queues
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	w, ok := r.byTarget[target]
+	if !ok {
+		return ErrTargetGone
+	}
+
+	claimed, err := db.ClaimNotification(db.DB, id, resetAttempts)
+	if err != nil || !claimed {
+		return err
+	}
+
+	w.push(delivery{id: id, ev: ev})
+	return nil
+}
+
+// Resend queues a logged notification again regardless of its status and attempts
+func (r *Registry) Resend(id int) error {
+	n, err := db.GetNotification(db.DB, id)
+	if err != nil {
+		return err
+	}
+	return r.retry(n.ID, n.Target, n.Payload, true)
+}
+
+// retryDue queues every failed notification whose backoff has elapsed
+func (r *Registry) retryDue() {
+	due, err := db.DueNotifications(db.DB, time.Now())
+	if err != nil {
+		log.Printf("Failed to load notifications to retry: %v", err)
+		return
+	}
+
+	for _, n := range due {
+		err := r.retry(n.ID, n.Target, n.Payload, false)
+		if errors.Is(err, ErrTargetGone) {
+			if _, ferr := db.FailNotification(db.DB, n.ID, err.Error()); ferr == nil {
+				err = db.MarkNotificationDead(db.DB, n.ID)
+			}
+		}
+		if err != nil {
+			log.Printf("Notification %d: retry failed: %v", n.ID, err)
+		}
+	}
+}
+
+// StartRetry re-queues deliveries interrupted by the last shutdown and then
+// retries failed notifications as their backoff elapses. The same loop sends
+// the alerts held during quiet hours once they end.
+func (r *Registry) StartRetry(ctx context.Context) {
+	if err := db.RequeueInterrupted(db.DB, time.Now()); err != nil {
+		log.Printf("Failed to requeue interrupted notifications: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(retryPoll)
+		defer ticker.Stop()
+
+		for {
+			r.retryDue()
+			r.flushHeld()
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func Resend(id int) error {
+	return Default.Resend(id)
+}
+
+func StartRetry(ctx context.Context) {
+	Default.StartRetry(ctx)
+}
@@ -0,0 +1,266 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	htmltemplate "html/template"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+	"github.com/wiktoz/sentry/risk"
+)
+
+var digestSeverities = []string{"critical", "high", "medium", "low", "none"}
+
+type digestFinding struct {
+	models.Finding
+	Notes []string
+}
+
+type digestSection struct {
+	Severity string
+	Color    string
+	Findings []digestFinding
+}
+
+type digestHost struct {
+	Address     string
+	Criticality int
+	Risk        float64
+	Findings    int
+	Worst       string
+}
+
+type severityCount struct {
+	Severity string
+	Color    string
+	Count    int
+}
+
+type digestData struct {
+	ScanID   int
+	Date     string
+	Risk     float64
+	Total    int
+	New      int
+	KEV      int
+	Exploits int
+	Counts   []severityCount
+	Hosts    []digestHost
+	Sections []digestSection
+	Held     []string
+	ScanURL  string
+}
+
+var digestFuncs = map[string]any{
+	"title": func(s string) string { return strings.ToUpper(s[:1]) + s[1:] },
+	"join":  strings.Join,
+}
+
+var digestHTML = htmltemplate.Must(htmltemplate.New("digest").Funcs(digestFuncs).Parse(`<html><body style="font-family: sans-serif; color: #222;">
+<h2>Scan #{{.ScanID}} report</h2>
+<p>{{.Date}}{{if .ScanURL}} - <a href="{{.ScanURL}}">open in Sentry</a>{{end}}</p>
+
+<table cellpadding="6" style="border-collapse: collapse;">
+<tr><td>Network risk</td><td><b>{{printf "%.1f" .Risk}}</b> / 100</td></tr>
+<tr><td>Findings</td><td><b>{{.Total}}</b>{{if .New}} ({{.New}} new){{end}}</td></tr>
+{{range .Counts}}<tr><td style="color: {{.Color}};">{{title .Severity}}</td><td>{{.Count}}</td></tr>
+{{end}}{{if .KEV}}<tr><td>Actively exploited (KEV)</td><td><b style="color: #d9534f;">{{.KEV}}</b></td></tr>
+{{end}}{{if .Exploits}}<tr><td>Public exploit available</td><td>{{.Exploits}}</td></tr>
+{{end}}</table>
+
+<h3>Hosts</h3>
+<table cellpadding="6" border="1" style="border-collapse: collapse; border-color: #ddd;">
+<tr><th align="left">Host</th><th>Criticality</th><th>Risk</th><th>Findings</th><th>Worst</th></tr>
+{{range .Hosts}}<tr><td>{{.Address}}</td><td align="center">{{.Criticality}}</td><td align="right">{{printf "%.1f" .Risk}}</td><td align="right">{{.Findings}}</td><td>{{.Worst}}</td></tr>
+{{end}}</table>
+{{range .Sections}}
+<h3 style="color: {{.Color}};">{{title .Severity}} ({{len .Findings}})</h3>
+<ul>
+{{range .Findings}}<li>{{if .URL}}<a href="{{.URL}}">{{.ID}}</a>{{else}}{{.ID}}{{end}} ({{printf "%.1f" .Score}}) on {{.Address}}:{{.PortNum}}/{{.Protocol}} {{.ServiceName}}{{if .New}} <b>NEW</b>{{end}}{{if .Notes}} - {{if .KEV}}<b style="color: #d9534f;">{{join .Notes ", "}}</b>{{else}}{{join .Notes ", "}}{{end}}{{end}}</li>
+{{end}}</ul>
+{{end}}{{if .Held}}
+<h3>Held during quiet hours ({{len .Held}})</h3>
+<p>Non-critical alerts raised while quiet hours were on, batched into this report.</p>
+<ul>
+{{range .Held}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}
+</body></html>`))
+
+var digestText = texttemplate.Must(texttemplate.New("digest").Funcs(digestFuncs).Parse(`Scan #{{.ScanID}} report
+{{.Date}}
+{{if .ScanURL}}{{.ScanURL}}
+{{end}}
+Network risk: {{printf "%.1f" .Risk}} / 100
+Findings: {{.Total}}{{if .New}} ({{.New}} new){{end}}
+{{range .Counts}}  {{title .Severity}}: {{.Count}}
+{{end}}{{if .KEV}}Actively exploited (KEV): {{.KEV}}
+{{end}}{{if .Exploits}}Public exploit available: {{.Exploits}}
+{{end}}
+Hosts
+{{range .Hosts}}  {{.Address}}  criticality {{.Criticality}}, risk {{printf "%.1f" .Risk}}, {{.Findings}} findings{{if .Worst}}, worst {{.Worst}}{{end}}
+{{end}}{{range .Sections}}
+{{title .Severity}} ({{len .Findings}})
+{{range .Findings}}  - {{.ID}} ({{printf "%.1f" .Score}}) on {{.Address}}:{{.PortNum}}/{{.Protocol}} {{.ServiceName}}{{if .New}} [NEW]{{end}}{{if .Notes}} - {{join .Notes ", "}}{{end}}{{if .URL}}
+    {{.URL}}{{end}}
+{{end}}{{end}}{{if .Held}}
+Held during quiet hours ({{len .Held}})
+{{range .Held}}  - {{.}}
+{{end}}{{end}}`))
+
+// renderDigest builds the per-scan digest email from a scan.finished event
+// and the alerts currently held back by quiet hours
+func renderDigest(ev Event, settings models.SMTPSettings, held []string) (Mail, error) {
+	data := digestData{
+		Held:    held,
+		ScanID:  ev.ScanID,
+		Date:    ev.Time.Format("2006-01-02 15:04 MST"),
+		Total:   len(ev.Findings),
+		ScanURL: scanLink(settings.UIURL, ev.ScanID),
+	}
+
+	hosts := map[string]*digestHost{}
+	var order []string
+
+	if scan, err := db.GetScanData(db.DB, ev.ScanID); err == nil {
+		data.Risk = scan.Risk
+		for _, h := range scan.Hosts {
+			hosts[h.Address] = &digestHost{Address: h.Address, Criticality: h.Criticality, Risk: h.Risk}
+			order = append(order, h.Address)
+		}
+	}
+
+	sections := map[string]*digestSection{}
+	for _, sev := range digestSeverities {
+		sections[sev] = &digestSection{Severity: sev, Color: fmt.Sprintf("#%06x", severityColor(sev))}
+	}
+
+	findings := append([]models.Finding(nil), ev.Findings...)
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].KEV != findings[j].KEV {
+			return findings[i].KEV
+		}
+		return findings[i].Score > findings[j].Score
+	})
+
+	for _, f := range findings {
+		sev := risk.Severity(f.Score)
+		sections[sev].Findings = append(sections[sev].Findings, digestFinding{Finding: f, Notes: findingNotes(f)})
+
+		if f.New {
+			data.New++
+		}
+		if f.KEV {
+			data.KEV++
+		}
+		if f.Exploit {
+			data.Exploits++
+		}
+
+		h, ok := hosts[f.Address]
+		if !ok {
+			h = &digestHost{Address: f.Address, Criticality: risk.DefaultCriticality}
+			hosts[f.Address] = h
+			order = append(order, f.Address)
+		}
+		h.Findings++
+		if h.Worst == "" || severityRank[sev] > severityRank[h.Worst] {
+			h.Worst = sev
+		}
+	}
+
+	for _, sev := range digestSeverities {
+		if s := sections[sev]; len(s.Findings) > 0 {
+			data.Sections = append(data.Sections, *s)
+			data.Counts = append(data.Counts, severityCount{Severity: sev, Color: s.Color, Count: len(s.Findings)})
+		}
+	}
+
+	for _, addr := range order {
+		data.Hosts = append(data.Hosts, *hosts[addr])
+	}
+	sort.SliceStable(data.Hosts, func(i, j int) bool {
+		return data.Hosts[i].Risk > data.Hosts[j].Risk
+	})
+
+	var html, text bytes.Buffer
+	if err := digestHTML.Execute(&html, data); err != nil {
+		return Mail{}, err
+	}
+	if err := digestText.Execute(&text, data); err != nil {
+		return Mail{}, err
+	}
+
+	subject := fmt.Sprintf("Scan #%d: %d findings on %d hosts", ev.ScanID, data.Total, len(data.Hosts))
+	if len(data.Counts) > 0 && data.Counts[0].Severity == "critical" {
+		subject += fmt.Sprintf(", %d critical", data.Counts[0].Count)
+	}
+
+	m := Mail{Subject: subject, HTML: html.String(), Text: text.String()}
+
+	if settings.AttachCSV {
+		csvData, err := findingsCSV(ev.Findings)
+		if err != nil {
+			return Mail{}, err
+		}
+		m.Attachments = append(m.Attachments, Attachment{
+			Filename:    fmt.Sprintf("sentry-scan-%d.csv", ev.ScanID),
+			ContentType: "text/csv",
+			Data:        csvData,
+		})
+	}
+
+	return m, nil
+}
+
+// findingNotes lists the exploit/KEV/EPSS annotations shown next to a finding
+func findingNotes(f models.Finding) []string {
+	var notes []string
+	if f.KEV {
+		notes = append(notes, "actively exploited since "+f.KEVDateAdded)
+	}
+	if f.Exploit {
+		notes = append(notes, "public exploit")
+	}
+	if f.EPSSPercentile > 0 {
+		notes = append(notes, fmt.Sprintf("EPSS %.1f%%, %s percentile", f.EPSS*100, ordinal(int(f.EPSSPercentile*100))))
+	}
+	return notes
+}
+
+func findingsCSV(findings []models.Finding) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	_ = w.Write([]string{
+		"scan_id", "address", "port", "protocol", "service", "vuln_id", "type", "cve",
+		"score", "severity", "exploit", "kev", "epss", "epss_percentile", "new", "url", "description",
+	})
+	for _, f := range findings {
+		_ = w.Write([]string{
+			strconv.Itoa(f.ScanID), f.Address, strconv.Itoa(f.PortNum), f.Protocol, csvSafe(f.ServiceName),
+			csvSafe(f.ID), f.Type, f.CVE,
+			strconv.FormatFloat(f.Score, 'f', 1, 64), risk.Severity(f.Score),
+			strconv.FormatBool(f.Exploit), strconv.FormatBool(f.KEV),
+			strconv.FormatFloat(f.EPSS, 'f', 5, 64), strconv.FormatFloat(f.EPSSPercentile, 'f', 5, 64),
+			strconv.FormatBool(f.New), csvSafe(f.URL), csvSafe(f.Description),
+		})
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// csvSafe stops spreadsheets from evaluating scanner-provided text as a formula
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
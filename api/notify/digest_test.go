@@ -0,0 +1,138 @@
+package notify
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+func TestRenderDigest(t *testing.T) {
+	openTestDB(t)
+
+	ev := NewEvent(EventScanFinished, 7)
+	ev.Findings = []models.Finding{
+		{Address: "10.0.0.5", PortNum: 80, Protocol: "tcp", ServiceName: "http", VulnerabilityData: models.VulnerabilityData{ID: "CVE-2024-0001", Score: 5.3}},
+		{Address: "10.0.0.5", PortNum: 22, Protocol: "tcp", ServiceName: "ssh", New: true, VulnerabilityData: models.VulnerabilityData{
+			ID: "CVE-2024-0002", Score: 9.8, URL: "https://vulners.com/cve/CVE-2024-0002",
+			Enrichment: models.Enrichment{KEV: true, KEVDateAdded: "2024-05-01"},
+		}},
+		{Address: "10.0.0.9", PortNum: 443, Protocol: "tcp", ServiceName: "https", VulnerabilityData: models.VulnerabilityData{
+			ID: "CVE-2024-0003", Score: 7.5, Exploit: true,
+			Enrichment: models.Enrichment{EPSS: 0.42, EPSSPercentile: 0.97},
+		}},
+	}
+	settings := models.SMTPSettings{UIURL: "https://sentry.example.com/", AttachCSV: true}
+
+	m, err := renderDigest(ev, settings, []string{"Oct 19 02:10 New host 10.0.0.12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "Scan #7: 3 findings on 2 hosts, 1 critical"; m.Subject != want {
+		t.Errorf("subject = %q, want %q", m.Subject, want)
+	}
+
+	for _, want := range []string{
+		"https://sentry.example.com/?page=Scans&amp;scan=7",
+		"Actively exploited (KEV)",
+		"actively exploited since 2024-05-01",
+		"<b>NEW</b>",
+		"EPSS 42.0%, 97th percentile",
+		"Held during quiet hours (1)",
+		"New host 10.0.0.12",
+	} {
+		if !strings.Contains(m.HTML, want) {
+			t.Errorf("HTML digest lacks %q", want)
+		}
+	}
+
+	// Findings are listed KEV first, then by score
+	text := m.Text
+	if i, j, k := strings.Index(text, "CVE-2024-0002"), strings.Index(text, "CVE-2024-0003"), strings.Index(text, "CVE-2024-0001"); i < 0 || !(i < j && j < k) {
+		t.Errorf("text digest lists findings out of order:\n%s", text)
+	}
+	if !strings.Contains(text, "Findings: 3 (1 new)") {
+		t.Errorf("text digest lacks the finding count:\n%s", text)
+	}
+
+	if len(m.Attachments) != 1 {
+		t.Fatalf("got %d attachments, want the findings CSV", len(m.Attachments))
+	}
+	a := m.Attachments[0]
+	if a.Filename != "sentry-scan-7.csv" || a.ContentType != "text/csv" {
+		t.Errorf("attachment = %s (%s)", a.Filename, a.ContentType)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(a.Data)), "\n"); len(lines) != 4 || !strings.HasPrefix(lines[0], "scan_id,address,port") {
+		t.Errorf("CSV has %d lines, want a header and 3 findings:\n%s", len(lines), a.Data)
+	}
+
+	settings.AttachCSV = false
+	if m, err := renderDigest(ev, settings, nil); err != nil || len(m.Attachments) != 0 || strings.Contains(m.HTML, "quiet hours") {
+		t.Errorf("without attach_csv and held alerts: %d attachments, err %v", len(m.Attachments), err)
+	}
+}
+
+func TestEmailNotifierDigest(t *testing.T) {
+	openTestDB(t)
+	srv := startSMTP(t, &smtpServer{})
+
+	settings := srv.settings(models.SMTPSecurityNone, models.SMTPAuthNone)
+	settings.Recipients = []string{"ops@example.com", "sec@example.com"}
+	settings.AttachCSV = true
+	if err := db.SaveSMTPSettings(db.DB, settings); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rule 1 emails, so its held alerts go into the digest; rule 2 only posts to a channel
+	digest := &EmailNotifier{rules: map[int]bool{1: true}}
+	newHost := NewEvent(EventNewHost, 1)
+	newHost.Host = "10.0.0.12"
+	hold(models.AlertRule{ID: 1}, newHost)
+	hold(models.AlertRule{ID: 2}, newHost)
+
+	// A scan without findings sends nothing and keeps the held alerts
+	if err := digest.Notify(context.Background(), NewEvent(EventScanFinished, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.received()); n != 0 {
+		t.Fatalf("empty scan sent %d digests", n)
+	}
+
+	ev := NewEvent(EventScanFinished, 2)
+	ev.Findings = []models.Finding{{Address: "10.0.0.5", PortNum: 22, Protocol: "tcp", VulnerabilityData: models.VulnerabilityData{ID: "CVE-2024-0002", Score: 9.8}}}
+	if err := digest.Notify(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+
+	got := srv.received()
+	if len(got) != 1 || len(got[0].to) != 2 {
+		t.Fatalf("received %+v, want one digest to both recipients", got)
+	}
+	if !strings.Contains(got[0].data, "sentry-scan-2.csv") {
+		t.Error("digest lacks the CSV attachment")
+	}
+	if !strings.Contains(got[0].data, "Held during quiet hours (1)") {
+		t.Error("digest lacks the held alert")
+	}
+
+	// The digest took its held alert off the queue, the channel rule's one stays for the flush
+	queued, err := db.QueuedAlerts(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0].RuleID != 2 {
+		t.Fatalf("queued %+v, want only rule 2's alert", queued)
+	}
+
+	// The next digest does not repeat it
+	ev.ScanID = 3
+	if err := digest.Notify(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.received(); len(got) != 2 || strings.Contains(got[1].data, "Held during quiet hours") {
+		t.Error("second digest repeats the held alert")
+	}
+}
@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+func init() {
+	register("discord", newDiscord, "webhook_url")
+}
+
+type discordConfig struct {
+	WebhookURL string `json:"webhook_url"`
+	chatConfig
+}
+
+// DiscordNotifier posts embeds to a Discord channel webhook
+type DiscordNotifier struct {
+	name   string
+	config discordConfig
+	client *http.Client
+}
+
+func newDiscord(ch models.Channel) (Notifier, error) {
+	var cfg discordConfig
+	if err := json.Unmarshal(ch.Config, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid discord config: %v", err)
+	}
+	if err := validateURL(cfg.WebhookURL); err != nil {
+		return nil, fmt.Errorf("invalid webhook_url")
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return &DiscordNotifier{name: ch.Name, config: cfg, client: &http.Client{Timeout: httpTimeout}}, nil
+}
+
+func (d *DiscordNotifier) Name() string {
+	return d.name
+}
+
+func (d *DiscordNotifier) Notify(ctx context.Context, ev Event) error {
+	msg, ok := buildMessage(ev, d.config.chatConfig)
+	if !ok {
+		return nil
+	}
+
+	body, err := json.Marshal(discordPayload(msg, ev))
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, d.client, d.config.WebhookURL, body, nil, defaultMaxRetries)
+}
+
+func discordPayload(msg message, ev Event) map[string]any {
+	description := msg.Text
+	for _, line := range msg.Lines {
+		description += "\n• " + line
+	}
+
+	embed := map[string]any{
+		"title":       truncate(msg.Title, 256),
+		"description": truncate(strings.TrimSpace(description), 4096),
+		"color":       severityColor(msg.Severity),
+		"timestamp":   ev.Time.Format("2006-01-02T15:04:05Z07:00"),
+	}
+	if msg.Link != "" {
+		embed["url"] = msg.Link
+	}
+
+	var fields []map[string]any
+	for _, f := range msg.Fields {
+		fields = append(fields, map[string]any{
+			"name":   truncate(f.Name, 256),
+			"value":  truncate(f.Value, 1024),
+			"inline": true,
+		})
+	}
+	if len(fields) > 0 {
+		embed["fields"] = fields
+	}
+
+	return map[string]any{
+		"username": "Sentry",
+		"embeds":   []map[string]any{embed},
+	}
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,401 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wiktoz/sentry/config"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+const smtpTimeout = 30 * time.Second
+
+var ErrSMTPNotConfigured = errors.New("SMTP host is not configured")
+
+// smtpRootCAs verifies the mail server certificate; nil means the system roots
+var smtpRootCAs *x509.CertPool
+
+// Attachment is a file attached to an email
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Mail is an email with an HTML body, an optional plaintext alternative and attachments
+type Mail struct {
+	Subject     string
+	HTML        string
+	Text        string
+	Attachments []Attachment
+}
+
+// SendEmail sends a message to the configured recipients,
+// falling back to the scan config email when no recipient list is set
+func SendEmail(m Mail) error {
+	settings, err := db.GetSMTPSettings(db.DB)
+	if err != nil {
+		return err
+	}
+
+	recipients := settings.Recipients
+	if len(recipients) == 0 {
+		cfg, err := db.GetConfig(db.DB)
+		if err != nil {
+			return err
+		}
+		if cfg.Email != "" {
+			recipients = []string{cfg.Email}
+		}
+	}
+
+	return SendMail(settings, recipients, m)
+}
+
+// smtpConfigured tells whether a mail server is set, in the UI or the server config
+func smtpConfigured() (bool, error) {
+	settings, err := db.GetSMTPSettings(db.DB)
+	if err != nil {
+		return false, err
+	}
+	return settings.Host != "" || config.Current.SMTP.Host != "", nil
+}
+
+func SendMail(settings models.SMTPSettings, to []string, m Mail) error {
+	// The server config fills in whatever was left empty in the UI
+	fallback := config.Current.SMTP
+	if settings.Host == "" {
+		settings.Host, settings.Port = fallback.Host, fallback.Port
+	}
+	if settings.Username == "" {
+		settings.Username = fallback.Username
+	}
+	if settings.Password == "" {
+		settings.Password = fallback.Password
+	}
+
+	if settings.Host == "" {
+		return ErrSMTPNotConfigured
+	}
+	if len(to) == 0 {
+		return errors.New("no email recipients configured")
+	}
+	from := settings.From
+	if from == "" {
+		from = settings.Username
+	}
+
+	msg, err := m.render(from, to)
+	if err != nil {
+		return fmt.Errorf("build message: %v", err)
+	}
+
+	client, err := dialSMTP(settings)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if settings.Auth != models.SMTPAuthNone {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("auth error: server does not support AUTH")
+		}
+		if err := client.Auth(smtpAuth(settings)); err != nil {
+			return fmt.Errorf("auth error: %v", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("MAIL FROM error: %v", err)
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("RCPT TO %s error: %v", rcpt, err)
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("DATA error: %v", err)
+	}
+
+	if _, err := writer.Write(msg); err != nil {
+		return fmt.Errorf("write error: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("write error: %v", err)
+	}
+
+	return client.Quit()
+}
+
+// render builds the MIME message: multipart/alternative (text, html), wrapped in
+// multipart/mixed when there are attachments
+func (m Mail) render(from string, to []string) ([]byte, error) {
+	var buf bytes.Buffer
+	sender := mail.Address{Name: "Scan Report", Address: from}
+
+	buf.WriteString("From: " + sender.String() + "\r\n")
+	buf.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("UTF-8", m.Subject) + "\r\n")
+	buf.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	outer := multipart.NewWriter(&buf)
+	alt := outer
+
+	if len(m.Attachments) > 0 {
+		buf.WriteString("Content-Type: multipart/mixed; boundary=" + outer.Boundary() + "\r\n\r\n")
+
+		// The nested boundary has to be in the part header before the part is written
+		boundary := multipart.NewWriter(nil).Boundary()
+		part, err := outer.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"multipart/alternative; boundary=" + boundary},
+		})
+		if err != nil {
+			return nil, err
+		}
+		alt = multipart.NewWriter(part)
+		if err := alt.SetBoundary(boundary); err != nil {
+			return nil, err
+		}
+	} else {
+		buf.WriteString("Content-Type: multipart/alternative; boundary=" + outer.Boundary() + "\r\n\r\n")
+	}
+
+	text := m.Text
+	if text == "" {
+		text = "This message requires an HTML capable mail client."
+	}
+	if err := writeQuotedPart(alt, "text/plain; charset=UTF-8", text); err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPart(alt, "text/html; charset=UTF-8", m.HTML); err != nil {
+		return nil, err
+	}
+
+	if alt != outer {
+		if err := alt.Close(); err != nil {
+			return nil, err
+		}
+		for _, a := range m.Attachments {
+			if err := writeAttachment(outer, a); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := outer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPart(w *multipart.Writer, contentType, body string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func writeAttachment(w *multipart.Writer, a Attachment) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(a.ContentType, map[string]string{"name": a.Filename})},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+
+	// RFC 2045 limits encoded lines to 76 characters
+	encoded := base64.StdEncoding.EncodeToString(a.Data)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(part, encoded[:76]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = io.WriteString(part, encoded+"\r\n")
+	return err
+}
+
+func dialSMTP(settings models.SMTPSettings) (*smtp.Client, error) {
+	addr := net.JoinHostPort(settings.Host, strconv.Itoa(settings.Port))
+	tlsConfig := &tls.Config{ServerName: settings.Host, RootCAs: smtpRootCAs}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	var conn net.Conn
+	var err error
+	if settings.Security == models.SMTPSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connection error: %v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, settings.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SMTP client error: %v", err)
+	}
+
+	if settings.Security == models.SMTPSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("STARTTLS error: server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("STARTTLS error: %v", err)
+		}
+	}
+
+	return client, nil
+}
+
+func smtpAuth(settings models.SMTPSettings) smtp.Auth {
+	switch settings.Auth {
+	case models.SMTPAuthLogin:
+		return &loginAuth{username: settings.Username, password: settings.Password}
+	case models.SMTPAuthCRAMMD5:
+		return smtp.CRAMMD5Auth(settings.Username, settings.Password)
+	default:
+		return smtp.PlainAuth("", settings.Username, settings.Password, settings.Host)
+	}
+}
+
+// loginAuth implements the AUTH LOGIN mechanism, which net/smtp lacks
+// but is still the only one offered by some providers
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+	}
+}
+
+// EmailNotifier mails a single digest of the findings of each finished scan.
+// The digest also carries the alerts that emailing rules held during quiet hours.
+type EmailNotifier struct {
+	rules map[int]bool // alert rules whose held alerts go into the digest
+}
+
+func (e *EmailNotifier) Name() string {
+	return "email"
+}
+
+func (e *EmailNotifier) Notify(ctx context.Context, ev Event) error {
+	if ev.Type != EventScanFinished || len(ev.Findings) == 0 {
+		return nil
+	}
+
+	settings, err := db.GetSMTPSettings(db.DB)
+	if err != nil {
+		return err
+	}
+
+	held, err := e.held()
+	if err != nil {
+		return err
+	}
+
+	m, err := renderDigest(ev, settings, heldAlerts(held))
+	if err != nil {
+		return fmt.Errorf("render digest: %v", err)
+	}
+
+	if err := SendEmail(m); err != nil {
+		return fmt.Errorf("digest for scan #%d: %v", ev.ScanID, err)
+	}
+	log.Printf("Digest email sent for scan #%d", ev.ScanID)
+
+	if len(held) > 0 {
+		ids := make([]int, len(held))
+		for i, q := range held {
+			ids[i] = q.ID
+		}
+		if err := db.DeleteQueuedAlerts(db.DB, ids); err != nil {
+			log.Printf("Failed to clear alerts held for the digest: %v", err)
+		}
+	}
+	return nil
+}
+
+// held returns the queued alerts of the rules this digest batches
+func (e *EmailNotifier) held() ([]db.QueuedAlert, error) {
+	queued, err := db.QueuedAlerts(db.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	var held []db.QueuedAlert
+	for _, q := range queued {
+		if e.rules[q.RuleID] {
+			held = append(held, q)
+		}
+	}
+	return held, nil
+}
+
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return strconv.Itoa(n) + suffix
+}
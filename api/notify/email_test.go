@@ -0,0 +1,392 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+// smtpSession is what the stand-in server saw of one delivered message
+type smtpSession struct {
+	tls  bool
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// smtpServer is a minimal in-process SMTP server that accepts any message
+type smtpServer struct {
+	addr     string
+	tls      *tls.Config
+	implicit bool     // TLS from the first byte (port 465 style)
+	startTLS bool     // offer STARTTLS
+	auth     []string // AUTH mechanisms to offer
+	user     string
+	pass     string
+
+	mu       sync.Mutex
+	sessions []smtpSession
+}
+
+func startSMTP(t *testing.T, s *smtpServer) *smtpServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.implicit {
+		ln = tls.NewListener(ln, s.tls)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s.addr = ln.Addr().String()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) settings(security, auth string) models.SMTPSettings {
+	host, port, _ := net.SplitHostPort(s.addr)
+	p, _ := strconv.Atoi(port)
+	return models.SMTPSettings{
+		Host:     host,
+		Port:     p,
+		Security: security,
+		Auth:     auth,
+		Username: s.user,
+		Password: s.pass,
+		From:     "sentry@example.com",
+	}
+}
+
+func (s *smtpServer) received() []smtpSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpSession(nil), s.sessions...)
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	tp := textproto.NewConn(conn)
+	session := smtpSession{tls: s.implicit}
+	tp.PrintfLine("220 sentry.test ESMTP")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			ext := []string{"sentry.test"}
+			if s.startTLS && !session.tls {
+				ext = append(ext, "STARTTLS")
+			}
+			if len(s.auth) > 0 {
+				ext = append(ext, "AUTH "+strings.Join(s.auth, " "))
+			}
+			for i, e := range ext {
+				sep := "-"
+				if i == len(ext)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, e)
+			}
+
+		case "STARTTLS":
+			tp.PrintfLine("220 ready to start TLS")
+			tc := tls.Server(conn, s.tls)
+			if err := tc.Handshake(); err != nil {
+				return
+			}
+			conn, tp = tc, textproto.NewConn(tc)
+			session.tls = true
+
+		case "AUTH":
+			mech, initial, _ := strings.Cut(arg, " ")
+			if s.authenticate(tp, mech, initial) {
+				session.auth = mech
+				tp.PrintfLine("235 authenticated")
+			} else {
+				tp.PrintfLine("535 authentication failed")
+			}
+
+		case "MAIL":
+			session.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 ok")
+
+		case "RCPT":
+			session.to = append(session.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 ok")
+
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			lines, err := tp.ReadDotLines()
+			if err != nil {
+				return
+			}
+			session.data = strings.Join(lines, "\r\n")
+			s.mu.Lock()
+			s.sessions = append(s.sessions, session)
+			s.mu.Unlock()
+			tp.PrintfLine("250 queued")
+
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpServer) authenticate(tp *textproto.Conn, mech, initial string) bool {
+	// challenge sends a 334 prompt and returns the decoded answer
+	challenge := func(prompt string) string {
+		tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+		line, _ := tp.ReadLine()
+		answer, _ := base64.StdEncoding.DecodeString(line)
+		return string(answer)
+	}
+
+	switch mech {
+	case "PLAIN":
+		resp, _ := base64.StdEncoding.DecodeString(initial)
+		parts := strings.Split(string(resp), "\x00")
+		return len(parts) == 3 && parts[1] == s.user && parts[2] == s.pass
+	case "LOGIN":
+		return challenge("Username:") == s.user && challenge("Password:") == s.pass
+	case "CRAM-MD5":
+		nonce := "<1896.697170952@sentry.test>"
+		user, digest, _ := strings.Cut(challenge(nonce), " ")
+		mac := hmac.New(md5.New, []byte(s.pass))
+		mac.Write([]byte(nonce))
+		return user == s.user && digest == hex.EncodeToString(mac.Sum(nil))
+	}
+	return false
+}
+
+func TestSendMailSecurity(t *testing.T) {
+	serverTLS, roots := testTLS(t)
+	smtpRootCAs = roots
+	t.Cleanup(func() { smtpRootCAs = nil })
+
+	tests := []struct {
+		security string
+		server   *smtpServer
+	}{
+		{models.SMTPSecurityNone, &smtpServer{}},
+		{models.SMTPSecurityStartTLS, &smtpServer{tls: serverTLS, startTLS: true}},
+		{models.SMTPSecurityTLS, &smtpServer{tls: serverTLS, implicit: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.security, func(t *testing.T) {
+			srv := startSMTP(t, tt.server)
+
+			err := SendMail(srv.settings(tt.security, models.SMTPAuthNone), []string{"ops@example.com"}, Mail{Subject: "hi", HTML: "<p>hi</p>"})
+			if err != nil {
+				t.Fatalf("SendMail: %v", err)
+			}
+
+			got := srv.received()
+			if len(got) != 1 {
+				t.Fatalf("server received %d messages, want 1", len(got))
+			}
+			if wantTLS := tt.security != models.SMTPSecurityNone; got[0].tls != wantTLS {
+				t.Errorf("message sent with TLS = %v, want %v", got[0].tls, wantTLS)
+			}
+		})
+	}
+}
+
+func TestSendMailStartTLSNotOffered(t *testing.T) {
+	srv := startSMTP(t, &smtpServer{})
+
+	err := SendMail(srv.settings(models.SMTPSecurityStartTLS, models.SMTPAuthNone), []string{"ops@example.com"}, Mail{Subject: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("err = %v, want a STARTTLS error", err)
+	}
+	if n := len(srv.received()); n != 0 {
+		t.Errorf("server received %d messages in plaintext", n)
+	}
+}
+
+func TestSendMailAuth(t *testing.T) {
+	serverTLS, roots := testTLS(t)
+	smtpRootCAs = roots
+	t.Cleanup(func() { smtpRootCAs = nil })
+
+	tests := []struct {
+		auth     string
+		mech     string
+		security string
+	}{
+		{models.SMTPAuthPlain, "PLAIN", models.SMTPSecurityStartTLS},
+		{models.SMTPAuthLogin, "LOGIN", models.SMTPSecurityStartTLS},
+		{models.SMTPAuthCRAMMD5, "CRAM-MD5", models.SMTPSecurityNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.auth, func(t *testing.T) {
+			srv := startSMTP(t, &smtpServer{
+				tls:      serverTLS,
+				startTLS: true,
+				auth:     []string{tt.mech},
+				user:     "sentry",
+				pass:     "s3cret",
+			})
+			settings := srv.settings(tt.security, tt.auth)
+
+			if err := SendMail(settings, []string{"ops@example.com"}, Mail{Subject: "hi"}); err != nil {
+				t.Fatalf("SendMail: %v", err)
+			}
+			if got := srv.received(); len(got) != 1 || got[0].auth != tt.mech {
+				t.Fatalf("received %+v, want one message after AUTH %s", got, tt.mech)
+			}
+
+			settings.Password = "wrong"
+			err := SendMail(settings, []string{"ops@example.com"}, Mail{Subject: "hi"})
+			if err == nil || !strings.Contains(err.Error(), "auth error") {
+				t.Fatalf("wrong password: err = %v, want an auth error", err)
+			}
+			if n := len(srv.received()); n != 1 {
+				t.Errorf("server received %d messages, the rejected login must not send", n)
+			}
+		})
+	}
+}
+
+func TestSendMailLoginNeedsTLS(t *testing.T) {
+	srv := startSMTP(t, &smtpServer{auth: []string{"LOGIN"}, user: "sentry", pass: "s3cret"})
+
+	err := SendMail(srv.settings(models.SMTPSecurityNone, models.SMTPAuthLogin), []string{"ops@example.com"}, Mail{Subject: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "unencrypted") {
+		t.Fatalf("err = %v, want LOGIN refused over plaintext", err)
+	}
+}
+
+func TestSendMailRecipientsAndAttachment(t *testing.T) {
+	srv := startSMTP(t, &smtpServer{})
+
+	to := []string{"ops@example.com", "sec@example.com", "oncall@example.com"}
+	csv := []byte(strings.Repeat("scan_id,address,port\n1,10.0.0.5,22\n", 20))
+	m := Mail{
+		Subject:     "Scan #1 report",
+		HTML:        "<p>report</p>",
+		Text:        "report",
+		Attachments: []Attachment{{Filename: "sentry-scan-1.csv", ContentType: "text/csv", Data: csv}},
+	}
+
+	if err := SendMail(srv.settings(models.SMTPSecurityNone, models.SMTPAuthNone), to, m); err != nil {
+		t.Fatalf("SendMail: %v", err)
+	}
+
+	got := srv.received()
+	if len(got) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(got))
+	}
+	if got[0].from != "sentry@example.com" {
+		t.Errorf("MAIL FROM = %q", got[0].from)
+	}
+	if strings.Join(got[0].to, ",") != strings.Join(to, ",") {
+		t.Errorf("RCPT TO = %v, want %v", got[0].to, to)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(got[0].data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := msg.Header.Get("To"); h != strings.Join(to, ", ") {
+		t.Errorf("To header = %q", h)
+	}
+
+	parts := mimeParts(t, msg.Header.Get("Content-Type"), msg.Body)
+	if len(parts) != 2 {
+		t.Fatalf("multipart/mixed has %d parts, want the alternative body and the CSV", len(parts))
+	}
+	if ct, _, _ := mime.ParseMediaType(parts[0].Header.Get("Content-Type")); ct != "multipart/alternative" {
+		t.Errorf("first part is %s, want multipart/alternative", ct)
+	}
+
+	attachment := parts[1]
+	if _, params, _ := mime.ParseMediaType(attachment.Header.Get("Content-Disposition")); params["filename"] != "sentry-scan-1.csv" {
+		t.Errorf("Content-Disposition = %q", attachment.Header.Get("Content-Disposition"))
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(attachment.body)), "\r\n") {
+		if len(line) > 76 {
+			t.Fatalf("base64 line of %d characters exceeds 76", len(line))
+		}
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(attachment.body), "\r\n", ""))
+	if err != nil || string(data) != string(csv) {
+		t.Errorf("attachment does not decode to the CSV (err %v)", err)
+	}
+}
+
+func TestSendMailNotConfigured(t *testing.T) {
+	err := SendMail(models.SMTPSettings{}, []string{"ops@example.com"}, Mail{Subject: "hi"})
+	if err != ErrSMTPNotConfigured {
+		t.Fatalf("err = %v, want ErrSMTPNotConfigured", err)
+	}
+}
+
+type mimePart struct {
+	Header textproto.MIMEHeader
+	body   []byte
+}
+
+func mimeParts(t *testing.T, contentType string, body io.Reader) []mimePart {
+	t.Helper()
+
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("Content-Type %q: %v", contentType, err)
+	}
+
+	var parts []mimePart
+	r := multipart.NewReader(body, params["boundary"])
+	for {
+		p, err := r.NextRawPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, mimePart{Header: p.Header, body: data})
+	}
+}
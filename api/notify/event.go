@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"time"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+type EventType string
+
+const (
+	EventScanStarted       EventType = "scan.started"
+	EventScanFinished      EventType = "scan.finished"
+	EventScanFailed        EventType = "scan.failed"
+	EventNewFinding        EventType = "finding.new"
+	EventNewHost           EventType = "host.new"
+	EventBaselineDeviation EventType = "baseline.deviation"
+)
+
+var EventTypes = []EventType{
+	EventScanStarted,
+	EventScanFinished,
+	EventScanFailed,
+	EventNewFinding,
+	EventNewHost,
+	EventBaselineDeviation,
+}
+
+// Event is what gets published to every notifier; it is also the JSON body of generic webhooks
+type Event struct {
+	Type    EventType `json:"type"`
+	Time    time.Time `json:"time"`
+	ScanID  int       `json:"scan_id,omitempty"`
+	Host    string    `json:"host,omitempty"`
+	Message string    `json:"message,omitempty"`
+
+	// Set on finding.new
+	Finding *models.Finding `json:"finding,omitempty"`
+
+	// Set on scan.finished: every active (unsuppressed) finding of the scan
+	Findings []models.Finding `json:"findings,omitempty"`
+
+	// Set on baseline.deviation
+	Changes []models.PortChange `json:"changes,omitempty"`
+}
+
+func NewEvent(t EventType, scanID int) Event {
+	return Event{Type: t, Time: time.Now().UTC(), ScanID: scanID}
+}
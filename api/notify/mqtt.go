@@ -0,0 +1,329 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+	"github.com/wiktoz/sentry/risk"
+)
+
+const (
+	mqttKeepAlive = 60
+	mqttTimeout   = 15 * time.Second
+)
+
+func init() {
+	register("mqtt", newMQTT, "password")
+}
+
+type mqttConfig struct {
+	Broker      string `json:"broker"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	ClientID    string `json:"client_id"`
+	TopicPrefix string `json:"topic_prefix"`
+	QoS         int    `json:"qos"`
+	InsecureTLS bool   `json:"insecure_tls"`
+}
+
+// MQTTNotifier publishes retained per-host state topics and event topics:
+//
+//	<prefix>/host/<address>/state   retained, refreshed after every scan
+//	<prefix>/event/<event type>     e.g. sentry/event/finding.new, sentry/event/host.new
+type MQTTNotifier struct {
+	name   string
+	config mqttConfig
+	addr   string
+	useTLS bool
+}
+
+func newMQTT(ch models.Channel) (Notifier, error) {
+	var cfg mqttConfig
+	if err := json.Unmarshal(ch.Config, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid mqtt config: %v", err)
+	}
+
+	u, err := url.Parse(cfg.Broker)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid broker, expected mqtt://host:port or mqtts://host:port")
+	}
+
+	n := &MQTTNotifier{name: ch.Name, config: cfg}
+	port := u.Port()
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		if port == "" {
+			port = "1883"
+		}
+	case "mqtts", "ssl", "tls":
+		n.useTLS = true
+		if port == "" {
+			port = "8883"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported broker scheme %q", u.Scheme)
+	}
+	n.addr = net.JoinHostPort(u.Hostname(), port)
+
+	if cfg.QoS < 0 || cfg.QoS > 1 {
+		return nil, errors.New("qos must be 0 or 1")
+	}
+	if n.config.TopicPrefix == "" {
+		n.config.TopicPrefix = "sentry"
+	}
+	n.config.TopicPrefix = strings.TrimRight(n.config.TopicPrefix, "/")
+	if n.config.ClientID == "" {
+		n.config.ClientID = "sentry"
+	}
+
+	return n, nil
+}
+
+func (m *MQTTNotifier) Name() string {
+	return m.name
+}
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+type hostState struct {
+	Address   string    `json:"address"`
+	ScanID    int       `json:"scan_id"`
+	OpenPorts []int     `json:"open_ports"`
+	Services  []string  `json:"services"`
+	VulnCount int       `json:"vuln_count"`
+	MaxScore  float64   `json:"max_score"`
+	Risk      float64   `json:"risk"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+func (m *MQTTNotifier) Notify(ctx context.Context, ev Event) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	msgs := []mqttMessage{{topic: m.config.TopicPrefix + "/event/" + string(ev.Type), payload: payload}}
+
+	if ev.Type == EventScanFinished && ev.ScanID != 0 {
+		states, err := m.hostStates(ev)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, states...)
+	}
+
+	return m.publish(ctx, msgs)
+}
+
+func (m *MQTTNotifier) hostStates(ev Event) ([]mqttMessage, error) {
+	scan, err := db.GetScanData(db.DB, ev.ScanID)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []mqttMessage
+	for _, h := range scan.Hosts {
+		state := hostState{
+			Address:   h.Address,
+			ScanID:    scan.ID,
+			OpenPorts: []int{},
+			Services:  []string{},
+			Risk:      h.Risk,
+			LastSeen:  ev.Time,
+		}
+
+		for _, p := range h.Ports {
+			if p.State != "open" {
+				continue
+			}
+			state.OpenPorts = append(state.OpenPorts, p.PortNum)
+			state.Services = append(state.Services, fmt.Sprintf("%d/%s %s", p.PortNum, p.Protocol, p.ServiceName))
+
+			for _, v := range p.Vulnerabilities {
+				if risk.Counts(v) {
+					state.VulnCount++
+					state.MaxScore = max(state.MaxScore, v.Score)
+				}
+			}
+		}
+
+		payload, err := json.Marshal(state)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, mqttMessage{
+			topic:   m.config.TopicPrefix + "/host/" + topicSafe(h.Address) + "/state",
+			payload: payload,
+			retain:  true,
+		})
+	}
+	return msgs, nil
+}
+
+// topicSafe strips MQTT wildcard and separator characters (IPv6 colons are fine)
+func topicSafe(s string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(s)
+}
+
+// publish opens a short-lived MQTT 3.1.1 session, sends msgs and disconnects
+func (m *MQTTNotifier) publish(ctx context.Context, msgs []mqttMessage) error {
+	dialer := &net.Dialer{Timeout: mqttTimeout}
+
+	var conn net.Conn
+	var err error
+	if m.useTLS {
+		host, _, _ := net.SplitHostPort(m.addr)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: m.config.InsecureTLS,
+		}}).DialContext(ctx, "tcp", m.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", m.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("mqtt connect: %v", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(mqttTimeout + time.Duration(len(msgs))*time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	r := bufio.NewReader(conn)
+
+	if _, err := conn.Write(m.connectPacket()); err != nil {
+		return fmt.Errorf("mqtt connect: %v", err)
+	}
+	typ, body, err := readPacket(r)
+	if err != nil {
+		return fmt.Errorf("mqtt connack: %v", err)
+	}
+	if typ != 2 || len(body) < 2 {
+		return fmt.Errorf("mqtt: expected CONNACK, got packet type %d", typ)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("mqtt: connection refused, return code %d", body[1])
+	}
+
+	for i, msg := range msgs {
+		packetID := uint16(i + 1)
+		if _, err := conn.Write(publishPacket(msg, m.config.QoS, packetID)); err != nil {
+			return fmt.Errorf("mqtt publish: %v", err)
+		}
+
+		if m.config.QoS == 1 {
+			typ, body, err := readPacket(r)
+			if err != nil {
+				return fmt.Errorf("mqtt puback: %v", err)
+			}
+			if typ != 4 || len(body) < 2 || binary.BigEndian.Uint16(body) != packetID {
+				return fmt.Errorf("mqtt: expected PUBACK for %d, got packet type %d", packetID, typ)
+			}
+		}
+	}
+
+	// DISCONNECT
+	_, err = conn.Write([]byte{0xe0, 0x00})
+	return err
+}
+
+func (m *MQTTNotifier) connectPacket() []byte {
+	flags := byte(0x02) // clean session
+	payload := mqttString(m.config.ClientID)
+	if m.config.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(m.config.Username)...)
+		if m.config.Password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(m.config.Password)...)
+		}
+	}
+
+	variable := append(mqttString("MQTT"), 0x04, flags, 0, mqttKeepAlive)
+	return packet(0x10, append(variable, payload...))
+}
+
+func publishPacket(msg mqttMessage, qos int, packetID uint16) []byte {
+	header := byte(0x30)
+	if msg.retain {
+		header |= 0x01
+	}
+
+	body := mqttString(msg.topic)
+	if qos == 1 {
+		header |= 0x02
+		body = binary.BigEndian.AppendUint16(body, packetID)
+	}
+	return packet(header, append(body, msg.payload...))
+}
+
+func mqttString(s string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(s)))
+	return append(b, s...)
+}
+
+func packet(header byte, body []byte) []byte {
+	out := []byte{header}
+
+	// Remaining length, variable-length encoded 7 bits at a time
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i >= 4 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header >> 4, body, nil
+}
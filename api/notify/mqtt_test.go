@@ -0,0 +1,420 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+type mqttConnect struct {
+	clientID     string
+	username     string
+	password     string
+	cleanSession bool
+	keepAlive    uint16
+}
+
+type mqttPublish struct {
+	topic    string
+	payload  []byte
+	qos      int
+	retain   bool
+	packetID uint16
+}
+
+// mqttBroker is an in-process MQTT 3.1.1 broker that records what clients send
+type mqttBroker struct {
+	addr       string
+	tls        *tls.Config
+	returnCode byte // CONNACK return code, 0 accepts
+	noPuback   bool // never acknowledge QoS 1 messages
+	dropFirst  bool // close the first connection right after CONNECT
+
+	wg        sync.WaitGroup // connections still being served
+	mu        sync.Mutex
+	sessions  int
+	connects  []mqttConnect
+	published []mqttPublish
+}
+
+func startBroker(t *testing.T, b *mqttBroker) *mqttBroker {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.tls != nil {
+		ln = tls.NewListener(ln, b.tls)
+	}
+	t.Cleanup(func() { ln.Close() })
+	b.addr = ln.Addr().String()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			b.wg.Add(1)
+			go func() {
+				defer b.wg.Done()
+				b.serve(conn)
+			}()
+		}
+	}()
+	return b
+}
+
+func (b *mqttBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+
+	typ, body, err := readPacket(r)
+	if err != nil || typ != 1 {
+		return
+	}
+	c, ok := parseConnect(body)
+	if !ok {
+		return
+	}
+
+	b.mu.Lock()
+	b.sessions++
+	drop := b.dropFirst && b.sessions == 1
+	b.connects = append(b.connects, c)
+	b.mu.Unlock()
+
+	if drop {
+		return
+	}
+	conn.Write([]byte{0x20, 0x02, 0x00, b.returnCode})
+	if b.returnCode != 0 {
+		return
+	}
+
+	for {
+		header, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+		flags := header[0] & 0x0f
+
+		typ, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case 3: // PUBLISH
+			p := mqttPublish{qos: int(flags>>1) & 0x03, retain: flags&0x01 != 0}
+			n := int(binary.BigEndian.Uint16(body))
+			p.topic, body = string(body[2:2+n]), body[2+n:]
+			if p.qos > 0 {
+				p.packetID, body = binary.BigEndian.Uint16(body), body[2:]
+			}
+			p.payload = body
+
+			b.mu.Lock()
+			b.published = append(b.published, p)
+			b.mu.Unlock()
+
+			if p.qos == 1 && !b.noPuback {
+				conn.Write([]byte{0x40, 0x02, byte(p.packetID >> 8), byte(p.packetID)})
+			}
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func parseConnect(body []byte) (mqttConnect, bool) {
+	str := func() string {
+		if len(body) < 2 {
+			return ""
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		if len(body) < 2+n {
+			return ""
+		}
+		s := string(body[2 : 2+n])
+		body = body[2+n:]
+		return s
+	}
+
+	if str() != "MQTT" || len(body) < 4 || body[0] != 0x04 {
+		return mqttConnect{}, false
+	}
+	flags := body[1]
+	c := mqttConnect{cleanSession: flags&0x02 != 0, keepAlive: binary.BigEndian.Uint16(body[2:])}
+	body = body[4:]
+
+	c.clientID = str()
+	if flags&0x80 != 0 {
+		c.username = str()
+	}
+	if flags&0x40 != 0 {
+		c.password = str()
+	}
+	return c, true
+}
+
+// state returns what the broker received once open connections end;
+// QoS 0 clients disconnect without waiting for the broker to read
+func (b *mqttBroker) state() ([]mqttConnect, []mqttPublish) {
+	b.wg.Wait()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]mqttConnect(nil), b.connects...), append([]mqttPublish(nil), b.published...)
+}
+
+func mqttNotifier(t *testing.T, config string) Notifier {
+	t.Helper()
+
+	n, err := Build(models.Channel{Name: "mqtt", Type: "mqtt", Config: json.RawMessage(config)})
+	if err != nil {
+		t.Fatalf("build mqtt: %v", err)
+	}
+	return n
+}
+
+func TestMQTTConnect(t *testing.T) {
+	b := startBroker(t, &mqttBroker{})
+	n := mqttNotifier(t, fmt.Sprintf(`{"broker": "mqtt://%s", "username": "sentry", "password": "s3cret", "client_id": "sentry-test"}`, b.addr))
+
+	if err := n.Notify(context.Background(), NewEvent(EventScanStarted, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	connects, published := b.state()
+	if len(connects) != 1 {
+		t.Fatalf("got %d connections, want 1", len(connects))
+	}
+	want := mqttConnect{clientID: "sentry-test", username: "sentry", password: "s3cret", cleanSession: true, keepAlive: mqttKeepAlive}
+	if connects[0] != want {
+		t.Errorf("CONNECT = %+v, want %+v", connects[0], want)
+	}
+
+	if len(published) != 1 || published[0].topic != "sentry/event/scan.started" || published[0].retain || published[0].qos != 0 {
+		t.Fatalf("published %+v, want one QoS 0 event", published)
+	}
+	var ev Event
+	if err := json.Unmarshal(published[0].payload, &ev); err != nil || ev.ScanID != 3 {
+		t.Errorf("payload %s does not hold the event", published[0].payload)
+	}
+}
+
+func TestMQTTConnectAnonymous(t *testing.T) {
+	b := startBroker(t, &mqttBroker{})
+	n := mqttNotifier(t, fmt.Sprintf(`{"broker": "tcp://%s"}`, b.addr))
+
+	if err := n.Notify(context.Background(), NewEvent(EventScanStarted, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if connects, _ := b.state(); connects[0] != (mqttConnect{clientID: "sentry", cleanSession: true, keepAlive: mqttKeepAlive}) {
+		t.Errorf("CONNECT = %+v, want no credentials and the default client id", connects[0])
+	}
+}
+
+func TestMQTTConnackRefused(t *testing.T) {
+	b := startBroker(t, &mqttBroker{returnCode: 5}) // not authorized
+	n := mqttNotifier(t, fmt.Sprintf(`{"broker": "mqtt://%s", "username": "sentry", "password": "wrong"}`, b.addr))
+
+	err := n.Notify(context.Background(), NewEvent(EventScanStarted, 3))
+	if err == nil || !strings.Contains(err.Error(), "return code 5") {
+		t.Fatalf("err = %v, want the refused connection reported", err)
+	}
+	if _, published := b.state(); len(published) != 0 {
+		t.Errorf("published %d messages on a refused connection", len(published))
+	}
+}
+
+// insertScan stores a finished scan of one host with two open ports and one finding
+func insertScan(t *testing.T) int {
+	t.Helper()
+
+	scanID, err := db.CreateScan(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := db.DB.Exec("INSERT INTO hosts (scan_id, address, addr_type) VALUES (?, '10.0.0.5', 'ipv4')", scanID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostID, _ := res.LastInsertId()
+
+	for _, p := range []struct {
+		port    int
+		service string
+		state   string
+	}{{22, "ssh", "open"}, {80, "http", "open"}, {8080, "http-proxy", "closed"}} {
+		res, err := db.DB.Exec("INSERT INTO ports (host_id, protocol, port_id, state, service_name) VALUES (?, 'tcp', ?, ?, ?)",
+			hostID, p.port, p.state, p.service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.port == 22 {
+			portID, _ := res.LastInsertId()
+			_, err := db.DB.Exec(`INSERT INTO vulnerabilities (port_id, vuln_id, vuln_type, cve_id, exploit, score, url, description)
+				VALUES (?, 'CVE-2024-6387', 'cve', 'CVE-2024-6387', 1, 8.1, '', '')`, portID)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return scanID
+}
+
+func TestMQTTRetainedHostState(t *testing.T) {
+	openTestDB(t)
+	scanID := insertScan(t)
+
+	b := startBroker(t, &mqttBroker{})
+	n := mqttNotifier(t, fmt.Sprintf(`{"broker": "mqtt://%s", "topic_prefix": "home/sentry/"}`, b.addr))
+
+	ev := NewEvent(EventScanFinished, scanID)
+	if err := n.Notify(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+
+	_, published := b.state()
+	if len(published) != 2 {
+		t.Fatalf("published %d messages, want the event and one host state", len(published))
+	}
+	if p := published[0]; p.topic != "home/sentry/event/scan.finished" || p.retain {
+		t.Errorf("event published as %s (retain %v)", p.topic, p.retain)
+	}
+
+	p := published[1]
+	if p.topic != "home/sentry/host/10.0.0.5/state" || !p.retain {
+		t.Fatalf("state published as %s (retain %v), want a retained host topic", p.topic, p.retain)
+	}
+	var state hostState
+	if err := json.Unmarshal(p.payload, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Address != "10.0.0.5" || state.ScanID != scanID || state.VulnCount != 1 || state.MaxScore != 8.1 {
+		t.Errorf("state = %+v", state)
+	}
+	if fmt.Sprint(state.OpenPorts) != "[22 80]" || len(state.Services) != 2 || state.Services[0] != "22/tcp ssh" {
+		t.Errorf("state lists ports %v and services %v, want only the open ones", state.OpenPorts, state.Services)
+	}
+	if !state.LastSeen.Equal(ev.Time) {
+		t.Errorf("last_seen = %v, want %v", state.LastSeen, ev.Time)
+	}
+}
+
+func TestMQTTTopicSafe(t *testing.T) {
+	if got := topicSafe("fe80::1/64+#"); got != "fe80::1_64__" {
+		t.Errorf("topicSafe = %q", got)
+	}
+}
+
+func TestMQTTQoS1(t *testing.T) {
+	openTestDB(t)
+	scanID := insertScan(t)
+
+	b := startBroker(t, &mqttBroker{})
+	n := mqttNotifier(t, fmt.Sprintf(`{"broker": "mqtt://%s", "qos": 1}`, b.addr))
+
+	if err := n.Notify(context.Background(), NewEvent(EventScanFinished, scanID)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, published := b.state()
+	if len(published) != 2 {
+		t.Fatalf("published %d messages, want 2", len(published))
+	}
+	for i, p := range published {
+		if p.qos != 1 || p.packetID != uint16(i+1) {
+			t.Errorf("message %d sent with QoS %d and packet id %d", i, p.qos, p.packetID)
+		}
+	}
+}
+
+func TestMQTTPubackTimeout(t *testing.T) {
+	b := startBroker(t, &mqttBroker{noPuback: true})
+	n := mqttNotifier(t, fmt.Sprintf(`{"broker": "mqtt://%s", "qos": 1}`, b.addr))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := n.Notify(ctx, NewEvent(EventScanStarted, 3))
+	if err == nil || !strings.Contains(err.Error(), "puback") {
+		t.Fatalf("err = %v, want a PUBACK timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gave up after %v, the context deadline is 300ms", elapsed)
+	}
+}
+
+func TestMQTTReconnect(t *testing.T) {
+	b := startBroker(t, &mqttBroker{dropFirst: true})
+	n := mqttNotifier(t, fmt.Sprintf(`{"broker": "mqtt://%s", "qos": 1}`, b.addr))
+
+	if err := n.Notify(context.Background(), NewEvent(EventScanStarted, 3)); err == nil {
+		t.Fatal("dropped connection was not reported")
+	}
+	for _, id := range []int{4, 5} {
+		if err := n.Notify(context.Background(), NewEvent(EventScanStarted, id)); err != nil {
+			t.Fatalf("delivery after the dropped connection: %v", err)
+		}
+	}
+
+	connects, published := b.state()
+	if len(connects) != 3 {
+		t.Errorf("got %d connections, want a fresh session per delivery", len(connects))
+	}
+	if len(published) != 2 {
+		t.Errorf("published %d messages, want 2", len(published))
+	}
+}
+
+func TestMQTTTLS(t *testing.T) {
+	serverTLS, _ := testTLS(t)
+	b := startBroker(t, &mqttBroker{tls: serverTLS})
+
+	// The test certificate is self-signed, so only insecure_tls gets through
+	n := mqttNotifier(t, fmt.Sprintf(`{"broker": "mqtts://%s"}`, b.addr))
+	if err := n.Notify(context.Background(), NewEvent(EventScanStarted, 3)); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("err = %v, want the untrusted certificate rejected", err)
+	}
+
+	n = mqttNotifier(t, fmt.Sprintf(`{"broker": "mqtts://%s", "insecure_tls": true}`, b.addr))
+	if err := n.Notify(context.Background(), NewEvent(EventScanStarted, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if _, published := b.state(); len(published) != 1 {
+		t.Errorf("published %d messages over TLS, want 1", len(published))
+	}
+}
+
+func TestMQTTConfig(t *testing.T) {
+	for config, want := range map[string]string{
+		`{"broker": "http://broker"}`:           "unsupported broker scheme",
+		`{"broker": "mqtt://"}`:                 "invalid broker",
+		`{"broker": "mqtt://broker", "qos": 2}`: "qos must be 0 or 1",
+	} {
+		_, err := Build(models.Channel{Name: "mqtt", Type: "mqtt", Config: json.RawMessage(config)})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", config, err, want)
+		}
+	}
+
+	n := mqttNotifier(t, `{"broker": "mqtts://broker"}`).(*MQTTNotifier)
+	if n.addr != "broker:8883" || !n.useTLS {
+		t.Errorf("mqtts without a port dials %s (TLS %v), want broker:8883 over TLS", n.addr, n.useTLS)
+	}
+}
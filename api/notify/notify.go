@@ -0,0 +1,361 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+const (
+	queueSize     = 256
+	notifyTimeout = 2 * time.Minute
+)
+
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, ev Event) error
+}
+
+// Factory builds a notifier from a stored channel's type-specific JSON config
+type Factory func(ch models.Channel) (Notifier, error)
+
+var factories = map[string]Factory{}
+
+// Config fields per channel type that must never be returned by the API
+var secretFields = map[string][]string{}
+
+func register(channelType string, f Factory, secrets ...string) {
+	factories[channelType] = f
+	secretFields[channelType] = secrets
+}
+
+func ChannelTypes() []string {
+	types := make([]string, 0, len(factories))
+	for t := range factories {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// Validate checks that a channel can be built, without registering it
+func Validate(ch models.Channel) error {
+	factory, ok := factories[ch.Type]
+	if !ok {
+		return fmt.Errorf("unknown channel type %q", ch.Type)
+	}
+	for _, e := range ch.Events {
+		if !slices.Contains(EventTypes, EventType(e)) {
+			return fmt.Errorf("unknown event type %q", e)
+		}
+	}
+	_, err := factory(ch)
+	return err
+}
+
+// Build creates a notifier for a stored channel
+func Build(ch models.Channel) (Notifier, error) {
+	factory, ok := factories[ch.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown channel type %q", ch.Type)
+	}
+	return factory(ch)
+}
+
+// worker delivers events to one notifier in publish order
+type worker struct {
+	target   string
+	notifier Notifier
+	events   []string
+	ruleOnly bool
+	queue    chan delivery
+	done     chan struct{}
+}
+
+func (w *worker) wants(ev Event) bool {
+	return !w.ruleOnly && (len(w.events) == 0 || slices.Contains(w.events, string(ev.Type)))
+}
+
+// enqueue records a new delivery in the notification log and queues it
+func (w *worker) enqueue(ev Event) {
+	d := delivery{ev: ev}
+
+	payload, err := json.Marshal(ev)
+	if err == nil {
+		d.id, err = db.CreateNotification(db.DB, models.Notification{
+			Target:  w.target,
+			Channel: w.notifier.Name(),
+			Event:   string(ev.Type),
+			Payload: payload,
+		})
+	}
+	if err != nil {
+		log.Printf("Notifier %s: failed to log %s event: %v", w.notifier.Name(), ev.Type, err)
+	}
+
+	w.push(d)
+}
+
+func (w *worker) push(d delivery) {
+	select {
+	case w.queue <- d:
+	default:
+		finish(w.notifier.Name(), d, errors.New("queue full"))
+	}
+}
+
+func (w *worker) run() {
+	defer close(w.done)
+	for d := range w.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		err := w.notifier.Notify(ctx, d.ev)
+		cancel()
+		finish(w.notifier.Name(), d, err)
+	}
+}
+
+type Registry struct {
+	mu       sync.RWMutex
+	workers  []*worker
+	byTarget map[string]*worker
+	router   *router
+}
+
+var Default = &Registry{}
+
+// Publish queues an event for every interested notifier without blocking the caller.
+// Findings, new hosts and port changes also go wherever a matching alert rule sends them;
+// a subscribed channel that a matching rule targets only gets what the rule lets through,
+// so its throttling and quiet hours apply there too.
+func (r *Registry) Publish(ev Event) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var covered map[*worker]bool
+	if r.router != nil && alertable(ev.Type) {
+		covered = r.router.route(ev)
+	}
+
+	for _, w := range r.workers {
+		if w.wants(ev) && !covered[w] {
+			w.enqueue(ev)
+		}
+	}
+}
+
+// flushHeld delivers the alerts held during quiet hours once they are over
+func (r *Registry) flushHeld() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.router != nil {
+		r.router.flush(time.Now())
+	}
+}
+
+// set replaces the active notifiers; old workers finish their queued events in the background
+func (r *Registry) set(workers []*worker, rt *router) {
+	byTarget := map[string]*worker{}
+	for _, w := range workers {
+		byTarget[w.target] = w
+	}
+
+	r.mu.Lock()
+	old := r.workers
+	r.workers = workers
+	r.byTarget = byTarget
+	r.router = rt
+	r.mu.Unlock()
+
+	for _, w := range old {
+		close(w.queue)
+	}
+	for _, w := range workers {
+		go w.run()
+	}
+}
+
+// Close stops all workers and waits until their queues are drained or ctx expires
+func (r *Registry) Close(ctx context.Context) error {
+	r.mu.Lock()
+	old := r.workers
+	r.workers = nil
+	r.byTarget = nil
+	r.router = nil
+	r.mu.Unlock()
+
+	for _, w := range old {
+		close(w.queue)
+	}
+	for _, w := range old {
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func newWorker(target string, n Notifier, events []string) *worker {
+	return &worker{
+		target:   target,
+		notifier: n,
+		events:   events,
+		queue:    make(chan delivery, queueSize),
+		done:     make(chan struct{}),
+	}
+}
+
+// Reload rebuilds the registry from the notifiers and alert_rules tables. Email is registered while SMTP is configured.
+func (r *Registry) Reload() error {
+	channels, err := db.GetChannels(db.DB)
+	if err != nil {
+		return err
+	}
+
+	rules, err := db.GetAlertRules(db.DB)
+	if err != nil {
+		return err
+	}
+
+	// Email workers only exist while SMTP is set up, otherwise every delivery
+	// would fail, retry and dead-letter. Saving SMTP settings reloads.
+	mail, err := smtpConfigured()
+	if err != nil {
+		return err
+	}
+
+	var workers []*worker
+	digest := &EmailNotifier{rules: map[int]bool{}}
+	if mail {
+		workers = append(workers, newWorker(emailTarget, digest, []string{string(EventScanFinished)}))
+	}
+	rt := &router{channels: map[int]*worker{}, email: map[int]*worker{}}
+
+	for _, ch := range channels {
+		if !ch.Enabled {
+			continue
+		}
+
+		n, err := Build(ch)
+		if err != nil {
+			log.Printf("Skipping notifier %q: %v", ch.Name, err)
+			continue
+		}
+		w := newWorker(channelTarget(ch.ID), n, ch.Events)
+		rt.channels[ch.ID] = w
+		workers = append(workers, w)
+	}
+
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		rt.rules = append(rt.rules, rule)
+
+		if len(rule.Recipients) > 0 && !mail {
+			log.Printf("Alert rule %q: SMTP is not configured, not emailing its recipients", rule.Name)
+		} else if len(rule.Recipients) > 0 {
+			w := newWorker(ruleTarget(rule.ID), &alertEmail{rule: rule.Name, recipients: rule.Recipients}, nil)
+			w.ruleOnly = true
+			rt.email[rule.ID] = w
+			digest.rules[rule.ID] = true
+			workers = append(workers, w)
+		}
+	}
+
+	if rt.quiet, err = db.GetQuietHours(db.DB); err != nil {
+		return err
+	}
+
+	r.set(workers, rt)
+	return nil
+}
+
+func Publish(ev Event) {
+	Default.Publish(ev)
+}
+
+func Reload() error {
+	return Default.Reload()
+}
+
+func Close(ctx context.Context) error {
+	return Default.Close(ctx)
+}
+
+// RedactConfig blanks secret fields of a channel config for API responses.
+// A secret field holding an object, like webhook headers, has each value blanked.
+func RedactConfig(ch models.Channel) models.Channel {
+	ch.Config = mapConfig(ch.Config, func(cfg map[string]any) {
+		for _, key := range secretFields[ch.Type] {
+			switch v := cfg[key].(type) {
+			case string:
+				if v != "" {
+					cfg[key] = redacted
+				}
+			case map[string]any:
+				for k, item := range v {
+					if item, ok := item.(string); ok && item != "" {
+						v[k] = redacted
+					}
+				}
+			}
+		}
+	})
+	return ch
+}
+
+// KeepSecrets copies secret fields from the stored config when the update sends them back redacted
+func KeepSecrets(updated, stored models.Channel) models.Channel {
+	var old map[string]any
+	_ = json.Unmarshal(stored.Config, &old)
+
+	updated.Config = mapConfig(updated.Config, func(cfg map[string]any) {
+		for _, key := range secretFields[updated.Type] {
+			if cfg[key] == redacted {
+				cfg[key] = old[key]
+			}
+			if values, ok := cfg[key].(map[string]any); ok {
+				oldValues, _ := old[key].(map[string]any)
+				for k, v := range values {
+					if v != redacted {
+						continue
+					}
+					if stored, ok := oldValues[k]; ok {
+						values[k] = stored
+					} else {
+						delete(values, k)
+					}
+				}
+			}
+		}
+	})
+	return updated
+}
+
+const redacted = "********"
+
+func mapConfig(raw json.RawMessage, fn func(map[string]any)) json.RawMessage {
+	var cfg map[string]any
+	if err := json.Unmarshal(raw, &cfg); err != nil || cfg == nil {
+		return raw
+	}
+
+	fn(cfg)
+
+	out, err := json.Marshal(cfg)
+	if err != nil {
+		return raw
+	}
+	return out
+}
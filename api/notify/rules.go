@@ -0,0 +1,292 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"time"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+// router delivers alert events to the destinations of matching alert rules.
+// Channels keep receiving the events they subscribed to alongside it.
+type router struct {
+	rules    []models.AlertRule
+	quiet    models.QuietHours
+	channels map[int]*worker
+	email    map[int]*worker
+}
+
+// alertable events are routed by rules, scan lifecycle events are always broadcast
+func alertable(t EventType) bool {
+	return t == EventNewFinding || t == EventNewHost || t == EventBaselineDeviation
+}
+
+// match narrows ev to the part a rule applies to and returns one throttle
+// fingerprint per matched item (finding, host or opened port)
+func match(rule models.AlertRule, ev Event) (Event, []string) {
+	switch {
+	case rule.Trigger == models.TriggerFinding && ev.Type == EventNewFinding && ev.Finding != nil:
+		f := ev.Finding
+		if f.Score < rule.MinScore || (rule.KEVOnly && !f.KEV) ||
+			!rule.MatchesPort(f.PortNum, f.ServiceName) || !rule.InTargets(f.Address) {
+			return ev, nil
+		}
+		return ev, []string{fmt.Sprintf("%s|%d/%s|%s", f.Address, f.PortNum, f.Protocol, f.ID)}
+
+	case rule.Trigger == models.TriggerNewHost && ev.Type == EventNewHost:
+		if !rule.InTargets(ev.Host) {
+			return ev, nil
+		}
+		return ev, []string{ev.Host}
+
+	case rule.Trigger == models.TriggerNewPort && ev.Type == EventBaselineDeviation:
+		if !rule.InTargets(ev.Host) {
+			return ev, nil
+		}
+
+		var opened []models.PortChange
+		var keys []string
+		for _, c := range ev.Changes {
+			if c.Change == "opened" && rule.MatchesPort(c.PortNum, c.ServiceName) {
+				opened = append(opened, c)
+				keys = append(keys, fmt.Sprintf("%s|%d/%s", ev.Host, c.PortNum, c.Protocol))
+			}
+		}
+		ev.Changes = opened
+		return ev, keys
+	}
+
+	return ev, nil
+}
+
+// throttle drops the items of alert that the rule already reported within its window
+func throttle(rule models.AlertRule, alert Event, keys []string, now time.Time) (Event, bool) {
+	window := time.Duration(rule.ThrottleMinutes) * time.Minute
+
+	var kept []models.PortChange
+	sent := 0
+	for i, key := range keys {
+		ok, err := db.ShouldAlert(db.DB, rule.ID, key, window, now)
+		if err != nil {
+			// Better a duplicate alert than a lost one
+			log.Printf("Alert rule %q throttle check failed: %v", rule.Name, err)
+			ok = true
+		}
+		if !ok {
+			continue
+		}
+
+		sent++
+		if alert.Type == EventBaselineDeviation {
+			kept = append(kept, alert.Changes[i])
+		}
+	}
+
+	if alert.Type == EventBaselineDeviation {
+		alert.Changes = kept
+		alert.Message = fmt.Sprintf("%d new open ports on %s", len(kept), alert.Host)
+	}
+	return alert, sent > 0
+}
+
+// route sends ev to the destinations of every matching rule. It returns the workers
+// those rules cover, including ones whose alert was throttled or held for quiet hours,
+// so subscribed channels do not get the raw event around the rule.
+func (rt *router) route(ev Event) map[*worker]bool {
+	now := time.Now()
+	covered := map[*worker]bool{}
+	delivered := map[*worker]bool{}
+
+	for _, rule := range rt.rules {
+		alert, keys := match(rule, ev)
+		if len(keys) == 0 {
+			continue
+		}
+		for _, w := range rt.targets(rule) {
+			covered[w] = true
+		}
+
+		alert, ok := throttle(rule, alert, keys, now)
+		if !ok {
+			continue
+		}
+
+		if msg, _ := buildMessage(alert, chatConfig{}); rt.quiet.Active(now) && msg.Severity != "critical" {
+			hold(rule, alert)
+			continue
+		}
+
+		rt.deliver(rule, alert, delivered)
+	}
+	return covered
+}
+
+// targets returns the workers of the rule's channel and recipients
+func (rt *router) targets(rule models.AlertRule) []*worker {
+	var workers []*worker
+	if w, ok := rt.channels[rule.ChannelID]; ok {
+		workers = append(workers, w)
+	}
+	if w, ok := rt.email[rule.ID]; ok {
+		workers = append(workers, w)
+	}
+	return workers
+}
+
+// deliver queues alert on the rule's channel and recipients, skipping workers already in delivered
+func (rt *router) deliver(rule models.AlertRule, alert Event, delivered map[*worker]bool) {
+	if _, ok := rt.channels[rule.ChannelID]; rule.ChannelID != 0 && !ok {
+		log.Printf("Alert rule %q: channel %d is missing or disabled", rule.Name, rule.ChannelID)
+	}
+	for _, w := range rt.targets(rule) {
+		if !delivered[w] {
+			delivered[w] = true
+			w.enqueue(alert)
+		}
+	}
+}
+
+// hold queues an alert raised during quiet hours until they end
+func hold(rule models.AlertRule, alert Event) {
+	payload, err := json.Marshal(alert)
+	if err == nil {
+		err = db.QueueAlert(db.DB, rule.ID, payload)
+	}
+	if err != nil {
+		log.Printf("Alert rule %q: failed to queue %s for quiet hours: %v", rule.Name, alert.Type, err)
+	}
+}
+
+// flush sends the alerts held during quiet hours to their rules' channels once quiet
+// hours are over. Alerts of rules that email wait for the next digest instead, and
+// alerts of rules deleted or disabled in the meantime are dropped.
+func (rt *router) flush(now time.Time) {
+	if rt.quiet.Active(now) {
+		return
+	}
+
+	queued, err := db.QueuedAlerts(db.DB)
+	if err != nil {
+		log.Printf("Failed to load alerts held during quiet hours: %v", err)
+		return
+	}
+
+	rules := map[int]models.AlertRule{}
+	for _, rule := range rt.rules {
+		rules[rule.ID] = rule
+	}
+
+	// One alert held by several rules reaches a shared channel once
+	delivered := map[string]map[*worker]bool{}
+	var done []int
+	for _, q := range queued {
+		if _, ok := rt.email[q.RuleID]; ok {
+			continue
+		}
+		done = append(done, q.ID)
+
+		rule, ok := rules[q.RuleID]
+		if !ok {
+			log.Printf("Held alert %d: rule %d is no longer enabled, dropping it", q.ID, q.RuleID)
+			continue
+		}
+
+		var alert Event
+		if err := json.Unmarshal(q.Event, &alert); err != nil {
+			log.Printf("Held alert %d: invalid payload: %v", q.ID, err)
+			continue
+		}
+
+		key := string(q.Event)
+		if delivered[key] == nil {
+			delivered[key] = map[*worker]bool{}
+		}
+		rt.deliver(rule, alert, delivered[key])
+	}
+
+	if len(done) == 0 {
+		return
+	}
+	if err := db.DeleteQueuedAlerts(db.DB, done); err != nil {
+		log.Printf("Failed to clear alerts held during quiet hours: %v", err)
+	}
+}
+
+// heldAlerts renders the alerts queued during quiet hours as digest lines
+func heldAlerts(queued []db.QueuedAlert) []string {
+	var lines []string
+	for _, q := range queued {
+		var ev Event
+		if err := json.Unmarshal(q.Event, &ev); err != nil {
+			continue
+		}
+
+		msg, _ := buildMessage(ev, chatConfig{})
+		line := ev.Time.Local().Format("Jan 02 15:04") + " " + msg.Title
+		if ev.Finding != nil {
+			line += " - " + findingLine(*ev.Finding)
+		} else if ev.Message != "" {
+			line += " - " + ev.Message
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+var alertHTML = htmltemplate.Must(htmltemplate.New("alert").Parse(`<html><body style="font-family: sans-serif; color: #222;">
+<h2>{{.Title}}</h2>
+{{if .Text}}<p>{{.Text}}</p>{{end}}
+{{if .Fields}}<table cellpadding="4">
+{{range .Fields}}<tr><td><b>{{.Name}}</b></td><td>{{.Value}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Lines}}<ul>
+{{range .Lines}}<li>{{.}}</li>
+{{end}}</ul>{{end}}
+{{if .Link}}<p><a href="{{.Link}}">Open in Sentry</a></p>{{end}}
+</body></html>`))
+
+// alertEmail mails single rule alerts to the recipients of one rule
+type alertEmail struct {
+	rule       string
+	recipients []string
+}
+
+func (a *alertEmail) Name() string {
+	return "email:" + a.rule
+}
+
+func (a *alertEmail) Notify(ctx context.Context, ev Event) error {
+	settings, err := db.GetSMTPSettings(db.DB)
+	if err != nil {
+		return err
+	}
+
+	msg, _ := buildMessage(ev, chatConfig{UIURL: settings.UIURL})
+
+	var html bytes.Buffer
+	if err := alertHTML.Execute(&html, msg); err != nil {
+		return err
+	}
+
+	text := msg.Title + "\n\n"
+	if msg.Text != "" {
+		text += msg.Text + "\n\n"
+	}
+	for _, f := range msg.Fields {
+		text += f.Name + ": " + f.Value + "\n"
+	}
+	for _, l := range msg.Lines {
+		text += "- " + l + "\n"
+	}
+	if msg.Link != "" {
+		text += "\n" + msg.Link + "\n"
+	}
+
+	return SendMail(settings, a.recipients, Mail{Subject: "[Sentry] " + msg.Title, HTML: html.String(), Text: text})
+}
@@ -0,0 +1,169 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+// recorder is a notifier that keeps every event it is handed
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) Name() string {
+	return "recorder"
+}
+
+func (r *recorder) Notify(ctx context.Context, ev Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+	return nil
+}
+
+func (r *recorder) received() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+// testRouting is a registry with one channel subscribed to every event and a
+// finding rule that targets it
+type testRouting struct {
+	registry *Registry
+	router   *router
+	channel  *recorder
+	rule     models.AlertRule
+}
+
+func startRouting(t *testing.T, quiet bool) *testRouting {
+	t.Helper()
+
+	rule := models.AlertRule{ID: 1, Name: "high", Enabled: true, Trigger: models.TriggerFinding, MinScore: 7, ChannelID: 1, ThrottleMinutes: 60}
+	rec := &recorder{}
+	w := newWorker(channelTarget(1), rec, nil)
+
+	rt := &router{rules: []models.AlertRule{rule}, channels: map[int]*worker{1: w}, email: map[int]*worker{}}
+	if quiet {
+		rt.quiet = quietNow()
+	}
+
+	r := &Registry{}
+	r.set([]*worker{w}, rt)
+	return &testRouting{registry: r, router: rt, channel: rec, rule: rule}
+}
+
+// quietNow returns quiet hours that are on for the next hour
+func quietNow() models.QuietHours {
+	now := time.Now()
+	return models.QuietHours{Enabled: true, Start: now.Add(-time.Hour).Format("15:04"), End: now.Add(time.Hour).Format("15:04")}
+}
+
+// drain waits until the channel worker handled everything published so far
+func (tr *testRouting) drain(t *testing.T) []Event {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tr.registry.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return tr.channel.received()
+}
+
+func TestRouteThrottledFindingOnSubscribedChannel(t *testing.T) {
+	openTestDB(t)
+	tr := startRouting(t, false)
+
+	// The second report of the finding is throttled by the rule and must not
+	// reach the channel through its subscription either
+	tr.registry.Publish(findingEvent(7.5))
+	tr.registry.Publish(findingEvent(7.5))
+
+	// Findings the rule does not match still arrive through the subscription
+	tr.registry.Publish(findingEvent(4.0))
+
+	// Scan lifecycle events are never routed by rules
+	tr.registry.Publish(NewEvent(EventScanFinished, 1))
+
+	got := tr.drain(t)
+	if len(got) != 3 {
+		t.Fatalf("channel got %d events, want the first high finding, the medium one and scan.finished: %+v", len(got), got)
+	}
+	if got[0].Finding.Score != 7.5 || got[1].Finding.Score != 4.0 || got[2].Type != EventScanFinished {
+		t.Errorf("channel got %+v", got)
+	}
+}
+
+func TestRouteQuietHoursOnSubscribedChannel(t *testing.T) {
+	openTestDB(t)
+	tr := startRouting(t, true)
+
+	// Held for quiet hours, and not sent around the rule through the subscription
+	tr.registry.Publish(findingEvent(7.5))
+	// Critical alerts are never held
+	critical := findingEvent(9.8)
+	critical.Finding.ID = "CVE-2024-0003"
+	tr.registry.Publish(critical)
+
+	got := tr.drain(t)
+	if len(got) != 1 || got[0].Finding.ID != "CVE-2024-0003" {
+		t.Fatalf("channel got %+v during quiet hours, want only the critical finding", got)
+	}
+	if queued, _ := db.QueuedAlerts(db.DB); len(queued) != 1 || queued[0].RuleID != tr.rule.ID {
+		t.Fatalf("queued %+v, want the high finding held for rule %d", queued, tr.rule.ID)
+	}
+}
+
+func TestFlushHeldToChannel(t *testing.T) {
+	openTestDB(t)
+	tr := startRouting(t, true)
+	tr.registry.Publish(findingEvent(7.5))
+
+	// Nothing goes out while quiet hours are on
+	tr.registry.flushHeld()
+	if queued, _ := db.QueuedAlerts(db.DB); len(queued) != 1 {
+		t.Fatalf("flush during quiet hours left %d alerts queued, want 1", len(queued))
+	}
+
+	tr.router.quiet = models.QuietHours{}
+	tr.registry.flushHeld()
+
+	got := tr.drain(t)
+	if len(got) != 1 || got[0].Finding == nil || got[0].Finding.ID != "CVE-2024-6387" {
+		t.Fatalf("channel got %+v after quiet hours, want the held finding", got)
+	}
+	if queued, _ := db.QueuedAlerts(db.DB); len(queued) != 0 {
+		t.Errorf("%d alerts still queued after the flush", len(queued))
+	}
+}
+
+func TestFlushLeavesEmailingRulesToTheDigest(t *testing.T) {
+	openTestDB(t)
+
+	mailing := models.AlertRule{ID: 2, Name: "mail", Enabled: true, Trigger: models.TriggerFinding, ChannelID: 1}
+	w := newWorker(ruleTarget(2), &recorder{}, nil)
+	w.ruleOnly = true
+	rt := &router{
+		rules:    []models.AlertRule{mailing},
+		channels: map[int]*worker{},
+		email:    map[int]*worker{2: w},
+	}
+
+	hold(mailing, findingEvent(7.5))
+	hold(models.AlertRule{ID: 3, Name: "deleted"}, findingEvent(7.5))
+	rt.flush(time.Now())
+
+	queued, err := db.QueuedAlerts(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0].RuleID != 2 {
+		t.Errorf("queued %+v, want only the emailing rule's alert kept for the digest", queued)
+	}
+}
@@ -0,0 +1,113 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+func init() {
+	register("slack", newSlack, "webhook_url")
+}
+
+type slackConfig struct {
+	WebhookURL string `json:"webhook_url"`
+	chatConfig
+}
+
+// SlackNotifier posts Block Kit messages to a Slack incoming webhook
+type SlackNotifier struct {
+	name   string
+	config slackConfig
+	client *http.Client
+}
+
+func newSlack(ch models.Channel) (Notifier, error) {
+	var cfg slackConfig
+	if err := json.Unmarshal(ch.Config, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid slack config: %v", err)
+	}
+	if err := validateURL(cfg.WebhookURL); err != nil {
+		return nil, fmt.Errorf("invalid webhook_url")
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return &SlackNotifier{name: ch.Name, config: cfg, client: &http.Client{Timeout: httpTimeout}}, nil
+}
+
+func (s *SlackNotifier) Name() string {
+	return s.name
+}
+
+func (s *SlackNotifier) Notify(ctx context.Context, ev Event) error {
+	msg, ok := buildMessage(ev, s.config.chatConfig)
+	if !ok {
+		return nil
+	}
+
+	body, err := json.Marshal(slackPayload(msg))
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.client, s.config.WebhookURL, body, nil, defaultMaxRetries)
+}
+
+func slackPayload(msg message) map[string]any {
+	blocks := []map[string]any{{
+		"type": "header",
+		"text": map[string]any{"type": "plain_text", "text": truncate(msg.Title, 150)},
+	}}
+
+	text := slackEscape(msg.Text)
+	for _, line := range msg.Lines {
+		text += "\n• " + slackEscape(line)
+	}
+	if text = strings.TrimSpace(text); text != "" {
+		blocks = append(blocks, map[string]any{
+			"type": "section",
+			"text": map[string]any{"type": "mrkdwn", "text": text},
+		})
+	}
+
+	if len(msg.Fields) > 0 {
+		var fields []map[string]any
+		for _, f := range msg.Fields {
+			fields = append(fields, map[string]any{
+				"type": "mrkdwn",
+				"text": "*" + slackEscape(f.Name) + "*\n" + slackEscape(f.Value),
+			})
+		}
+		// Slack allows at most 10 fields per section
+		for len(fields) > 0 {
+			n := min(len(fields), 10)
+			blocks = append(blocks, map[string]any{"type": "section", "fields": fields[:n]})
+			fields = fields[n:]
+		}
+	}
+
+	if msg.Link != "" {
+		blocks = append(blocks, map[string]any{
+			"type": "actions",
+			"elements": []map[string]any{{
+				"type": "button",
+				"text": map[string]any{"type": "plain_text", "text": "Open in Sentry"},
+				"url":  msg.Link,
+			}},
+		})
+	}
+
+	return map[string]any{
+		"text":   msg.Title,
+		"blocks": blocks,
+	}
+}
+
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,399 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wiktoz/sentry/models"
+	"github.com/wiktoz/sentry/risk"
+)
+
+const (
+	syslogTimeout = 15 * time.Second
+	productVendor = "Sentry"
+	productName   = "Sentry"
+	// Bump when the CEF/LEEF field mapping below changes
+	productVersion = "1.0"
+
+	// Pseudo event for each finding carried by scan.finished
+	eventFindingDetected = "finding.detected"
+)
+
+func init() {
+	register("syslog", newSyslog)
+}
+
+type syslogConfig struct {
+	Network     string `json:"network"`
+	Address     string `json:"address"`
+	Format      string `json:"format"`
+	Facility    *int   `json:"facility"`
+	AppName     string `json:"app_name"`
+	Hostname    string `json:"hostname"`
+	InsecureTLS bool   `json:"insecure_tls"`
+}
+
+// SyslogNotifier sends RFC 5424 messages over UDP, TCP or TLS (octet-counted framing
+// per RFC 6587/5425) with a JSON, ArcSight CEF or QRadar LEEF payload.
+// scan.finished additionally emits one finding.detected record per active finding.
+type SyslogNotifier struct {
+	name   string
+	config syslogConfig
+}
+
+func newSyslog(ch models.Channel) (Notifier, error) {
+	var cfg syslogConfig
+	if err := json.Unmarshal(ch.Config, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid syslog config: %v", err)
+	}
+
+	switch cfg.Network {
+	case "":
+		cfg.Network = "udp"
+	case "udp", "tcp", "tls":
+	default:
+		return nil, errors.New("network must be udp, tcp or tls")
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return nil, errors.New("address must be host:port")
+	}
+
+	switch cfg.Format {
+	case "":
+		cfg.Format = "json"
+	case "json", "cef", "leef":
+	default:
+		return nil, errors.New("format must be json, cef or leef")
+	}
+
+	if cfg.Facility == nil {
+		local0 := 16
+		cfg.Facility = &local0
+	}
+	if *cfg.Facility < 0 || *cfg.Facility > 23 {
+		return nil, errors.New("facility must be between 0 and 23")
+	}
+
+	if cfg.AppName == "" {
+		cfg.AppName = "sentry"
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+	}
+
+	return &SyslogNotifier{name: ch.Name, config: cfg}, nil
+}
+
+func (s *SyslogNotifier) Name() string {
+	return s.name
+}
+
+// record is the flat, stable view of an event that all payload formats map from
+type record struct {
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	ScanID   int       `json:"scan_id,omitempty"`
+	Message  string    `json:"message,omitempty"`
+	Host     string    `json:"host,omitempty"`
+	Port     int       `json:"port,omitempty"`
+	Protocol string    `json:"protocol,omitempty"`
+	Service  string    `json:"service,omitempty"`
+	VulnID   string    `json:"vuln_id,omitempty"`
+	VulnType string    `json:"vuln_type,omitempty"`
+	CVE      string    `json:"cve,omitempty"`
+	CVSS     float64   `json:"cvss,omitempty"`
+	Severity string    `json:"severity"`
+	Exploit  bool      `json:"exploit,omitempty"`
+	KEV      bool      `json:"kev,omitempty"`
+	EPSS     float64   `json:"epss,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	URL      string    `json:"url,omitempty"`
+}
+
+func findingRecord(event string, ev Event, f models.Finding) record {
+	return record{
+		Event:    event,
+		Time:     ev.Time,
+		ScanID:   ev.ScanID,
+		Message:  fmt.Sprintf("%s (%.1f) on %s:%d/%s", f.ID, f.Score, f.Address, f.PortNum, f.Protocol),
+		Host:     f.Address,
+		Port:     f.PortNum,
+		Protocol: f.Protocol,
+		Service:  f.ServiceName,
+		VulnID:   f.ID,
+		VulnType: f.Type,
+		CVE:      f.CVE,
+		CVSS:     f.Score,
+		Severity: risk.Severity(f.Score),
+		Exploit:  f.Exploit,
+		KEV:      f.KEV,
+		EPSS:     f.EPSS,
+		Status:   f.Status,
+		URL:      f.URL,
+	}
+}
+
+func eventRecords(ev Event) []record {
+	if ev.Type == EventNewFinding && ev.Finding != nil {
+		return []record{findingRecord(string(ev.Type), ev, *ev.Finding)}
+	}
+
+	severity := "none"
+	if ev.Type == EventScanFailed {
+		severity = "high"
+	}
+
+	records := []record{{
+		Event:    string(ev.Type),
+		Time:     ev.Time,
+		ScanID:   ev.ScanID,
+		Message:  ev.Message,
+		Host:     ev.Host,
+		Severity: severity,
+	}}
+
+	if ev.Type == EventScanFinished {
+		for _, f := range ev.Findings {
+			records = append(records, findingRecord(eventFindingDetected, ev, f))
+		}
+	}
+	return records
+}
+
+func (s *SyslogNotifier) Notify(ctx context.Context, ev Event) error {
+	var frames [][]byte
+	for _, rec := range eventRecords(ev) {
+		payload, err := s.payload(rec)
+		if err != nil {
+			return err
+		}
+		frames = append(frames, []byte(s.syslogLine(rec, payload)))
+	}
+
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("syslog connect: %v", err)
+	}
+	defer conn.Close()
+
+	// A deadline per frame, so a large scan.finished is not cut off halfway
+	for _, frame := range frames {
+		if s.config.Network != "udp" {
+			frame = append([]byte(strconv.Itoa(len(frame))+" "), frame...)
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err := conn.Write(frame); err != nil {
+			return fmt.Errorf("syslog write: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *SyslogNotifier) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+
+	if s.config.Network == "tls" {
+		host, _, _ := net.SplitHostPort(s.config.Address)
+		return (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: s.config.InsecureTLS,
+		}}).DialContext(ctx, "tcp", s.config.Address)
+	}
+	return dialer.DialContext(ctx, s.config.Network, s.config.Address)
+}
+
+// syslogLine builds <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID - MSG
+func (s *SyslogNotifier) syslogLine(rec record, payload string) string {
+	pri := *s.config.Facility*8 + syslogSeverity(rec.Severity)
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		pri,
+		rec.Time.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		headerField(s.config.Hostname, 255),
+		headerField(s.config.AppName, 48),
+		os.Getpid(),
+		headerField(rec.Event, 32),
+		payload,
+	)
+}
+
+// headerField makes a value safe for a syslog header: printable ASCII, no spaces, "-" if empty
+func headerField(s string, n int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "-"
+	}
+	if len(s) > n {
+		s = s[:n]
+	}
+	return s
+}
+
+func syslogSeverity(sev string) int {
+	switch sev {
+	case "critical":
+		return 2
+	case "high":
+		return 3
+	case "medium":
+		return 4
+	case "low":
+		return 5
+	default:
+		return 6
+	}
+}
+
+func (s *SyslogNotifier) payload(rec record) (string, error) {
+	switch s.config.Format {
+	case "cef":
+		return formatCEF(rec), nil
+	case "leef":
+		return formatLEEF(rec), nil
+	default:
+		b, err := json.Marshal(rec)
+		return string(b), err
+	}
+}
+
+// siemSeverity maps onto the 0-10 scale used by both CEF and LEEF
+func siemSeverity(rec record) int {
+	if rec.CVSS > 0 {
+		return int(math.Round(rec.CVSS))
+	}
+	if rec.Event == string(EventScanFailed) {
+		return 7
+	}
+	return 1
+}
+
+func eventName(rec record) string {
+	if rec.VulnID != "" {
+		return "Vulnerability " + rec.VulnID
+	}
+	return rec.Message
+}
+
+// formatCEF maps a record onto ArcSight CEF. The extension keys are part of the
+// SIEM contract and must stay stable:
+//
+//	rt, dst, dpt, proto, app, msg, request,
+//	cs1=vulnId cs2=cve cs3=vulnType cs4=status cs5=kev cs6=exploit,
+//	cn1=scanId cfp1=cvss cfp2=epss
+func formatCEF(rec record) string {
+	ext := []string{"rt=" + strconv.FormatInt(rec.Time.UnixMilli(), 10)}
+	add := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+cefValue(value))
+		}
+	}
+	label := func(key, name, value string) {
+		if value != "" {
+			add(key+"Label", name)
+			add(key, value)
+		}
+	}
+
+	add("dst", rec.Host)
+	if rec.Port > 0 {
+		add("dpt", strconv.Itoa(rec.Port))
+	}
+	add("proto", strings.ToUpper(rec.Protocol))
+	add("app", rec.Service)
+	add("msg", rec.Message)
+	add("request", rec.URL)
+
+	label("cs1", "vulnId", rec.VulnID)
+	label("cs2", "cve", rec.CVE)
+	label("cs3", "vulnType", rec.VulnType)
+	label("cs4", "status", rec.Status)
+	if rec.VulnID != "" {
+		label("cs5", "kev", strconv.FormatBool(rec.KEV))
+		label("cs6", "exploit", strconv.FormatBool(rec.Exploit))
+		label("cfp1", "cvss", strconv.FormatFloat(rec.CVSS, 'f', 1, 64))
+		label("cfp2", "epss", strconv.FormatFloat(rec.EPSS, 'f', 5, 64))
+	}
+	if rec.ScanID > 0 {
+		label("cn1", "scanId", strconv.Itoa(rec.ScanID))
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		cefHeader(productVendor), cefHeader(productName), cefHeader(productVersion),
+		cefHeader(rec.Event), cefHeader(eventName(rec)), siemSeverity(rec),
+		strings.Join(ext, " "))
+}
+
+func cefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ").Replace(s)
+}
+
+func cefValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`).Replace(s)
+}
+
+// formatLEEF maps a record onto QRadar LEEF 1.0 (tab delimited). Stable keys:
+//
+//	devTime, cat, sev, dst, dstPort, proto, service, msg, url,
+//	vulnId, cve, vulnType, status, kev, exploit, cvss, epss, scanId
+func formatLEEF(rec record) string {
+	attrs := []string{
+		"devTime=" + rec.Time.UTC().Format("Jan 02 2006 15:04:05"),
+		"devTimeFormat=MMM dd yyyy HH:mm:ss",
+		"cat=" + rec.Event,
+		"sev=" + strconv.Itoa(siemSeverity(rec)),
+	}
+	add := func(key, value string) {
+		if value != "" {
+			attrs = append(attrs, key+"="+leefValue(value))
+		}
+	}
+
+	add("dst", rec.Host)
+	if rec.Port > 0 {
+		add("dstPort", strconv.Itoa(rec.Port))
+	}
+	add("proto", strings.ToUpper(rec.Protocol))
+	add("service", rec.Service)
+	add("msg", rec.Message)
+	add("url", rec.URL)
+	add("vulnId", rec.VulnID)
+	add("cve", rec.CVE)
+	add("vulnType", rec.VulnType)
+	add("status", rec.Status)
+	if rec.VulnID != "" {
+		add("kev", strconv.FormatBool(rec.KEV))
+		add("exploit", strconv.FormatBool(rec.Exploit))
+		add("cvss", strconv.FormatFloat(rec.CVSS, 'f', 1, 64))
+		add("epss", strconv.FormatFloat(rec.EPSS, 'f', 5, 64))
+	}
+	if rec.ScanID > 0 {
+		add("scanId", strconv.Itoa(rec.ScanID))
+	}
+
+	return fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|%s",
+		leefHeader(productVendor), leefHeader(productName), leefHeader(productVersion),
+		leefHeader(rec.Event), strings.Join(attrs, "\t"))
+}
+
+func leefHeader(s string) string {
+	return strings.NewReplacer("|", " ", "\t", " ", "\r", " ", "\n", " ").Replace(s)
+}
+
+func leefValue(s string) string {
+	return strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(s)
+}
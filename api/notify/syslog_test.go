@@ -0,0 +1,340 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+func syslogNotifier(t *testing.T, config string) *SyslogNotifier {
+	t.Helper()
+
+	n, err := Build(models.Channel{Name: "siem", Type: "syslog", Config: json.RawMessage(config)})
+	if err != nil {
+		t.Fatalf("build syslog: %v", err)
+	}
+	return n.(*SyslogNotifier)
+}
+
+var syslogTime = time.Date(2024, 7, 1, 12, 30, 45, 0, time.UTC)
+
+func TestFormatCEF(t *testing.T) {
+	tests := []struct {
+		name string
+		rec  record
+		want []string
+	}{
+		{
+			name: "finding",
+			rec: record{
+				Event: "finding.new", Time: syslogTime, ScanID: 12, Message: "CVE-2024-6387 (8.1) on 10.0.0.5:22/tcp",
+				Host: "10.0.0.5", Port: 22, Protocol: "tcp", Service: "ssh", VulnID: "CVE-2024-6387", VulnType: "cve",
+				CVE: "CVE-2024-6387", CVSS: 8.1, Severity: "high", KEV: true, EPSS: 0.12345, URL: "https://vulners.com/cve/CVE-2024-6387",
+			},
+			want: []string{
+				"CEF:0|Sentry|Sentry|1.0|finding.new|Vulnerability CVE-2024-6387|8|",
+				"rt=1719837045000", "dst=10.0.0.5", "dpt=22", "proto=TCP", "app=ssh",
+				"cs1Label=vulnId cs1=CVE-2024-6387", "cs2Label=cve cs2=CVE-2024-6387", "cs3Label=vulnType cs3=cve",
+				"cs5Label=kev cs5=true", "cs6Label=exploit cs6=false",
+				"cfp1Label=cvss cfp1=8.1", "cfp2Label=epss cfp2=0.12345", "cn1Label=scanId cn1=12",
+				"request=https://vulners.com/cve/CVE-2024-6387",
+			},
+		},
+		{
+			name: "header escaping",
+			rec:  record{Event: "finding.new", Time: syslogTime, VulnID: `a|b\c` + "\nd", Severity: "none"},
+			want: []string{`|finding.new|Vulnerability a\|b\\c d|1|`},
+		},
+		{
+			name: "extension escaping",
+			rec:  record{Event: "scan.failed", Time: syslogTime, Message: `nmap: a=b \ c` + "\r\nnext|line", Severity: "high"},
+			// Pipes need no escaping in extension values
+			want: []string{`|scan.failed|nmap: a=b \\ c  next\|line|7|`, `msg=nmap: a\=b \\ c\r\nnext|line`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatCEF(tt.rec)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("CEF lacks %q:\n%s", want, got)
+				}
+			}
+			if strings.ContainsAny(got, "\r\n") {
+				t.Errorf("CEF spans several lines:\n%q", got)
+			}
+		})
+	}
+
+	// Only findings carry the vulnerability fields
+	if got := formatCEF(record{Event: "scan.started", Time: syslogTime, ScanID: 3, Message: "started"}); strings.Contains(got, "cs5") || strings.Contains(got, "cfp1") {
+		t.Errorf("scan event has finding fields:\n%s", got)
+	}
+}
+
+func TestFormatLEEF(t *testing.T) {
+	rec := record{
+		Event: "finding.new", Time: syslogTime, ScanID: 12, Message: "line one\tcol\nline two",
+		Host: "10.0.0.5", Port: 443, Protocol: "tcp", Service: "https", VulnID: "CVE-2024-0003",
+		CVSS: 7.5, Exploit: true, Status: "accepted_risk", URL: "https://example.com/?a=b",
+	}
+	got := formatLEEF(rec)
+
+	header, attrs, ok := strings.Cut(got, "|finding.new|")
+	if !ok || header != "LEEF:1.0|Sentry|Sentry|1.0" {
+		t.Fatalf("LEEF header = %q", header)
+	}
+
+	fields := map[string]string{}
+	for _, attr := range strings.Split(attrs, "\t") {
+		key, value, ok := strings.Cut(attr, "=")
+		if !ok {
+			t.Fatalf("attribute %q is not key=value", attr)
+		}
+		fields[key] = value
+	}
+
+	for key, want := range map[string]string{
+		"devTime":       "Jul 01 2024 12:30:45",
+		"devTimeFormat": "MMM dd yyyy HH:mm:ss",
+		"cat":           "finding.new",
+		"sev":           "8",
+		"dst":           "10.0.0.5",
+		"dstPort":       "443",
+		"proto":         "TCP",
+		"service":       "https",
+		"msg":           "line one col line two",
+		"url":           "https://example.com/?a=b",
+		"vulnId":        "CVE-2024-0003",
+		"status":        "accepted_risk",
+		"kev":           "false",
+		"exploit":       "true",
+		"cvss":          "7.5",
+		"epss":          "0.00000",
+		"scanId":        "12",
+	} {
+		if fields[key] != want {
+			t.Errorf("%s = %q, want %q", key, fields[key], want)
+		}
+	}
+	if len(fields) != 17 {
+		t.Errorf("got %d attributes, want 17: %v", len(fields), fields)
+	}
+
+	// Tabs and pipes in the event ID cannot break the header apart
+	if got := formatLEEF(record{Event: "a|b\tc", Time: syslogTime}); !strings.HasPrefix(got, "LEEF:1.0|Sentry|Sentry|1.0|a b c|devTime=") {
+		t.Errorf("LEEF header not escaped: %q", got)
+	}
+}
+
+func TestSyslogLine(t *testing.T) {
+	tests := []struct {
+		facility int
+		severity string
+		pri      int
+	}{
+		{16, "critical", 130},
+		{16, "high", 131},
+		{16, "medium", 132},
+		{16, "low", 133},
+		{16, "none", 134},
+		{0, "critical", 2},
+		{4, "high", 35},
+		{23, "none", 190},
+	}
+
+	for _, tt := range tests {
+		s := syslogNotifier(t, fmt.Sprintf(`{"address": "127.0.0.1:514", "facility": %d, "hostname": "scanner 01", "app_name": "sentry"}`, tt.facility))
+		line := s.syslogLine(record{Event: "finding.new", Time: syslogTime, Severity: tt.severity}, "{}")
+
+		want := fmt.Sprintf("<%d>1 2024-07-01T12:30:45.000000Z scanner01 sentry %d finding.new - {}", tt.pri, os.Getpid())
+		if line != want {
+			t.Errorf("facility %d, %s:\ngot  %q\nwant %q", tt.facility, tt.severity, line, want)
+		}
+	}
+
+	for _, config := range []string{
+		`{"address": "127.0.0.1:514", "facility": 24}`,
+		`{"address": "127.0.0.1:514", "facility": -1}`,
+		`{"address": "127.0.0.1"}`,
+		`{"address": "127.0.0.1:514", "network": "http"}`,
+		`{"address": "127.0.0.1:514", "format": "xml"}`,
+	} {
+		if _, err := Build(models.Channel{Type: "syslog", Config: json.RawMessage(config)}); err == nil {
+			t.Errorf("config %s was accepted", config)
+		}
+	}
+}
+
+// readFrames splits an octet-counted stream (RFC 6587 / RFC 5425) into messages
+func readFrames(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	var frames []string
+	r := bufio.NewReader(strings.NewReader(string(data)))
+	for {
+		prefix, err := r.ReadString(' ')
+		if err == io.EOF && prefix == "" {
+			return frames
+		}
+		if err != nil {
+			t.Fatalf("truncated frame length %q", prefix)
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(prefix, " "))
+		if err != nil {
+			t.Fatalf("frame does not start with an octet count: %q", prefix)
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			t.Fatalf("frame shorter than its count %d", n)
+		}
+		frames = append(frames, string(frame))
+	}
+}
+
+// listenStream accepts one connection and returns everything written to it
+func listenStream(t *testing.T, tlsConfig *tls.Config) (string, <-chan []byte) {
+	t.Helper()
+
+	var ln net.Listener
+	var err error
+	if tlsConfig != nil {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	got := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			got <- nil
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		data, _ := io.ReadAll(conn)
+		got <- data
+	}()
+	return ln.Addr().String(), got
+}
+
+func scanFinishedEvent() Event {
+	ev := NewEvent(EventScanFinished, 12)
+	ev.Message = "scan finished"
+	ev.Findings = []models.Finding{*findingEvent(9.8).Finding, *findingEvent(5.3).Finding}
+	return ev
+}
+
+func TestSyslogFraming(t *testing.T) {
+	serverTLS, _ := testTLS(t)
+
+	for _, tt := range []struct {
+		network string
+		tls     *tls.Config
+	}{
+		{"tcp", nil},
+		{"tls", serverTLS},
+	} {
+		t.Run(tt.network, func(t *testing.T) {
+			addr, got := listenStream(t, tt.tls)
+			s := syslogNotifier(t, fmt.Sprintf(`{"network": %q, "address": %q, "format": "cef", "insecure_tls": true}`, tt.network, addr))
+
+			if err := s.Notify(context.Background(), scanFinishedEvent()); err != nil {
+				t.Fatal(err)
+			}
+
+			frames := readFrames(t, <-got)
+			if len(frames) != 3 {
+				t.Fatalf("got %d frames, want scan.finished and one per finding: %q", len(frames), frames)
+			}
+			if !strings.Contains(frames[0], " scan.finished - CEF:0|") {
+				t.Errorf("first frame is not the scan event: %q", frames[0])
+			}
+			for _, f := range frames[1:] {
+				if !strings.Contains(f, " finding.detected - CEF:0|") {
+					t.Errorf("finding frame: %q", f)
+				}
+			}
+			if !strings.HasPrefix(frames[1], "<130>1 ") || !strings.HasPrefix(frames[2], "<132>1 ") {
+				t.Errorf("finding PRIs: %q, %q", frames[1][:7], frames[2][:7])
+			}
+		})
+	}
+
+	t.Run("tls unverified", func(t *testing.T) {
+		addr, _ := listenStream(t, serverTLS)
+		s := syslogNotifier(t, fmt.Sprintf(`{"network": "tls", "address": %q}`, addr))
+		if err := s.Notify(context.Background(), scanFinishedEvent()); err == nil {
+			t.Fatal("self-signed certificate accepted without insecure_tls")
+		}
+	})
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := syslogNotifier(t, fmt.Sprintf(`{"address": %q, "format": "leef"}`, conn.LocalAddr()))
+	if err := s.Notify(context.Background(), scanFinishedEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	// One datagram per message, without an octet count
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64*1024)
+	for i, want := range []string{"scan.finished", "finding.detected", "finding.detected"} {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("datagram %d: %v", i, err)
+		}
+		msg := string(buf[:n])
+		if !strings.HasPrefix(msg, "<") || !strings.Contains(msg, " "+want+" - LEEF:1.0|") {
+			t.Errorf("datagram %d = %q, want a bare %s message", i, msg, want)
+		}
+	}
+}
+
+func TestSyslogJSON(t *testing.T) {
+	addr, got := listenStream(t, nil)
+	s := syslogNotifier(t, fmt.Sprintf(`{"network": "tcp", "address": %q}`, addr))
+
+	if err := s.Notify(context.Background(), findingEvent(9.8)); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := readFrames(t, <-got)
+	if len(frames) != 1 {
+		t.Fatalf("got %d frames", len(frames))
+	}
+	_, payload, _ := strings.Cut(frames[0], " finding.new - ")
+
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(payload), &rec); err != nil {
+		t.Fatalf("payload %q: %v", payload, err)
+	}
+	for key, want := range map[string]any{"event": "finding.new", "host": "10.0.0.5", "port": 22.0, "cvss": 9.8, "severity": "critical", "kev": true, "scan_id": 12.0} {
+		if rec[key] != want {
+			t.Errorf("%s = %v, want %v", key, rec[key], want)
+		}
+	}
+}
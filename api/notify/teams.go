@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+func init() {
+	register("teams", newTeams, "webhook_url")
+}
+
+type teamsConfig struct {
+	WebhookURL string `json:"webhook_url"`
+	chatConfig
+}
+
+// TeamsNotifier posts Adaptive Cards to a Microsoft Teams incoming webhook or workflow URL
+type TeamsNotifier struct {
+	name   string
+	config teamsConfig
+	client *http.Client
+}
+
+func newTeams(ch models.Channel) (Notifier, error) {
+	var cfg teamsConfig
+	if err := json.Unmarshal(ch.Config, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid teams config: %v", err)
+	}
+	if err := validateURL(cfg.WebhookURL); err != nil {
+		return nil, fmt.Errorf("invalid webhook_url")
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return &TeamsNotifier{name: ch.Name, config: cfg, client: &http.Client{Timeout: httpTimeout}}, nil
+}
+
+func (t *TeamsNotifier) Name() string {
+	return t.name
+}
+
+func (t *TeamsNotifier) Notify(ctx context.Context, ev Event) error {
+	msg, ok := buildMessage(ev, t.config.chatConfig)
+	if !ok {
+		return nil
+	}
+
+	body, err := json.Marshal(teamsPayload(msg))
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, t.client, t.config.WebhookURL, body, nil, defaultMaxRetries)
+}
+
+func teamsPayload(msg message) map[string]any {
+	body := []map[string]any{{
+		"type":   "TextBlock",
+		"size":   "Large",
+		"weight": "Bolder",
+		"text":   msg.Title,
+		"color":  teamsColor(msg.Severity),
+		"wrap":   true,
+	}}
+
+	if msg.Text != "" {
+		body = append(body, map[string]any{"type": "TextBlock", "text": msg.Text, "wrap": true})
+	}
+	for _, line := range msg.Lines {
+		body = append(body, map[string]any{"type": "TextBlock", "text": "- " + line, "wrap": true, "spacing": "None"})
+	}
+
+	if len(msg.Fields) > 0 {
+		var facts []map[string]any
+		for _, f := range msg.Fields {
+			facts = append(facts, map[string]any{"title": f.Name, "value": f.Value})
+		}
+		body = append(body, map[string]any{"type": "FactSet", "facts": facts})
+	}
+
+	card := map[string]any{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	if msg.Link != "" {
+		card["actions"] = []map[string]any{{
+			"type":  "Action.OpenUrl",
+			"title": "Open in Sentry",
+			"url":   msg.Link,
+		}}
+	}
+
+	return map[string]any{
+		"type": "message",
+		"attachments": []map[string]any{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	}
+}
+
+func teamsColor(sev string) string {
+	switch sev {
+	case "critical", "high":
+		return "Attention"
+	case "medium":
+		return "Warning"
+	default:
+		return "Default"
+	}
+}
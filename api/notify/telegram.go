@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+const telegramAPI = "https://api.telegram.org"
+
+func init() {
+	register("telegram", newTelegram, "bot_token")
+}
+
+type telegramConfig struct {
+	BotToken string `json:"bot_token"`
+	ChatID   string `json:"chat_id"`
+	APIURL   string `json:"api_url"`
+	chatConfig
+}
+
+// TelegramNotifier sends HTML formatted messages through the Telegram Bot API
+type TelegramNotifier struct {
+	name   string
+	config telegramConfig
+	client *http.Client
+}
+
+func newTelegram(ch models.Channel) (Notifier, error) {
+	var cfg telegramConfig
+	if err := json.Unmarshal(ch.Config, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid telegram config: %v", err)
+	}
+	if cfg.BotToken == "" || cfg.ChatID == "" {
+		return nil, errors.New("bot_token and chat_id are required")
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = telegramAPI
+	}
+	if err := validateURL(cfg.APIURL); err != nil {
+		return nil, fmt.Errorf("invalid api_url")
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return &TelegramNotifier{name: ch.Name, config: cfg, client: &http.Client{Timeout: httpTimeout}}, nil
+}
+
+func (t *TelegramNotifier) Name() string {
+	return t.name
+}
+
+func (t *TelegramNotifier) Notify(ctx context.Context, ev Event) error {
+	msg, ok := buildMessage(ev, t.config.chatConfig)
+	if !ok {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"chat_id":                  t.config.ChatID,
+		"text":                     telegramText(msg),
+		"parse_mode":               "HTML",
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	target := withPath(t.config.APIURL, "/bot"+t.config.BotToken+"/sendMessage")
+	return postJSON(ctx, t.client, target, body, nil, defaultMaxRetries)
+}
+
+func telegramText(msg message) string {
+	var b strings.Builder
+
+	b.WriteString("<b>" + html.EscapeString(msg.Title) + "</b>")
+	if msg.Text != "" {
+		b.WriteString("\n" + html.EscapeString(msg.Text))
+	}
+	for _, line := range msg.Lines {
+		b.WriteString("\n• " + html.EscapeString(line))
+	}
+	if len(msg.Fields) > 0 {
+		b.WriteString("\n")
+	}
+	for _, f := range msg.Fields {
+		b.WriteString("\n<b>" + html.EscapeString(f.Name) + ":</b> " + html.EscapeString(f.Value))
+	}
+	if msg.Link != "" {
+		b.WriteString("\n\n<a href=\"" + html.EscapeString(msg.Link) + "\">Open in Sentry</a>")
+	}
+
+	// Telegram rejects messages over 4096 characters
+	return truncate(b.String(), 4096)
+}
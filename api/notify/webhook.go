@@ -0,0 +1,187 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+const (
+	defaultMaxRetries = 3
+	maxRetries        = 10
+	initialBackoff    = time.Second
+	maxBackoff        = time.Minute
+	httpTimeout       = 15 * time.Second
+)
+
+func init() {
+	register("webhook", newWebhook, "secret", "headers") // header values often carry credentials
+}
+
+type webhookConfig struct {
+	URL        string            `json:"url"`
+	Secret     string            `json:"secret"`
+	Headers    map[string]string `json:"headers"`
+	MaxRetries *int              `json:"max_retries"`
+}
+
+// WebhookNotifier POSTs every event as JSON. With a secret set, the body is signed:
+//
+//	X-Sentry-Signature: sha256=hex(HMAC-SHA256(secret, X-Sentry-Timestamp + "." + body))
+type WebhookNotifier struct {
+	name   string
+	config webhookConfig
+	client *http.Client
+}
+
+func newWebhook(ch models.Channel) (Notifier, error) {
+	var cfg webhookConfig
+	if err := json.Unmarshal(ch.Config, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid webhook config: %v", err)
+	}
+
+	if err := validateURL(cfg.URL); err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxRetries == nil {
+		n := defaultMaxRetries
+		cfg.MaxRetries = &n
+	}
+	if *cfg.MaxRetries < 0 || *cfg.MaxRetries > maxRetries {
+		return nil, fmt.Errorf("max_retries must be between 0 and %d", maxRetries)
+	}
+
+	return &WebhookNotifier{
+		name:   ch.Name,
+		config: cfg,
+		client: &http.Client{Timeout: httpTimeout},
+	}, nil
+}
+
+func (wh *WebhookNotifier) Name() string {
+	return wh.name
+}
+
+func (wh *WebhookNotifier) Notify(ctx context.Context, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{}
+	for k, v := range wh.config.Headers {
+		headers[k] = v
+	}
+	headers["X-Sentry-Event"] = string(ev.Type)
+
+	if wh.config.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		headers["X-Sentry-Timestamp"] = ts
+		headers["X-Sentry-Signature"] = "sha256=" + Sign(wh.config.Secret, ts, body)
+	}
+
+	return postJSON(ctx, wh.client, wh.config.URL, body, headers, *wh.config.MaxRetries)
+}
+
+// Sign computes the webhook signature so receivers can verify it the same way
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q", raw)
+	}
+	return nil
+}
+
+// retryableError marks failures worth another attempt (network errors, 429, 5xx)
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+// postJSON sends body and retries transient failures with exponential backoff
+func postJSON(ctx context.Context, client *http.Client, target string, body []byte, headers map[string]string, retries int) error {
+	backoff := initialBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := postOnce(ctx, client, target, body, headers)
+		if err == nil {
+			return nil
+		}
+
+		var re *retryableError
+		if !errors.As(err, &re) || attempt >= retries {
+			return err
+		}
+
+		wait := max(backoff, re.retryAfter)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("%v (gave up: %v)", err, ctx.Err())
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func postOnce(ctx context.Context, client *http.Client, target string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sentry")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Drop the URL from the error, chat webhook URLs embed their credentials
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return &retryableError{err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(snippet))
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &retryableError{err: err, retryAfter: min(time.Duration(retryAfter)*time.Second, maxBackoff)}
+	}
+	return err
+}
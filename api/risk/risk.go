@@ -0,0 +1,141 @@
+package risk
+
+import (
+	"math"
+	"sort"
+
+	"github.com/wiktoz/sentry/models"
+)
+
+const (
+	DefaultCriticality = 3
+	MinCriticality     = 1
+	MaxCriticality     = 5
+)
+
+// Services that widen the attack surface considerably when exposed, weighted 0-1
+var riskyServices = map[string]float64{
+	"telnet":        1.0,
+	"login":         1.0,
+	"shell":         1.0,
+	"exec":          1.0,
+	"ftp":           0.6,
+	"tftp":          0.6,
+	"microsoft-ds":  0.8,
+	"netbios-ssn":   0.6,
+	"ms-wbt-server": 0.8,
+	"vnc":           0.8,
+	"x11":           0.6,
+	"snmp":          0.6,
+	"mysql":         0.6,
+	"postgresql":    0.6,
+	"ms-sql-s":      0.6,
+	"oracle-tns":    0.6,
+	"redis":         0.8,
+	"mongodb":       0.8,
+	"elasticsearch": 0.6,
+	"memcached":     0.6,
+	"docker":        1.0,
+	"upnp":          0.4,
+	"http-proxy":    0.3,
+}
+
+// Severity maps a CVSS score onto the CVSS v3 qualitative rating
+func Severity(score float64) string {
+	switch {
+	case score >= 9.0:
+		return "critical"
+	case score >= 7.0:
+		return "high"
+	case score >= 4.0:
+		return "medium"
+	case score > 0:
+		return "low"
+	default:
+		return "none"
+	}
+}
+
+// findingPoints weighs CVSS by exploitability: known exploitation doubles it,
+// a public exploit adds half, and the EPSS probability adds up to one more
+func findingPoints(v models.VulnerabilityData) float64 {
+	multiplier := 1.0 + v.EPSS
+	if v.KEV {
+		multiplier += 1.0
+	}
+	if v.Exploit {
+		multiplier += 0.5
+	}
+	return v.Score * multiplier
+}
+
+func criticalityFactor(criticality int) float64 {
+	if criticality < MinCriticality || criticality > MaxCriticality {
+		criticality = DefaultCriticality
+	}
+	return 0.6 + 0.2*float64(criticality-1)
+}
+
+// HostScore rates a host 0-100 from its findings (up to 60), exposure (up to 40)
+// and the user-assigned asset criticality, which scales the total
+func HostScore(ports []models.PortData, criticality int) float64 {
+	var points []float64
+	openPorts := 0
+	serviceRisk := 0.0
+
+	for _, p := range ports {
+		if p.State != "open" {
+			continue
+		}
+		openPorts++
+		serviceRisk += riskyServices[p.ServiceName]
+
+		for _, v := range p.Vulnerabilities {
+			if !Counts(v) {
+				continue
+			}
+			points = append(points, findingPoints(v))
+		}
+	}
+
+	// The worst finding dominates, the rest add with diminishing weight
+	sort.Sort(sort.Reverse(sort.Float64Slice(points)))
+	severity := 0.0
+	for i, p := range points {
+		if i == 0 {
+			severity += p
+		} else {
+			severity += 0.1 * p
+		}
+	}
+
+	findings := 60 * (1 - math.Exp(-severity/10))
+	exposure := math.Min(float64(openPorts)*2, 20) + math.Min(serviceRisk*10, 20)
+
+	score := (findings + exposure) * criticalityFactor(criticality)
+	return round(math.Min(score, 100))
+}
+
+// NetworkScore blends the riskiest host with the average so one bad box
+// and a generally sloppy network both show up
+func NetworkScore(hosts []models.HostData) float64 {
+	if len(hosts) == 0 {
+		return 0
+	}
+
+	worst, sum := 0.0, 0.0
+	for _, h := range hosts {
+		worst = math.Max(worst, h.Risk)
+		sum += h.Risk
+	}
+	return round(0.6*worst + 0.4*sum/float64(len(hosts)))
+}
+
+// Counts reports whether a finding contributes to scores and alerts; suppressed ones don't
+func Counts(v models.VulnerabilityData) bool {
+	return v.Status == "" || v.Status == models.StatusOpen
+}
+
+func round(f float64) float64 {
+	return math.Round(f*10) / 10
+}
@@ -0,0 +1,227 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/helpers"
+	"github.com/wiktoz/sentry/models"
+)
+
+// alertRuleRequest tells omitted enabled/throttle_minutes apart from explicit zero values
+type alertRuleRequest struct {
+	models.AlertRule
+	Enabled         *bool `json:"enabled"`
+	ThrottleMinutes *int  `json:"throttle_minutes"`
+}
+
+func GetAlertRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := db.GetAlertRules(db.DB)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	helpers.WriteJSON(w, rules)
+}
+
+func CreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	var req alertRuleRequest
+	if err := helpers.ReadJSON(r.Body, &req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	rule := req.AlertRule
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+	rule.ThrottleMinutes = models.DefaultThrottleMinutes
+	if req.ThrottleMinutes != nil {
+		rule.ThrottleMinutes = *req.ThrottleMinutes
+	}
+
+	if err := validateAlertRule(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := db.CreateAlertRule(db.DB, rule)
+	if err != nil {
+		http.Error(w, "failed to create alert rule", http.StatusInternalServerError)
+		return
+	}
+
+	reloadNotifiers()
+	rule.ID = id
+	record(r, audit.AlertRuleCreated, fmt.Sprintf("alert_rule:%d", id), rule)
+	helpers.WriteJSON(w, map[string]any{"status": "created", "id": id})
+}
+
+// AlertRuleByID serves PUT and DELETE on /api/alerts/rules/{id}
+func AlertRuleByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/alerts/rules/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid alert rule ID", http.StatusBadRequest)
+		return
+	}
+
+	stored, err := db.GetAlertRule(db.DB, id)
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Alert rule not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var req alertRuleRequest
+		if err := helpers.ReadJSON(r.Body, &req); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		rule := req.AlertRule
+		rule.ID = stored.ID
+		rule.Enabled = stored.Enabled
+		if req.Enabled != nil {
+			rule.Enabled = *req.Enabled
+		}
+		rule.ThrottleMinutes = stored.ThrottleMinutes
+		if req.ThrottleMinutes != nil {
+			rule.ThrottleMinutes = *req.ThrottleMinutes
+		}
+
+		if err := validateAlertRule(&rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := db.UpdateAlertRule(db.DB, rule); err != nil {
+			http.Error(w, "failed to update alert rule", http.StatusInternalServerError)
+			return
+		}
+		reloadNotifiers()
+		// Diff the stored row so omitted lists don't show up as changes
+		if updated, err := db.GetAlertRule(db.DB, id); err == nil {
+			rule = updated
+		}
+		record(r, audit.AlertRuleUpdated, fmt.Sprintf("alert_rule:%d", id), map[string]any{"changes": audit.Diff(stored, rule)})
+		helpers.WriteJSON(w, map[string]string{"status": "updated"})
+
+	case http.MethodDelete:
+		if _, err := db.DeleteAlertRule(db.DB, id); err != nil {
+			http.Error(w, "failed to delete alert rule", http.StatusInternalServerError)
+			return
+		}
+		reloadNotifiers()
+		record(r, audit.AlertRuleDeleted, fmt.Sprintf("alert_rule:%d", id), map[string]string{"name": stored.Name})
+		helpers.WriteJSON(w, map[string]string{"status": "deleted"})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func validateAlertRule(rule *models.AlertRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	rule.Service = strings.TrimSpace(rule.Service)
+
+	switch {
+	case rule.Name == "":
+		return fmt.Errorf("name is required")
+	case rule.Trigger != models.TriggerFinding && rule.Trigger != models.TriggerNewHost && rule.Trigger != models.TriggerNewPort:
+		return fmt.Errorf("trigger must be finding, new_host or new_port")
+	case rule.MinScore < 0 || rule.MinScore > 10:
+		return fmt.Errorf("min_score must be between 0 and 10")
+	case rule.Trigger != models.TriggerFinding && (rule.MinScore > 0 || rule.KEVOnly):
+		return fmt.Errorf("min_score and kev_only only apply to finding rules")
+	case rule.Port < 0 || rule.Port > 65535:
+		return fmt.Errorf("invalid port")
+	case rule.Trigger == models.TriggerNewHost && (rule.Port != 0 || rule.Service != ""):
+		return fmt.Errorf("port and service do not apply to new_host rules")
+	case rule.ThrottleMinutes < 0:
+		return fmt.Errorf("throttle_minutes must not be negative")
+	case rule.ChannelID == 0 && len(rule.Recipients) == 0:
+		return fmt.Errorf("channel_id or recipients is required")
+	}
+
+	for i, t := range rule.Targets {
+		t = strings.TrimSpace(t)
+		rule.Targets[i] = t
+		if _, err := netip.ParseAddr(t); err == nil {
+			continue
+		}
+		if _, err := netip.ParsePrefix(t); err != nil {
+			return fmt.Errorf("invalid target %q, expected an IP address or CIDR", t)
+		}
+	}
+
+	for _, rcpt := range rule.Recipients {
+		if _, err := mail.ParseAddress(rcpt); err != nil {
+			return fmt.Errorf("invalid recipient: %s", rcpt)
+		}
+	}
+
+	if rule.ChannelID != 0 {
+		if _, err := db.GetChannel(db.DB, rule.ChannelID); err != nil {
+			return fmt.Errorf("channel %d not found", rule.ChannelID)
+		}
+	}
+	return nil
+}
+
+func GetQuietHours(w http.ResponseWriter, r *http.Request) {
+	q, err := db.GetQuietHours(db.DB)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	helpers.WriteJSON(w, q)
+}
+
+func UpdateQuietHours(w http.ResponseWriter, r *http.Request) {
+	var q models.QuietHours
+	if err := helpers.ReadJSON(r.Body, &q); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := time.Parse("15:04", q.Start); err != nil {
+		http.Error(w, "start must be HH:MM", http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse("15:04", q.End); err != nil {
+		http.Error(w, "end must be HH:MM", http.StatusBadRequest)
+		return
+	}
+	if q.Timezone != "" {
+		if _, err := time.LoadLocation(q.Timezone); err != nil {
+			http.Error(w, "unknown timezone", http.StatusBadRequest)
+			return
+		}
+	}
+
+	before, err := db.GetQuietHours(db.DB)
+	if err != nil {
+		http.Error(w, "failed to load quiet hours", http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.SaveQuietHours(db.DB, q); err != nil {
+		http.Error(w, "failed to update quiet hours", http.StatusInternalServerError)
+		return
+	}
+
+	reloadNotifiers()
+	record(r, audit.QuietHoursUpdated, "config:quiet_hours", map[string]any{"changes": audit.Diff(before, q)})
+	helpers.WriteJSON(w, map[string]string{"status": "updated"})
+}
@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/helpers"
+	"github.com/wiktoz/sentry/models"
+	"github.com/wiktoz/sentry/risk"
+)
+
+func GetAssets(w http.ResponseWriter, r *http.Request) {
+	assets, err := db.GetAssets(db.DB)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	helpers.WriteJSON(w, assets)
+}
+
+func SaveAsset(w http.ResponseWriter, r *http.Request) {
+	var asset models.Asset
+	if err := helpers.ReadJSON(r.Body, &asset); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	asset.Address = strings.TrimSpace(asset.Address)
+	if asset.Address == "" {
+		http.Error(w, "address is required", http.StatusBadRequest)
+		return
+	}
+
+	if asset.Criticality == 0 {
+		asset.Criticality = risk.DefaultCriticality
+	}
+	if asset.Criticality < risk.MinCriticality || asset.Criticality > risk.MaxCriticality {
+		http.Error(w, "criticality must be between 1 and 5", http.StatusBadRequest)
+		return
+	}
+
+	before, err := db.GetAsset(db.DB, asset.Address)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.SaveAsset(db.DB, asset); err != nil {
+		http.Error(w, "failed to save asset", http.StatusInternalServerError)
+		return
+	}
+	record(r, audit.AssetUpdated, "asset:"+asset.Address, map[string]any{"changes": audit.Diff(before, asset)})
+
+	helpers.WriteJSON(w, asset)
+}
+
+func DeleteAsset(w http.ResponseWriter, r *http.Request) {
+	address := strings.TrimPrefix(r.URL.Path, "/api/assets/")
+	if address == "" {
+		http.Error(w, "address is required", http.StatusBadRequest)
+		return
+	}
+
+	before, err := db.GetAsset(db.DB, address)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.DeleteAsset(db.DB, address); err != nil {
+		http.Error(w, "failed to delete asset", http.StatusInternalServerError)
+		return
+	}
+	if before.Address != "" {
+		record(r, audit.AssetDeleted, "asset:"+address, before)
+	}
+
+	helpers.WriteJSON(w, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/auth"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/helpers"
+	"github.com/wiktoz/sentry/models"
+)
+
+// record audits an action by the authenticated user of r
+func record(r *http.Request, action, target string, detail any) {
+	u, _ := auth.UserFrom(r.Context())
+	audit.Request(r, u, action, target, detail)
+}
+
+// GetAudit browses the audit log, newest first. With ?format=jsonl the matching
+// entries are exported oldest first as JSON lines, without the default limit.
+func GetAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := models.AuditFilter{
+		Actor:  q.Get("actor"),
+		Action: q.Get("action"),
+		Target: q.Get("target"),
+	}
+
+	for name, dst := range map[string]*string{"since": &f.Since, "until": &f.Until} {
+		if v := q.Get(name); v != "" {
+			t, err := parseExpiry(v)
+			if err != nil {
+				http.Error(w, name+" must be RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			*dst = t.UTC().Format(time.DateTime)
+		}
+	}
+
+	export := q.Get("format") == "jsonl"
+	switch q.Get("format") {
+	case "", "json", "jsonl":
+	default:
+		http.Error(w, "format must be json or jsonl", http.StatusBadRequest)
+		return
+	}
+
+	if !export {
+		f.Limit = 100
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || (!export && n > 1000) {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		f.Limit = n
+	}
+
+	if export {
+		f.Oldest = true
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="sentry-audit-%s.jsonl"`, time.Now().Format("20060102-150405")))
+
+		enc := json.NewEncoder(w)
+		if err := db.QueryAudit(db.DB, f, func(e models.AuditEntry) error { return enc.Encode(e) }); err != nil {
+			// Headers are gone once the first line is written, so a failure can only cut the export short
+			http.Error(w, "Database error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	entries := []models.AuditEntry{}
+	err := db.QueryAudit(db.DB, f, func(e models.AuditEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	helpers.WriteJSON(w, entries)
+}
@@ -0,0 +1,299 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/auth"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/helpers"
+	"github.com/wiktoz/sentry/models"
+)
+
+// otpHeader tells clients that a login failed for want of a two-factor code
+const otpHeader = "X-Sentry-OTP"
+
+type sessionResponse struct {
+	User      models.User `json:"user"`
+	CSRFToken string      `json:"csrf_token,omitempty"`
+	ExpiresAt string      `json:"expires_at,omitempty"`
+}
+
+// Login checks credentials and starts a cookie session
+func Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var c credentials
+	if err := helpers.ReadJSON(r.Body, &c); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	c.Username = strings.TrimSpace(c.Username)
+	u, err := auth.Login(audit.RemoteIP(r), c.Username, c.Password, c.Code)
+	var locked *auth.LockedError
+	switch {
+	case errors.As(err, &locked):
+		auth.TooManyRequests(w, locked.RetryAfter, err.Error())
+		return
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		audit.Request(r, models.User{}, audit.LoginFailed, "user:"+c.Username, map[string]string{"method": "password", "reason": err.Error()})
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, auth.ErrTOTPRequired):
+		w.Header().Set(otpHeader, "required")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, auth.ErrInvalidCode):
+		audit.Request(r, models.User{}, audit.LoginFailed, "user:"+c.Username, map[string]string{"method": "totp", "reason": err.Error()})
+		w.Header().Set(otpHeader, "required")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, "login failed", http.StatusInternalServerError)
+		return
+	}
+
+	s, err := auth.StartSession(w, r, u)
+	if err != nil {
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+	method := "password"
+	if u.TOTPEnabled {
+		method = "password+totp"
+	}
+	audit.Request(r, u, audit.Login, fmt.Sprintf("session:%d", s.ID), map[string]string{"method": method})
+
+	helpers.WriteJSON(w, sessionResponse{User: u, CSRFToken: s.CSRFToken, ExpiresAt: s.ExpiresAt})
+}
+
+func Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s, ok := auth.SessionFrom(r.Context())
+	if !ok {
+		http.Error(w, "not signed in with a session", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.DeleteSession(db.DB, s.ID); err != nil {
+		http.Error(w, "failed to end session", http.StatusInternalServerError)
+		return
+	}
+	auth.ClearSessionCookie(w, r)
+	record(r, audit.Logout, fmt.Sprintf("session:%d", s.ID), nil)
+	helpers.WriteJSON(w, map[string]string{"status": "signed out"})
+}
+
+// CurrentSession returns the signed-in user, and the CSRF token when authenticated by cookie
+func CurrentSession(w http.ResponseWriter, r *http.Request) {
+	u, _ := auth.UserFrom(r.Context())
+
+	resp := sessionResponse{User: u}
+	if s, ok := auth.SessionFrom(r.Context()); ok {
+		resp.CSRFToken = s.CSRFToken
+		resp.ExpiresAt = s.ExpiresAt
+	}
+	helpers.WriteJSON(w, resp)
+}
+
+// GetSessions lists the caller's active sessions; admins see everyone's
+func GetSessions(w http.ResponseWriter, r *http.Request) {
+	u, _ := auth.UserFrom(r.Context())
+
+	owner := u.ID
+	if auth.HasRole(u, models.RoleAdmin) {
+		owner = 0
+	}
+
+	sessions, err := db.GetSessions(db.DB, owner, time.Now())
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if current, ok := auth.SessionFrom(r.Context()); ok {
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == current.ID
+		}
+	}
+	helpers.WriteJSON(w, sessions)
+}
+
+// DeleteSession revokes one of the caller's sessions; admins can revoke any
+func DeleteSession(w http.ResponseWriter, r *http.Request) {
+	u, _ := auth.UserFrom(r.Context())
+
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/auth/sessions/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	s, err := db.GetSession(db.DB, id)
+	if err == sql.ErrNoRows || (err == nil && s.UserID != u.ID && !auth.HasRole(u, models.RoleAdmin)) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := db.DeleteSession(db.DB, id); err != nil {
+		http.Error(w, "failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	if current, ok := auth.SessionFrom(r.Context()); ok && current.ID == id {
+		auth.ClearSessionCookie(w, r)
+	}
+	record(r, audit.SessionEnded, fmt.Sprintf("session:%d", id), map[string]string{"owner": s.Username})
+	helpers.WriteJSON(w, map[string]string{"status": "revoked"})
+}
+
+// EnrollTOTP creates a pending TOTP secret; it takes effect once EnableTOTP confirms a code
+func EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	u, _ := auth.UserFrom(r.Context())
+
+	var c credentials
+	if err := helpers.ReadJSON(r.Body, &c); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !auth.CheckPassword(u.PasswordHash, c.Password) {
+		http.Error(w, "invalid password", http.StatusForbidden)
+		return
+	}
+	if u.TOTPEnabled {
+		http.Error(w, "two-factor is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	if err := db.SetTOTPSecret(db.DB, u.ID, secret); err != nil {
+		http.Error(w, "failed to save secret", http.StatusInternalServerError)
+		return
+	}
+
+	helpers.WriteJSON(w, map[string]string{
+		"secret":      secret,
+		"otpauth_url": auth.TOTPURL(u.Username, secret),
+	})
+}
+
+// EnableTOTP confirms the pending secret with a code and returns fresh recovery codes
+func EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	u, _ := auth.UserFrom(r.Context())
+
+	var c credentials
+	if err := helpers.ReadJSON(r.Body, &c); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	secret, enabled, _, err := db.GetTOTP(db.DB, u.ID)
+	switch {
+	case err != nil:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	case enabled:
+		http.Error(w, "two-factor is already enabled", http.StatusConflict)
+		return
+	case secret == "":
+		http.Error(w, "start enrollment first", http.StatusBadRequest)
+		return
+	}
+
+	step, ok := auth.ConfirmTOTP(secret, c.Code)
+	if !ok {
+		http.Error(w, auth.ErrInvalidCode.Error(), http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		http.Error(w, "failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	if err := db.EnableTOTP(db.DB, u.ID, step, hashes); err != nil {
+		http.Error(w, "failed to enable two-factor", http.StatusInternalServerError)
+		return
+	}
+
+	// Sessions opened with the password alone should not outlive this
+	keep := 0
+	if s, ok := auth.SessionFrom(r.Context()); ok {
+		keep = s.ID
+	}
+	if err := db.DeleteUserSessions(db.DB, u.ID, keep); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	record(r, audit.TOTPEnabled, "user:"+u.Username, nil)
+	helpers.WriteJSON(w, map[string]any{"status": "enabled", "recovery_codes": codes})
+}
+
+// DisableTOTP turns two-factor off after checking the password and a current code
+func DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	u, _ := auth.UserFrom(r.Context())
+
+	var c credentials
+	if err := helpers.ReadJSON(r.Body, &c); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !u.TOTPEnabled {
+		http.Error(w, "two-factor is not enabled", http.StatusConflict)
+		return
+	}
+	if !auth.CheckPassword(u.PasswordHash, c.Password) {
+		http.Error(w, "invalid password", http.StatusForbidden)
+		return
+	}
+
+	err := auth.VerifySecondFactor(u, c.Code)
+	switch {
+	case errors.Is(err, auth.ErrTOTPRequired), errors.Is(err, auth.ErrInvalidCode):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.DisableTOTP(db.DB, u.ID); err != nil {
+		http.Error(w, "failed to disable two-factor", http.StatusInternalServerError)
+		return
+	}
+	record(r, audit.TOTPDisabled, "user:"+u.Username, nil)
+	helpers.WriteJSON(w, map[string]string{"status": "disabled"})
+}
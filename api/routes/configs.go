@@ -3,6 +3,7 @@ package routes
 import (
 	"net/http"
 
+	"github.com/wiktoz/sentry/audit"
 	"github.com/wiktoz/sentry/db"
 	"github.com/wiktoz/sentry/helpers"
 	"github.com/wiktoz/sentry/models"
@@ -29,10 +30,18 @@ func UpdateConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before, err := db.GetConfig(db.DB)
+	if err != nil {
+		http.Error(w, "failed to load config", http.StatusInternalServerError)
+		return
+	}
+
 	if err := db.SaveConfig(db.DB, cfg); err != nil {
 		http.Error(w, "failed to update config", http.StatusInternalServerError)
 		return
 	}
 
+	record(r, audit.ConfigUpdated, "config", map[string]any{"changes": audit.Diff(before, cfg)})
+
 	helpers.WriteJSON(w, map[string]string{"status": "updated"})
 }
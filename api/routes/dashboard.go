@@ -0,0 +1,174 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/helpers"
+	"github.com/wiktoz/sentry/models"
+	"github.com/wiktoz/sentry/risk"
+)
+
+const (
+	defaultTrendScans = 10
+	maxTrendScans     = 100
+	topHostsLimit     = 10
+)
+
+// GetDashboard summarises network risk for the latest scan and the trend over the last N scans (?scans=N)
+func GetDashboard(w http.ResponseWriter, r *http.Request) {
+	n := defaultTrendScans
+	if s := r.URL.Query().Get("scans"); s != "" {
+		var err error
+		n, err = strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid scans count", http.StatusBadRequest)
+			return
+		}
+		n = min(n, maxTrendScans)
+	}
+
+	ids, err := db.RecentScanIDs(db.DB, n)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	dashboard := models.Dashboard{
+		TopHosts:        []models.HostRisk{},
+		FindingSeverity: map[string]int{"critical": 0, "high": 0, "medium": 0, "low": 0},
+		HostSeverity:    map[string]int{"critical": 0, "high": 0, "medium": 0, "low": 0, "none": 0},
+		Trend:           []models.TrendPoint{},
+	}
+
+	var scans []models.ScanData
+	for _, id := range ids {
+		scan, err := db.GetScanData(db.DB, id)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		scans = append(scans, scan)
+	}
+
+	if len(scans) == 0 {
+		helpers.WriteJSON(w, dashboard)
+		return
+	}
+
+	// Trend oldest to newest
+	for i := len(scans) - 1; i >= 0; i-- {
+		scan := scans[i]
+		dashboard.Trend = append(dashboard.Trend, models.TrendPoint{
+			ScanID:   scan.ID,
+			Date:     scan.Date,
+			Risk:     scan.Risk,
+			Hosts:    len(scan.Hosts),
+			Findings: len(scanFingerprints(scan)),
+		})
+	}
+
+	latest := scans[0]
+	dashboard.ScanID = latest.ID
+	dashboard.Date = latest.Date
+	dashboard.Risk = latest.Risk
+
+	for _, host := range latest.Hosts {
+		hr := hostRisk(host)
+		dashboard.TopHosts = append(dashboard.TopHosts, hr)
+		dashboard.HostSeverity[risk.Severity(hr.MaxScore)]++
+
+		for _, p := range host.Ports {
+			for _, v := range p.Vulnerabilities {
+				if !risk.Counts(v) {
+					continue
+				}
+				if sev := risk.Severity(v.Score); sev != "none" {
+					dashboard.FindingSeverity[sev]++
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(dashboard.TopHosts, func(i, j int) bool {
+		return dashboard.TopHosts[i].Risk > dashboard.TopHosts[j].Risk
+	})
+	if len(dashboard.TopHosts) > topHostsLimit {
+		dashboard.TopHosts = dashboard.TopHosts[:topHostsLimit]
+	}
+
+	if len(scans) > 1 {
+		previous := scans[1]
+
+		current, before := scanFingerprints(latest), scanFingerprints(previous)
+		for fp := range current {
+			if !before[fp] {
+				dashboard.NewFindings++
+			}
+		}
+		for fp := range before {
+			if !current[fp] {
+				dashboard.ResolvedFindings++
+			}
+		}
+
+		known := map[string]bool{}
+		for _, h := range previous.Hosts {
+			known[h.Address] = true
+		}
+		for _, h := range latest.Hosts {
+			if !known[h.Address] {
+				dashboard.NewHosts++
+			}
+		}
+	}
+
+	helpers.WriteJSON(w, dashboard)
+}
+
+func hostRisk(host models.HostData) models.HostRisk {
+	hr := models.HostRisk{
+		Address:     host.Address,
+		Criticality: host.Criticality,
+		Risk:        host.Risk,
+	}
+
+	for _, p := range host.Ports {
+		if p.State == "open" {
+			hr.OpenPorts++
+		}
+		for _, v := range p.Vulnerabilities {
+			if !risk.Counts(v) {
+				continue
+			}
+			hr.Findings++
+			hr.MaxScore = max(hr.MaxScore, v.Score)
+			if v.KEV {
+				hr.KEV++
+			}
+			if v.Exploit {
+				hr.Exploits++
+			}
+		}
+	}
+	return hr
+}
+
+// scanFingerprints identifies each finding of a scan by host, port and vulnerability ID
+func scanFingerprints(scan models.ScanData) map[string]bool {
+	fps := map[string]bool{}
+	for _, h := range scan.Hosts {
+		for _, p := range h.Ports {
+			for _, v := range p.Vulnerabilities {
+				if !risk.Counts(v) {
+					continue
+				}
+				fps[fmt.Sprintf("%s|%d/%s|%s", h.Address, p.PortNum, p.Protocol, v.ID)] = true
+			}
+		}
+	}
+	return fps
+}
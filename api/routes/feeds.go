@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/helpers"
+	"github.com/wiktoz/sentry/scripts"
+)
+
+func GetFeeds(w http.ResponseWriter, r *http.Request) {
+	status, err := db.GetFeedStatus(db.DB)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if last := scripts.LastFeedImport(); !last.IsZero() {
+		status.LastImport = last.Format(time.RFC3339)
+	}
+
+	helpers.WriteJSON(w, status)
+}
+
+func RefreshFeeds(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := scripts.RefreshFeeds(scripts.FeedsDir(), true); err != nil {
+		http.Error(w, "feed import failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	GetFeeds(w, r)
+}
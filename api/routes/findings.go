@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"database/sql"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/helpers"
+	"github.com/wiktoz/sentry/models"
+)
+
+// GetFindings lists the findings of a scan (latest by default).
+// Query params: scan_id, exploit=true (public exploit only), kev=true, min_score, status, group=cve
+func GetFindings(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	scanID, err := db.LatestScanID(db.DB)
+	if idStr := q.Get("scan_id"); idStr != "" {
+		scanID, err = strconv.Atoi(idStr)
+		if err != nil || scanID <= 0 {
+			http.Error(w, "Invalid scan ID", http.StatusBadRequest)
+			return
+		}
+	}
+	switch {
+	case err == sql.ErrNoRows:
+		helpers.WriteJSON(w, []models.Finding{})
+		return
+	case err != nil:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	minScore := 0.0
+	if s := q.Get("min_score"); s != "" {
+		minScore, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			http.Error(w, "Invalid min_score", http.StatusBadRequest)
+			return
+		}
+	}
+	exploitOnly := q.Get("exploit") == "true"
+	kevOnly := q.Get("kev") == "true"
+	status := q.Get("status")
+
+	findings, err := db.GetFindings(db.DB, scanID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	findings = slices.DeleteFunc(findings, func(f models.Finding) bool {
+		return (exploitOnly && !f.Exploit) || (kevOnly && !f.KEV) || f.Score < minScore ||
+			(status != "" && f.Status != status)
+	})
+
+	if q.Get("group") == "cve" {
+		helpers.WriteJSON(w, groupByCVE(findings))
+		return
+	}
+
+	if findings == nil {
+		findings = []models.Finding{}
+	}
+	helpers.WriteJSON(w, findings)
+}
+
+// groupByCVE merges findings that share a CVE (directly or through an alias).
+// Entries without any CVE reference stay on their own.
+func groupByCVE(findings []models.Finding) []models.CVEGroup {
+	groups := []models.CVEGroup{}
+	index := map[string]int{}
+
+	for _, f := range findings {
+		key := f.CVE
+		if key == "" {
+			key = f.ID
+		}
+
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, models.CVEGroup{CVE: f.CVE})
+		}
+		g := &groups[i]
+
+		g.Score = max(g.Score, f.Score)
+		g.Exploit = g.Exploit || f.Exploit
+		if f.KEV || f.EPSS > g.EPSS {
+			g.Enrichment = f.Enrichment
+		}
+
+		if !slices.Contains(g.IDs, f.ID) {
+			g.IDs = append(g.IDs, f.ID)
+		}
+
+		affected := models.AffectedPort{Address: f.Address, PortNum: f.PortNum, Protocol: f.Protocol}
+		if !slices.Contains(g.Affected, affected) {
+			g.Affected = append(g.Affected, affected)
+		}
+	}
+
+	return groups
+}
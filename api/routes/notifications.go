@@ -0,0 +1,183 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/helpers"
+	"github.com/wiktoz/sentry/models"
+	"github.com/wiktoz/sentry/notify"
+)
+
+func GetSMTPSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := db.GetSMTPSettings(db.DB)
+	if err != nil {
+		http.Error(w, "failed to load SMTP settings", http.StatusInternalServerError)
+		return
+	}
+
+	settings.PasswordSet = settings.Password != ""
+	settings.Password = ""
+	helpers.WriteJSON(w, settings)
+}
+
+func UpdateSMTPSettings(w http.ResponseWriter, r *http.Request) {
+	var settings models.SMTPSettings
+	if err := helpers.ReadJSON(r.Body, &settings); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	settings.Host = strings.TrimSpace(settings.Host)
+
+	switch settings.Security {
+	case models.SMTPSecurityTLS, models.SMTPSecurityStartTLS, models.SMTPSecurityNone:
+	default:
+		http.Error(w, "security must be tls, starttls or none", http.StatusBadRequest)
+		return
+	}
+
+	switch settings.Auth {
+	case models.SMTPAuthPlain, models.SMTPAuthLogin, models.SMTPAuthCRAMMD5, models.SMTPAuthNone:
+	default:
+		http.Error(w, "auth must be plain, login, cram-md5 or none", http.StatusBadRequest)
+		return
+	}
+
+	if settings.Port <= 0 || settings.Port > 65535 {
+		http.Error(w, "invalid port", http.StatusBadRequest)
+		return
+	}
+
+	if settings.From != "" {
+		if _, err := mail.ParseAddress(settings.From); err != nil {
+			http.Error(w, "invalid from address", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if settings.UIURL != "" {
+		if u, err := url.Parse(settings.UIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "invalid ui_url", http.StatusBadRequest)
+			return
+		}
+	}
+
+	for _, rcpt := range settings.Recipients {
+		if _, err := mail.ParseAddress(rcpt); err != nil {
+			http.Error(w, "invalid recipient: "+rcpt, http.StatusBadRequest)
+			return
+		}
+	}
+
+	current, err := db.GetSMTPSettings(db.DB)
+	if err != nil {
+		http.Error(w, "failed to load SMTP settings", http.StatusInternalServerError)
+		return
+	}
+
+	// Empty password keeps the stored one so the redacted GET response can be sent back as-is
+	if settings.Password == "" {
+		settings.Password = current.Password
+	}
+
+	if err := db.SaveSMTPSettings(db.DB, settings); err != nil {
+		http.Error(w, "failed to update SMTP settings", http.StatusInternalServerError)
+		return
+	}
+	reloadNotifiers() // email notifiers only run while SMTP is configured
+
+	// The audit log only notes that the password changed, never its value
+	before, after := current, settings
+	before.Password, after.Password = "", ""
+	before.PasswordSet, after.PasswordSet = false, false
+	changes := map[string]any{}
+	for field, c := range audit.Diff(before, after) {
+		changes[field] = c
+	}
+	if settings.Password != current.Password {
+		changes["password"] = "changed"
+	}
+	record(r, audit.SMTPUpdated, "config:smtp", map[string]any{"changes": changes})
+
+	helpers.WriteJSON(w, map[string]string{"status": "updated"})
+}
+
+func TestNotification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m := notify.Mail{
+		Subject: "Sentry test notification",
+		HTML:    `<html><body style="font-family: sans-serif;"><p>This is a test notification from Sentry.</p></body></html>`,
+		Text:    "This is a test notification from Sentry.",
+	}
+	if err := notify.SendEmail(m); err != nil {
+		http.Error(w, "failed to send test email: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	helpers.WriteJSON(w, map[string]string{"status": "sent"})
+}
+
+func GetNotifications(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.DeliveryPending, models.DeliverySent, models.DeliveryFailed, models.DeliveryDead:
+	default:
+		http.Error(w, "status must be pending, sent, failed or dead", http.StatusBadRequest)
+		return
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	list, err := db.GetNotifications(db.DB, status, limit)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	helpers.WriteJSON(w, list)
+}
+
+// ResendNotification serves POST /api/notifications/{id}/resend
+func ResendNotification(w http.ResponseWriter, r *http.Request) {
+	idStr, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/notifications/"), "/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 || action != "resend" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err = notify.Resend(id)
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Notification not found", http.StatusNotFound)
+	case errors.Is(err, notify.ErrTargetGone):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, "failed to resend notification", http.StatusInternalServerError)
+	default:
+		helpers.WriteJSON(w, map[string]string{"status": "queued"})
+	}
+}
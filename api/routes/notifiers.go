@@ -0,0 +1,197 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/helpers"
+	"github.com/wiktoz/sentry/models"
+	"github.com/wiktoz/sentry/notify"
+)
+
+func GetNotifiers(w http.ResponseWriter, r *http.Request) {
+	channels, err := db.GetChannels(db.DB)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range channels {
+		channels[i] = notify.RedactConfig(channels[i])
+	}
+
+	helpers.WriteJSON(w, map[string]any{
+		"types":     notify.ChannelTypes(),
+		"events":    notify.EventTypes,
+		"notifiers": channels,
+	})
+}
+
+func CreateNotifier(w http.ResponseWriter, r *http.Request) {
+	var ch models.Channel
+	if err := helpers.ReadJSON(r.Body, &ch); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	ch.Name = strings.TrimSpace(ch.Name)
+	if ch.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if len(ch.Config) == 0 {
+		ch.Config = json.RawMessage("{}")
+	}
+
+	if err := notify.Validate(ch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := db.CreateChannel(db.DB, ch)
+	if err != nil {
+		http.Error(w, "failed to create notifier", http.StatusInternalServerError)
+		return
+	}
+
+	reloadNotifiers()
+	record(r, audit.NotifierCreated, fmt.Sprintf("notifier:%d", id), map[string]any{"name": ch.Name, "type": ch.Type, "events": ch.Events})
+	helpers.WriteJSON(w, map[string]any{"status": "created", "id": id})
+}
+
+// NotifierByID serves /api/notifiers/{id} (PUT, DELETE) and /api/notifiers/{id}/test (POST)
+func NotifierByID(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/notifiers/")
+	idStr, action, _ := strings.Cut(rest, "/")
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid notifier ID", http.StatusBadRequest)
+		return
+	}
+
+	stored, err := db.GetChannel(db.DB, id)
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Notifier not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case action == "test" && r.Method == http.MethodPost:
+		testNotifier(w, stored)
+	case action == "" && r.Method == http.MethodPut:
+		updateNotifier(w, r, stored)
+	case action == "" && r.Method == http.MethodDelete:
+		if _, err := db.DeleteChannel(db.DB, id); err != nil {
+			http.Error(w, "failed to delete notifier", http.StatusInternalServerError)
+			return
+		}
+		reloadNotifiers()
+		record(r, audit.NotifierDeleted, fmt.Sprintf("notifier:%d", id), map[string]string{"name": stored.Name, "type": stored.Type})
+		helpers.WriteJSON(w, map[string]string{"status": "deleted"})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func updateNotifier(w http.ResponseWriter, r *http.Request, stored models.Channel) {
+	var ch models.Channel
+	if err := helpers.ReadJSON(r.Body, &ch); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	ch.ID = stored.ID
+	ch.Type = stored.Type
+	ch.Name = strings.TrimSpace(ch.Name)
+	if ch.Name == "" {
+		ch.Name = stored.Name
+	}
+	if len(ch.Config) == 0 {
+		ch.Config = stored.Config
+	}
+	ch = notify.KeepSecrets(ch, stored)
+
+	if err := notify.Validate(ch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.UpdateChannel(db.DB, ch); err != nil {
+		http.Error(w, "failed to update notifier", http.StatusInternalServerError)
+		return
+	}
+
+	reloadNotifiers()
+	// Diff the stored row so omitted lists don't show up as changes
+	if updated, err := db.GetChannel(db.DB, ch.ID); err == nil {
+		ch = updated
+	}
+	record(r, audit.NotifierUpdated, fmt.Sprintf("notifier:%d", ch.ID), map[string]any{"changes": channelChanges(stored, ch)})
+	helpers.WriteJSON(w, map[string]string{"status": "updated"})
+}
+
+// channelChanges diffs two versions of a channel for the audit log. Config fields
+// are compared one by one and secret fields only noted as changed.
+func channelChanges(before, after models.Channel) map[string]any {
+	changes := map[string]any{}
+	for field, c := range audit.Diff(before, after) {
+		if field != "config" {
+			changes[field] = c
+		}
+	}
+
+	config := map[string]any{}
+	redacted := audit.Diff(notify.RedactConfig(before).Config, notify.RedactConfig(after).Config)
+	for field := range audit.Diff(before.Config, after.Config) {
+		if c, ok := redacted[field]; ok {
+			config[field] = c
+		} else {
+			config[field] = "changed"
+		}
+	}
+	if len(config) > 0 {
+		changes["config"] = config
+	}
+	return changes
+}
+
+func testNotifier(w http.ResponseWriter, ch models.Channel) {
+	n, err := notify.Build(ch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ev := notify.NewEvent(notify.EventScanFinished, 0)
+	ev.Message = "This is a test notification from Sentry."
+
+	if err := n.Notify(ctx, ev); err != nil {
+		http.Error(w, "test notification failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	helpers.WriteJSON(w, map[string]string{"status": "sent"})
+}
+
+func reloadNotifiers() {
+	if err := notify.Reload(); err != nil {
+		log.Printf("Failed to reload notifiers: %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/auth"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/helpers"
+	"github.com/wiktoz/sentry/models"
+)
+
+// stateCookie ties the provider's redirect back to the browser that started the login
+const stateCookie = "sentry_oidc_state"
+
+// OIDCInfo tells the login page whether to offer single sign-on
+func OIDCInfo(w http.ResponseWriter, r *http.Request) {
+	s, err := db.GetOIDCSettings(db.DB)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	helpers.WriteJSON(w, map[string]any{"enabled": s.Enabled, "name": s.Name})
+}
+
+// OIDCLogin redirects the browser to the identity provider
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	s, err := db.GetOIDCSettings(db.DB)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, state, err := auth.OIDCAuthURL(r.Context(), s)
+	switch {
+	case errors.Is(err, auth.ErrOIDCDisabled):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, auth.ErrTooManyPending):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		log.Printf("SSO login failed: %v", err)
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// Lax, not Strict: the provider's redirect back is a cross-site navigation
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc/callback",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback finishes the login and sends the browser back to the UI,
+// with ?sso_error= when it failed
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	fail := func(msg string) {
+		http.Redirect(w, r, "/?sso_error="+url.QueryEscape(msg), http.StatusFound)
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/api/auth/oidc/callback", MaxAge: -1})
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		fail(strings.TrimSpace(e + " " + q.Get("error_description")))
+		return
+	}
+
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil || q.Get("state") == "" || cookie.Value != q.Get("state") {
+		fail(auth.ErrOIDCState.Error())
+		return
+	}
+
+	s, err := db.GetOIDCSettings(db.DB)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	u, err := auth.OIDCCallback(r.Context(), s, q.Get("state"), q.Get("code"))
+	if err != nil {
+		audit.Request(r, models.User{}, audit.LoginFailed, "", map[string]string{"method": "sso", "reason": err.Error()})
+	}
+	switch {
+	case errors.Is(err, auth.ErrOIDCDisabled), errors.Is(err, auth.ErrOIDCState),
+		errors.Is(err, auth.ErrNoRole), errors.Is(err, auth.ErrUsernameTaken):
+		fail(err.Error())
+		return
+	case err != nil:
+		log.Printf("SSO callback failed: %v", err)
+		fail("single sign-on failed")
+		return
+	}
+
+	session, err := auth.StartSession(w, r, u)
+	if err != nil {
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+	audit.Request(r, u, audit.Login, fmt.Sprintf("session:%d", session.ID), map[string]string{"method": "sso"})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func GetOIDCSettings(w http.ResponseWriter, r *http.Request) {
+	s, err := db.GetOIDCSettings(db.DB)
+	if err != nil {
+		http.Error(w, "failed to load SSO settings", http.StatusInternalServerError)
+		return
+	}
+
+	s.ClientSecretSet = s.ClientSecret != ""
+	s.ClientSecret = ""
+	helpers.WriteJSON(w, s)
+}
+
+func UpdateOIDCSettings(w http.ResponseWriter, r *http.Request) {
+	var s models.OIDCSettings
+	if err := helpers.ReadJSON(r.Body, &s); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	s.Name = strings.TrimSpace(s.Name)
+	s.Issuer = strings.TrimSuffix(strings.TrimSpace(s.Issuer), "/")
+	s.ClientID = strings.TrimSpace(s.ClientID)
+	if s.Name == "" {
+		s.Name = "SSO"
+	}
+	if len(s.Scopes) == 0 {
+		s.Scopes = []string{"openid", "profile", "email"}
+	}
+	if s.UsernameClaim == "" {
+		s.UsernameClaim = "preferred_username"
+	}
+	if s.RoleClaim == "" {
+		s.RoleClaim = "groups"
+	}
+	if s.RoleMappings == nil {
+		s.RoleMappings = map[string]string{}
+	}
+
+	if s.DefaultRole != "" && !auth.ValidRole(s.DefaultRole) {
+		http.Error(w, "default_role must be empty, viewer, operator or admin", http.StatusBadRequest)
+		return
+	}
+	for value, role := range s.RoleMappings {
+		if !auth.ValidRole(role) {
+			http.Error(w, "invalid role for "+value+": must be viewer, operator or admin", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if s.Enabled {
+		if err := auth.CheckIssuer(s.Issuer); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.ClientID == "" {
+			http.Error(w, "client_id is required", http.StatusBadRequest)
+			return
+		}
+		if u, err := url.Parse(s.RedirectURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "invalid redirect_url", http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(s.Scopes, "openid") {
+			http.Error(w, "scopes must include openid", http.StatusBadRequest)
+			return
+		}
+		if err := auth.CheckProvider(r.Context(), s.Issuer); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	current, err := db.GetOIDCSettings(db.DB)
+	if err != nil {
+		http.Error(w, "failed to load SSO settings", http.StatusInternalServerError)
+		return
+	}
+
+	// Empty secret keeps the stored one so the redacted GET response can be sent back as-is
+	if s.ClientSecret == "" {
+		s.ClientSecret = current.ClientSecret
+	}
+
+	if err := db.SaveOIDCSettings(db.DB, s); err != nil {
+		http.Error(w, "failed to update SSO settings", http.StatusInternalServerError)
+		return
+	}
+
+	// The audit log only notes that the secret changed, never its value
+	before, after := current, s
+	before.ClientSecret, after.ClientSecret = "", ""
+	before.ClientSecretSet, after.ClientSecretSet = false, false
+	changes := map[string]any{}
+	for field, c := range audit.Diff(before, after) {
+		changes[field] = c
+	}
+	if s.ClientSecret != current.ClientSecret {
+		changes["client_secret"] = "changed"
+	}
+	record(r, audit.SSOUpdated, "config:sso", map[string]any{"changes": changes})
+	helpers.WriteJSON(w, map[string]string{"status": "updated"})
+}
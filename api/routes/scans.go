@@ -8,7 +8,9 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/wiktoz/sentry/audit"
 	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/health"
 	"github.com/wiktoz/sentry/helpers"
 	"github.com/wiktoz/sentry/models"
 	"github.com/wiktoz/sentry/scripts"
@@ -23,7 +25,7 @@ func GetScanById(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	scanData, err := getScanData(scanID)
+	scanData, err := db.GetScanData(db.DB, scanID)
 	switch {
 	case err == sql.ErrNoRows:
 		http.Error(w, "Scan not found", http.StatusNotFound)
@@ -53,7 +55,7 @@ func GetScans(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		scanData, err := getScanData(scanID)
+		scanData, err := db.GetScanData(db.DB, scanID)
 		if err != nil {
 			// Log error and skip problematic scans instead of failing the whole response
 			log.Printf("error loading scan ID %d: %v", scanID, err)
@@ -72,16 +74,8 @@ func GetScans(w http.ResponseWriter, r *http.Request) {
 }
 
 func RunScan(w http.ResponseWriter, r *http.Request) {
-	// Insert new scan record with current timestamp
-	res, err := db.DB.Exec("INSERT INTO scans (created_at) VALUES (datetime('now'))")
-	if err != nil {
-		http.Error(w, "failed to create scan", http.StatusInternalServerError)
-		return
-	}
-
-	scanID, err := res.LastInsertId()
-	if err != nil {
-		http.Error(w, "failed to retrieve scan ID", http.StatusInternalServerError)
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -97,98 +91,35 @@ func RunScan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Start the scan in background, passing scanID as int
-	go scripts.RunFullScan(int(scanID), targets)
-
-	// Return the scan ID immediately as JSON
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(fmt.Sprintf(`{"status":"scan started", "scan_id": %d}`, scanID)))
-}
-
-func getScanData(scanID int) (models.ScanData, error) {
-	var scan models.ScanData
-
-	// Fetch scan metadata
-	err := db.DB.QueryRow(`SELECT id, created_at FROM scans WHERE id = ?`, scanID).Scan(&scan.ID, &scan.Date)
-	if err != nil {
-		return models.ScanData{}, err
+	// Refuse up front rather than record a scan that is bound to fail
+	if err := health.ScanPreflight(r.Context()); err != nil {
+		http.Error(w, "cannot scan: "+err.Error(), http.StatusServiceUnavailable)
+		return
 	}
 
-	// Fetch hosts for the scan
-	hostRows, err := db.DB.Query(`SELECT id, address FROM hosts WHERE scan_id = ?`, scanID)
+	scanCtx, done, err := scripts.Track()
 	if err != nil {
-		return models.ScanData{}, err
-	}
-	defer hostRows.Close()
-
-	for hostRows.Next() {
-		var host models.HostData
-		var hostID int
-
-		if err := hostRows.Scan(&hostID, &host.Address); err != nil {
-			return models.ScanData{}, err
-		}
-
-		ports, err := fetchPortsWithVulns(hostID)
-		if err != nil {
-			return models.ScanData{}, err
-		}
-
-		host.Ports = ports
-		scan.Hosts = append(scan.Hosts, host)
-	}
-
-	if err := hostRows.Err(); err != nil {
-		return models.ScanData{}, err
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
 	}
 
-	return scan, nil
-}
-
-func fetchPortsWithVulns(hostID int) ([]models.PortData, error) {
-	portRows, err := db.DB.Query(`
-		SELECT id, port_id, service_name, protocol, state 
-		FROM ports 
-		WHERE host_id = ?`, hostID)
+	// Insert new scan record with current timestamp
+	scanID, err := db.CreateScan(db.DB)
 	if err != nil {
-		return nil, err
+		done()
+		http.Error(w, "failed to create scan", http.StatusInternalServerError)
+		return
 	}
-	defer portRows.Close()
 
-	var ports []models.PortData
+	record(r, audit.ScanStarted, fmt.Sprintf("scan:%d", scanID), map[string]string{"targets": targets, "trigger": "manual"})
 
-	for portRows.Next() {
-		var p models.PortData
-		var portID int
+	// Start the scan in background
+	go func() {
+		defer done()
+		scripts.RunFullScan(scanCtx, scanID, targets)
+	}()
 
-		if err := portRows.Scan(&portID, &p.PortNum, &p.ServiceName, &p.Protocol, &p.State); err != nil {
-			return nil, err
-		}
-
-		vulnRows, err := db.DB.Query(`
-			SELECT vuln_id, description, score, url 
-			FROM vulnerabilities 
-			WHERE port_id = ?`, portID)
-		if err != nil {
-			return nil, err
-		}
-
-		for vulnRows.Next() {
-			var v models.VulnerabilityData
-			if err := vulnRows.Scan(&v.CVE, &v.Description, &v.Score, &v.URL); err != nil {
-				vulnRows.Close()
-				return nil, err
-			}
-			p.Vulnerabilities = append(p.Vulnerabilities, v)
-		}
-		vulnRows.Close()
-
-		ports = append(ports, p)
-	}
-
-	if err := portRows.Err(); err != nil {
-		return nil, err
-	}
-
-	return ports, nil
+	// Return the scan ID immediately as JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(fmt.Sprintf(`{"status":"scan started", "scan_id": %d}`, scanID)))
 }
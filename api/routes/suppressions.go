@@ -0,0 +1,108 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/helpers"
+	"github.com/wiktoz/sentry/models"
+)
+
+func GetSuppressions(w http.ResponseWriter, r *http.Request) {
+	sups, err := db.GetSuppressions(db.DB)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	helpers.WriteJSON(w, sups)
+}
+
+func CreateSuppression(w http.ResponseWriter, r *http.Request) {
+	var s models.Suppression
+	if err := helpers.ReadJSON(r.Body, &s); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	s.Address = strings.TrimSpace(s.Address)
+	s.VulnID = strings.TrimSpace(s.VulnID)
+	s.Protocol = strings.ToLower(strings.TrimSpace(s.Protocol))
+
+	switch {
+	case s.VulnID == "":
+		http.Error(w, "vuln_id is required", http.StatusBadRequest)
+		return
+	case s.Status != models.StatusFalsePositive && s.Status != models.StatusAcceptedRisk:
+		http.Error(w, "status must be false_positive or accepted_risk", http.StatusBadRequest)
+		return
+	case strings.TrimSpace(s.Justification) == "":
+		http.Error(w, "justification is required", http.StatusBadRequest)
+		return
+	case strings.TrimSpace(s.Owner) == "":
+		http.Error(w, "owner is required", http.StatusBadRequest)
+		return
+	case s.Address == "" && (s.Port != 0 || s.Protocol != ""):
+		http.Error(w, "port and protocol require an address", http.StatusBadRequest)
+		return
+	case s.Port < 0 || s.Port > 65535:
+		http.Error(w, "invalid port", http.StatusBadRequest)
+		return
+	}
+
+	if s.ExpiresAt != "" {
+		expires, err := parseExpiry(s.ExpiresAt)
+		if err != nil {
+			http.Error(w, "expires_at must be RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		if !expires.After(time.Now()) {
+			http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
+		// Same layout as SQLite datetime() so expiry can be compared in queries
+		s.ExpiresAt = expires.UTC().Format(time.DateTime)
+	}
+
+	id, err := db.CreateSuppression(db.DB, s)
+	if err != nil {
+		http.Error(w, "failed to create suppression", http.StatusInternalServerError)
+		return
+	}
+
+	s.ID = id
+	record(r, audit.SuppressionCreated, fmt.Sprintf("suppression:%d", id), s)
+	helpers.WriteJSON(w, map[string]any{"status": "created", "id": id})
+}
+
+func DeleteSuppression(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/suppressions/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid suppression ID", http.StatusBadRequest)
+		return
+	}
+
+	found, err := db.DeleteSuppression(db.DB, id)
+	if err != nil {
+		http.Error(w, "failed to delete suppression", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Suppression not found", http.StatusNotFound)
+		return
+	}
+	record(r, audit.SuppressionDeleted, fmt.Sprintf("suppression:%d", id), nil)
+
+	helpers.WriteJSON(w, map[string]string{"status": "deleted"})
+}
+
+func parseExpiry(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, s, time.Local)
+}
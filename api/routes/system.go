@@ -0,0 +1,46 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/wiktoz/sentry/config"
+	"github.com/wiktoz/sentry/health"
+	"github.com/wiktoz/sentry/helpers"
+)
+
+// GetSystemConfig shows the effective server configuration with secrets masked
+func GetSystemConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	helpers.WriteJSON(w, config.Current.Redacted())
+}
+
+// GetDiagnostics runs every self-check now and shows the outcome of each
+func GetDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	helpers.WriteJSON(w, health.Run(r.Context()))
+}
+
+// Healthz reports that the process is up and serving requests
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	helpers.WriteJSON(w, map[string]string{"status": "ok"})
+}
+
+// Readyz reports whether sentry can scan. It is public, so it only names the
+// failed checks; the details are in the diagnostics.
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	report := health.Cached(r.Context())
+	if !report.Ready {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": "not ready", "failed": report.Failed()})
+		return
+	}
+	helpers.WriteJSON(w, map[string]string{"status": "ready"})
+}
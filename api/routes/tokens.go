@@ -0,0 +1,131 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/auth"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/helpers"
+	"github.com/wiktoz/sentry/models"
+)
+
+// GetTokens lists the caller's API tokens; admins see everyone's
+func GetTokens(w http.ResponseWriter, r *http.Request) {
+	u, _ := auth.UserFrom(r.Context())
+
+	owner := u.ID
+	if auth.HasRole(u, models.RoleAdmin) {
+		owner = 0
+	}
+
+	tokens, err := db.GetTokens(db.DB, owner)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	helpers.WriteJSON(w, tokens)
+}
+
+func CreateToken(w http.ResponseWriter, r *http.Request) {
+	u, _ := auth.UserFrom(r.Context())
+
+	var t models.APIToken
+	if err := helpers.ReadJSON(r.Body, &t); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	if len(t.Scopes) == 0 {
+		http.Error(w, "at least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, s := range t.Scopes {
+		if !auth.ValidScope(s) {
+			http.Error(w, "scopes must be read, scan or admin", http.StatusBadRequest)
+			return
+		}
+	}
+	if !auth.HasRole(u, auth.ScopeRole(t.Scopes)) {
+		http.Error(w, "scopes exceed your role", http.StatusForbidden)
+		return
+	}
+
+	if t.ExpiresAt != "" {
+		expires, err := parseExpiry(t.ExpiresAt)
+		if err != nil {
+			http.Error(w, "expires_at must be RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		if !expires.After(time.Now()) {
+			http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
+		t.ExpiresAt = expires.UTC().Format(time.DateTime)
+	}
+
+	token, hash, err := auth.GenerateToken()
+	if err != nil {
+		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	t.UserID = u.ID
+	t.Prefix = token[:len(auth.TokenPrefix)+6]
+
+	id, err := db.CreateToken(db.DB, t, hash)
+	if err != nil {
+		http.Error(w, "failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	created, err := db.GetToken(db.DB, id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	record(r, audit.TokenCreated, fmt.Sprintf("token:%d", id), map[string]any{
+		"name": created.Name, "prefix": created.Prefix, "scopes": created.Scopes, "expires_at": created.ExpiresAt,
+	})
+
+	created.Token = token
+	helpers.WriteJSON(w, created)
+}
+
+// DeleteToken revokes one of the caller's tokens; admins can revoke any
+func DeleteToken(w http.ResponseWriter, r *http.Request) {
+	u, _ := auth.UserFrom(r.Context())
+
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/tokens/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	t, err := db.GetToken(db.DB, id)
+	if err == sql.ErrNoRows || (err == nil && t.UserID != u.ID && !auth.HasRole(u, models.RoleAdmin)) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := db.DeleteToken(db.DB, id); err != nil {
+		http.Error(w, "failed to delete token", http.StatusInternalServerError)
+		return
+	}
+	record(r, audit.TokenDeleted, fmt.Sprintf("token:%d", id), map[string]string{"name": t.Name, "owner": t.Username})
+	helpers.WriteJSON(w, map[string]string{"status": "deleted"})
+}
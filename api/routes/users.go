@@ -0,0 +1,246 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/auth"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/helpers"
+	"github.com/wiktoz/sentry/models"
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role,omitempty"`
+	Token    string `json:"token,omitempty"`
+	Code     string `json:"code,omitempty"`
+
+	// Lets an admin clear two-factor for a user who lost their device and recovery codes
+	ResetTOTP bool `json:"reset_totp,omitempty"`
+}
+
+// Setup creates the first user with the one-time token logged at startup
+func Setup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var c credentials
+	if err := helpers.ReadJSON(r.Body, &c); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	c.Username = strings.TrimSpace(c.Username)
+	if !usernamePattern.MatchString(c.Username) {
+		http.Error(w, "invalid username", http.StatusBadRequest)
+		return
+	}
+
+	err := auth.CompleteSetup(c.Token, c.Username, c.Password)
+	switch {
+	case errors.Is(err, auth.ErrSetupDone):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, auth.ErrInvalidSetupToken):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, auth.ErrWeakPassword):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, "failed to create user", http.StatusInternalServerError)
+	default:
+		audit.Request(r, models.User{Username: c.Username}, audit.UserCreated, "user:"+c.Username,
+			map[string]string{"role": models.RoleAdmin, "via": "setup"})
+		helpers.WriteJSON(w, map[string]string{"status": "created"})
+	}
+}
+
+func GetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := db.GetUsers(db.DB)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	helpers.WriteJSON(w, users)
+}
+
+func CreateUser(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if err := helpers.ReadJSON(r.Body, &c); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	c.Username = strings.TrimSpace(c.Username)
+	if !usernamePattern.MatchString(c.Username) {
+		http.Error(w, "invalid username", http.StatusBadRequest)
+		return
+	}
+
+	if c.Role == "" {
+		c.Role = models.RoleViewer
+	}
+	if !auth.ValidRole(c.Role) {
+		http.Error(w, "role must be viewer, operator or admin", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.GetUserByName(db.DB, c.Username); err == nil {
+		http.Error(w, "username already exists", http.StatusConflict)
+		return
+	}
+
+	hash, err := auth.HashPassword(c.Password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := db.CreateUser(db.DB, c.Username, c.Role, hash)
+	if err != nil {
+		http.Error(w, "failed to create user", http.StatusInternalServerError)
+		return
+	}
+
+	record(r, audit.UserCreated, "user:"+c.Username, map[string]string{"role": c.Role})
+	helpers.WriteJSON(w, map[string]any{"status": "created", "id": id})
+}
+
+// UserByID serves PUT (password, role or two-factor reset) and DELETE on /api/users/{id}
+func UserByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/users/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := db.GetUser(db.DB, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var c credentials
+		if err := helpers.ReadJSON(r.Body, &c); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if c.Password == "" && c.Role == "" && !c.ResetTOTP {
+			http.Error(w, "password, role or reset_totp is required", http.StatusBadRequest)
+			return
+		}
+
+		var hash string
+		if c.Password != "" {
+			if hash, err = auth.HashPassword(c.Password); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if c.Role != "" && c.Role != user.Role {
+			if !auth.ValidRole(c.Role) {
+				http.Error(w, "role must be viewer, operator or admin", http.StatusBadRequest)
+				return
+			}
+			if user.Role == models.RoleAdmin && lastAdmin(w) {
+				return
+			}
+			if err := db.SetUserRole(db.DB, id, c.Role); err != nil {
+				http.Error(w, "failed to update user", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if hash != "" {
+			if err := db.SetUserPassword(db.DB, id, hash); err != nil {
+				http.Error(w, "failed to update user", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if c.ResetTOTP {
+			if err := db.DisableTOTP(db.DB, id); err != nil {
+				http.Error(w, "failed to update user", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// A new password or cleared two-factor signs the user out of their browser sessions
+		if hash != "" || c.ResetTOTP {
+			keep := 0
+			if s, ok := auth.SessionFrom(r.Context()); ok {
+				keep = s.ID
+			}
+			if err := db.DeleteUserSessions(db.DB, id, keep); err != nil {
+				http.Error(w, "failed to update user", http.StatusInternalServerError)
+				return
+			}
+		}
+		changes := map[string]any{}
+		if c.Role != "" && c.Role != user.Role {
+			changes["role"] = audit.Change{Before: user.Role, After: c.Role}
+		}
+		if hash != "" {
+			changes["password"] = "changed"
+		}
+		if c.ResetTOTP {
+			changes["totp"] = "reset"
+		}
+		record(r, audit.UserUpdated, "user:"+user.Username, changes)
+		helpers.WriteJSON(w, map[string]string{"status": "updated"})
+
+	case http.MethodDelete:
+		if user.Role == models.RoleAdmin && lastAdmin(w) {
+			return
+		}
+
+		if _, err := db.DeleteUser(db.DB, id); err != nil {
+			http.Error(w, "failed to delete user", http.StatusInternalServerError)
+			return
+		}
+		record(r, audit.UserDeleted, "user:"+user.Username, map[string]string{"role": user.Role})
+		helpers.WriteJSON(w, map[string]string{"status": "deleted"})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// lastAdmin refuses changes that would leave no admin to manage users
+func lastAdmin(w http.ResponseWriter) bool {
+	n, err := db.CountAdmins(db.DB)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return true
+	}
+	if n <= 1 {
+		http.Error(w, "cannot remove the last admin", http.StatusConflict)
+		return true
+	}
+	return false
+}
+
+func GetForbiddenAttempts(w http.ResponseWriter, r *http.Request) {
+	list, err := db.GetForbiddenAttempts(db.DB, 500)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	helpers.WriteJSON(w, list)
+}
@@ -0,0 +1,147 @@
+package scripts
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+	"github.com/wiktoz/sentry/notify"
+	"github.com/wiktoz/sentry/risk"
+)
+
+// publishScanResults compares a finished scan with the previous one and publishes
+// new hosts, new findings, port changes and finally the scan.finished summary
+func publishScanResults(scanID int) error {
+	current, err := db.GetScanData(db.DB, scanID)
+	if err != nil {
+		return err
+	}
+
+	findings, err := db.GetFindings(db.DB, scanID)
+	if err != nil {
+		return err
+	}
+
+	var previous *models.ScanData
+	prevID, err := db.PreviousScanID(db.DB, scanID)
+	switch {
+	case err == nil:
+		prev, err := db.GetScanData(db.DB, prevID)
+		if err != nil {
+			return err
+		}
+		previous = &prev
+	case err != sql.ErrNoRows:
+		return err
+	}
+
+	now := time.Now().UTC()
+	known := map[string]bool{}
+	var active []models.Finding
+
+	if previous != nil {
+		prevHosts := map[string]models.HostData{}
+		for _, h := range previous.Hosts {
+			prevHosts[h.Address] = h
+			for _, p := range h.Ports {
+				for _, v := range p.Vulnerabilities {
+					known[fingerprint(h.Address, p.PortNum, p.Protocol, v.ID)] = true
+				}
+			}
+		}
+
+		for _, h := range current.Hosts {
+			prev, ok := prevHosts[h.Address]
+			if !ok {
+				notify.Publish(notify.Event{
+					Type:    notify.EventNewHost,
+					Time:    now,
+					ScanID:  scanID,
+					Host:    h.Address,
+					Message: fmt.Sprintf("New host %s with %d open ports", h.Address, len(openPorts(h))),
+				})
+				continue
+			}
+
+			if changes := portChanges(prev, h); len(changes) > 0 {
+				notify.Publish(notify.Event{
+					Type:    notify.EventBaselineDeviation,
+					Time:    now,
+					ScanID:  scanID,
+					Host:    h.Address,
+					Message: fmt.Sprintf("%d port changes on %s since scan #%d", len(changes), h.Address, previous.ID),
+					Changes: changes,
+				})
+			}
+		}
+	}
+
+	for _, f := range findings {
+		if !risk.Counts(f.VulnerabilityData) {
+			continue
+		}
+
+		f.New = !known[fingerprint(f.Address, f.PortNum, f.Protocol, f.ID)]
+		active = append(active, f)
+
+		if f.New {
+			finding := f
+			notify.Publish(notify.Event{
+				Type:    notify.EventNewFinding,
+				Time:    now,
+				ScanID:  scanID,
+				Host:    f.Address,
+				Message: fmt.Sprintf("%s (%.1f) on %s:%d/%s", f.ID, f.Score, f.Address, f.PortNum, f.Protocol),
+				Finding: &finding,
+			})
+		}
+	}
+
+	notify.Publish(notify.Event{
+		Type:     notify.EventScanFinished,
+		Time:     now,
+		ScanID:   scanID,
+		Message:  fmt.Sprintf("Scan finished: %d hosts, %d findings, risk %.1f", len(current.Hosts), len(active), current.Risk),
+		Findings: active,
+	})
+
+	return nil
+}
+
+func fingerprint(address string, port int, protocol, vulnID string) string {
+	return fmt.Sprintf("%s|%d/%s|%s", address, port, protocol, vulnID)
+}
+
+func openPorts(h models.HostData) map[string]models.PortData {
+	open := map[string]models.PortData{}
+	for _, p := range h.Ports {
+		if p.State == "open" {
+			open[fmt.Sprintf("%d/%s", p.PortNum, p.Protocol)] = p
+		}
+	}
+	return open
+}
+
+func portChanges(before, after models.HostData) []models.PortChange {
+	var changes []models.PortChange
+	was, is := openPorts(before), openPorts(after)
+
+	for key, p := range is {
+		if _, ok := was[key]; !ok {
+			changes = append(changes, models.PortChange{PortNum: p.PortNum, Protocol: p.Protocol, ServiceName: p.ServiceName, Change: "opened"})
+		}
+	}
+	for key, p := range was {
+		if _, ok := is[key]; !ok {
+			changes = append(changes, models.PortChange{PortNum: p.PortNum, Protocol: p.Protocol, ServiceName: p.ServiceName, Change: "closed"})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].PortNum < changes[j].PortNum
+	})
+	return changes
+}
@@ -0,0 +1,233 @@
+package scripts
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wiktoz/sentry/config"
+	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/models"
+)
+
+// Feed files are dropped into this directory (e.g. by cron + curl) and picked up automatically
+const (
+	kevFileName  = "known_exploited_vulnerabilities.json"
+	epssFileName = "epss_scores-current.csv"
+)
+
+var (
+	feedsMu    sync.Mutex
+	feedsMtime = map[string]time.Time{}
+	lastImport time.Time
+)
+
+func FeedsDir() string {
+	return config.Current.FeedsDir
+}
+
+func ImportKEVFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	entries, err := parseKEV(f)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := db.ReplaceKEV(db.DB, entries); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// parseKEV reads the CISA KEV catalog. An empty or wrong-schema file is refused
+// so it cannot wipe the KEV table.
+func parseKEV(r io.Reader) ([]models.KEVEntry, error) {
+	var catalog models.KEVCatalog
+	if err := json.NewDecoder(r).Decode(&catalog); err != nil {
+		return nil, fmt.Errorf("decode KEV catalog: %v", err)
+	}
+
+	var entries []models.KEVEntry
+	for _, e := range catalog.Vulnerabilities {
+		if e.CVEID == "" {
+			continue
+		}
+		e.CVEID = strings.ToUpper(e.CVEID)
+		entries = append(entries, e)
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("no KEV entries found")
+	}
+	return entries, nil
+}
+
+func ImportEPSSFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	entries, scoreDate, err := parseEPSS(r)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := db.ReplaceEPSS(db.DB, entries, scoreDate); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// parseEPSS reads the FIRST EPSS CSV, which starts with a
+// "#model_version:...,score_date:..." comment followed by a cve,epss,percentile header
+func parseEPSS(r io.Reader) ([]models.EPSSEntry, string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	var entries []models.EPSSEntry
+	scoreDate := ""
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		if len(record) > 0 && strings.HasPrefix(record[0], "#") {
+			for _, field := range record {
+				if v, ok := strings.CutPrefix(field, "score_date:"); ok {
+					scoreDate = v
+				}
+			}
+			continue
+		}
+
+		if len(record) < 3 || record[0] == "cve" {
+			continue
+		}
+
+		epss, err := strconv.ParseFloat(record[1], 64)
+		if err != nil {
+			continue
+		}
+		percentile, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, models.EPSSEntry{
+			CVEID:      strings.ToUpper(record[0]),
+			EPSS:       epss,
+			Percentile: percentile,
+		})
+	}
+
+	if len(entries) == 0 {
+		return nil, "", errors.New("no EPSS rows found")
+	}
+	return entries, scoreDate, nil
+}
+
+// RefreshFeeds imports every feed file in dir whose modification time changed since the last import.
+// With force set, files are re-imported regardless.
+func RefreshFeeds(dir string, force bool) error {
+	feedsMu.Lock()
+	defer feedsMu.Unlock()
+
+	imports := []struct {
+		names []string
+		load  func(string) (int, error)
+	}{
+		{[]string{kevFileName}, ImportKEVFile},
+		{[]string{epssFileName, epssFileName + ".gz"}, ImportEPSSFile},
+	}
+
+	var errs []error
+	for _, imp := range imports {
+		for _, name := range imp.names {
+			path := filepath.Join(dir, name)
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+
+			if !force && feedsMtime[path].Equal(info.ModTime()) {
+				break
+			}
+
+			n, err := imp.load(path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", name, err))
+				break
+			}
+
+			feedsMtime[path] = info.ModTime()
+			lastImport = time.Now()
+			log.Printf("Imported %d entries from %s", n, path)
+			break
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func LastFeedImport() time.Time {
+	feedsMu.Lock()
+	defer feedsMu.Unlock()
+	return lastImport
+}
+
+func StartFeedRefresh(ctx context.Context) {
+	go func() {
+		dir := FeedsDir()
+
+		if err := RefreshFeeds(dir, false); err != nil {
+			log.Printf("Feed import failed: %v", err)
+		}
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := RefreshFeeds(dir, false); err != nil {
+					log.Printf("Feed import failed: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,67 @@
+package scripts
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// interruptGrace is how long cancelled scans get to record what they found
+const interruptGrace = 5 * time.Second
+
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// running tracks scans in progress so shutdown can wait for them
+var running struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	draining bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func init() {
+	running.ctx, running.cancel = context.WithCancel(context.Background())
+}
+
+// Track registers a scan about to start. Call done when it ends; ctx is
+// cancelled if the scan outlives the shutdown deadline.
+func Track() (ctx context.Context, done func(), err error) {
+	running.mu.Lock()
+	defer running.mu.Unlock()
+
+	if running.draining {
+		return nil, nil, ErrShuttingDown
+	}
+	running.wg.Add(1)
+	return running.ctx, running.wg.Done, nil
+}
+
+// DrainScans refuses new scans and waits for running ones until ctx expires.
+// Scans still running then are cancelled; each keeps the hosts it already
+// stored and is marked failed.
+func DrainScans(ctx context.Context) error {
+	running.mu.Lock()
+	running.draining = true
+	running.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		running.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+	}
+
+	running.cancel()
+	select {
+	case <-finished:
+	case <-time.After(interruptGrace):
+	}
+	return ctx.Err()
+}
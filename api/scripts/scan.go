@@ -14,7 +14,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/wiktoz/sentry/audit"
+	"github.com/wiktoz/sentry/config"
 	"github.com/wiktoz/sentry/db"
+	"github.com/wiktoz/sentry/health"
+	"github.com/wiktoz/sentry/metrics"
+	"github.com/wiktoz/sentry/notify"
 )
 
 type NmapRun struct {
@@ -59,6 +64,9 @@ type Script struct {
 
 type Vulnerability struct {
 	VulnID      string
+	Type        string
+	CVE         string
+	Exploit     bool
 	Score       float64
 	URL         string
 	Description string
@@ -82,25 +90,115 @@ func filterTargets(targets string) string {
 	return strings.Join(filtered, " ")
 }
 
-func RunFullScan(scanID int, target string) {
-	hosts, err := RunNormalScan(target, scanID)
+var (
+	scanPhaseDuration = metrics.NewHistogramVec("sentry_scan_phase_duration_seconds",
+		"Time spent in each scan phase.", metrics.DurationBuckets, "phase")
+	nmapFailures = metrics.NewCounterVec("sentry_nmap_failures_total",
+		"nmap runs that failed to start or exited with an error, by scan phase.", "phase")
+)
+
+const nmapWaitDelay = 2 * time.Second
+
+// Scan phases, as reported in metrics
+const (
+	phaseDiscovery     = "discovery"
+	phaseVulnerability = "vulnerability"
+	phasePublish       = "publish"
+)
+
+// timePhase records how long a scan phase took when the returned func is called
+func timePhase(phase string) func() {
+	start := time.Now()
+	return func() { scanPhaseDuration.Observe(time.Since(start).Seconds(), phase) }
+}
+
+// runNmap runs nmap and returns its XML output, counting failures other than cancellation
+func runNmap(ctx context.Context, phase string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, config.Current.NmapPath, args...)
+	cmd.WaitDelay = nmapWaitDelay // don't hang on output pipes held by nmap's children after a kill
+	output, err := cmd.Output()
+	if err != nil && ctx.Err() == nil {
+		nmapFailures.Inc(phase)
+	}
+	return output, err
+}
+
+// RunFullScan discovers hosts, then scans their open ports for vulnerabilities.
+// Cancelling ctx stops nmap; hosts stored until then are kept.
+func RunFullScan(ctx context.Context, scanID int, target string) {
+	notify.Publish(notify.Event{
+		Type:    notify.EventScanStarted,
+		Time:    time.Now().UTC(),
+		ScanID:  scanID,
+		Message: "Scan started for " + target,
+	})
+
+	done := timePhase(phaseDiscovery)
+	hosts, err := RunNormalScan(ctx, target, scanID)
+	done()
+	if ctx.Err() != nil {
+		interruptScan(scanID, target, phaseDiscovery)
+		return
+	}
 	if err != nil {
-		log.Fatalf("Normal scan failed: %v", err)
+		failScan(scanID, fmt.Errorf("normal scan failed: %v", err))
+		return
 	}
 
-	err = RunVulnScan(hosts, scanID)
+	done = timePhase(phaseVulnerability)
+	err = RunVulnScan(ctx, hosts, scanID)
+	done()
+	if ctx.Err() != nil {
+		interruptScan(scanID, target, phaseVulnerability)
+		return
+	}
 	if err != nil {
-		log.Fatalf("Vulnerability scan failed: %v", err)
+		failScan(scanID, fmt.Errorf("vulnerability scan failed: %v", err))
+		return
+	}
+
+	if err := db.SetScanStatus(db.DB, scanID, db.ScanFinished); err != nil {
+		log.Printf("Failed to update scan %d status: %v", scanID, err)
+	}
+
+	done = timePhase(phasePublish)
+	if err := publishScanResults(scanID); err != nil {
+		log.Printf("Failed to publish results of scan %d: %v", scanID, err)
 	}
+	done()
 
 	log.Println("Scan completed successfully")
 }
 
-func RunNormalScan(target string, scanID int) ([]Host, error) {
+// interruptScan fails a scan cancelled by shutdown and audits it as done by the system
+func interruptScan(scanID int, target, phase string) {
+	audit.Scheduled(audit.ScanCancelled, fmt.Sprintf("scan:%d", scanID), map[string]string{
+		"targets": target,
+		"phase":   phase,
+		"reason":  ErrShuttingDown.Error(),
+	})
+	failScan(scanID, ErrShuttingDown)
+}
+
+func failScan(scanID int, err error) {
+	log.Printf("Scan %d failed: %v", scanID, err)
+
+	if err := db.SetScanStatus(db.DB, scanID, db.ScanFailed); err != nil {
+		log.Printf("Failed to update scan %d status: %v", scanID, err)
+	}
+
+	notify.Publish(notify.Event{
+		Type:    notify.EventScanFailed,
+		Time:    time.Now().UTC(),
+		ScanID:  scanID,
+		Message: err.Error(),
+	})
+}
+
+func RunNormalScan(ctx context.Context, target string, scanID int) ([]Host, error) {
 	log.Println("Normal Scan started")
 
-	cmd := exec.Command("nmap", "--host-timeout", "30s", "-oX", "-", target)
-	output, err := cmd.Output()
+	output, err := runNmap(ctx, phaseDiscovery, "--host-timeout", "30s", "-oX", "-", target)
 	if err != nil {
 		return nil, err
 	}
@@ -168,8 +266,12 @@ func RunNormalScan(target string, scanID int) ([]Host, error) {
 	return filteredHosts, nil
 }
 
-func RunVulnScan(hosts []Host, scanID int) error {
+func RunVulnScan(ctx context.Context, hosts []Host, scanID int) error {
 	for _, host := range hosts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		for _, addr := range host.Addresses {
 			if addr.AddrType != "ipv4" {
 				continue
@@ -193,8 +295,7 @@ func RunVulnScan(hosts []Host, scanID int) error {
 
 			log.Println("Running nmap for:", addr.Addr)
 
-			cmd := exec.Command("nmap", args...)
-			output, err := cmd.Output()
+			output, err := runNmap(ctx, phaseVulnerability, args...)
 			if err != nil {
 				return err
 			}
@@ -209,11 +310,6 @@ func RunVulnScan(hosts []Host, scanID int) error {
 				return err
 			}
 
-			var emailBody strings.Builder
-			totalVulns := 0
-
-			emailBody.WriteString("<html><body style=\"font-family: sans-serif;\">")
-
 			for _, scannedHost := range nmapRun.Hosts {
 				if scannedHost.TimedOut {
 					log.Printf("Skipping timed-out host: %+v\n", scannedHost.Addresses)
@@ -232,9 +328,6 @@ func RunVulnScan(hosts []Host, scanID int) error {
 						return err
 					}
 
-					var hostVulnCount int
-					hostHeaderWritten := false
-
 					for _, scannedPort := range scannedHost.Ports.Port {
 						var portID int64
 						err := tx.QueryRow("SELECT id FROM ports WHERE host_id = ? AND port_id = ?", hostID, scannedPort.PortID).Scan(&portID)
@@ -248,71 +341,85 @@ func RunVulnScan(hosts []Host, scanID int) error {
 						}
 
 						for _, script := range scannedPort.Scripts {
-							if script.ID == "vulners" {
-								vulns := ParseVulnersOutput(script.Output)
-								if len(vulns) > 0 {
-									if !hostHeaderWritten {
-										emailBody.WriteString(fmt.Sprintf(`<h2>Host: <b>%s</b></h2>`, scannedAddr.Addr))
-										hostHeaderWritten = true
-									}
-
-									hostVulnCount += len(vulns)
-									emailBody.WriteString(fmt.Sprintf(`<p><b>Port: %d</b> - Showing up to 5 vulnerabilities:</p><ul>`, scannedPort.PortID))
-
-									for i, vuln := range vulns {
-										if i >= 5 {
-											break
-										}
-										emailBody.WriteString(fmt.Sprintf(
-											`<li><span style="color:#d9534f;font-weight:bold;"><a href="%s" style="color:#d9534f;text-decoration:none;">%s</a></span> (Score: %.1f)</li>`,
-											vuln.URL, vuln.VulnID, vuln.Score,
-										))
-									}
-
-									emailBody.WriteString("</ul>")
-								}
+							if script.ID != "vulners" {
+								continue
+							}
 
-								for _, vuln := range vulns {
-									_, err := tx.Exec(
-										`INSERT INTO vulnerabilities (port_id, vuln_id, score, url, description)
-										 VALUES (?, ?, ?, ?, ?)`,
-										portID, vuln.VulnID, vuln.Score, vuln.URL, vuln.Description,
-									)
-									if err != nil {
-										_ = tx.Rollback()
-										return err
-									}
+							for _, vuln := range ParseVulnersOutput(script.Output) {
+								_, err := tx.Exec(
+									`INSERT INTO vulnerabilities (port_id, vuln_id, vuln_type, cve_id, exploit, score, url, description)
+									 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+									portID, vuln.VulnID, vuln.Type, nullIfEmpty(vuln.CVE), vuln.Exploit, vuln.Score, vuln.URL, vuln.Description,
+								)
+								if err != nil {
+									_ = tx.Rollback()
+									return err
 								}
 							}
 						}
 					}
-
-					if hostVulnCount > 0 {
-						totalVulns += hostVulnCount
-						emailBody.WriteString(fmt.Sprintf(`<p><i>Total vulnerabilities on this host: <b>%d</b></i></p>`, hostVulnCount))
-					}
 				}
 			}
 
-			emailBody.WriteString("</body></html>")
-
 			if err := tx.Commit(); err != nil {
 				return err
 			}
-
-			if totalVulns > 0 {
-				subject := fmt.Sprintf("Vulnerabilities detected on %s (%d total)", addr.Addr, totalVulns)
-				if err := SendEmail(subject, emailBody.String()); err != nil {
-					log.Printf("Failed to send email: %v", err)
-				} else {
-					log.Printf("Email sent for host %s", addr.Addr)
-				}
-			}
 		}
 	}
 	return nil
 }
 
+// vulners prefixes IDs with their source database; anything unknown falls back to the URL path
+var vulnIDPrefixes = []struct {
+	prefix string
+	kind   string
+}{
+	{"CVE-", "cve"},
+	{"EDB-ID:", "exploitdb"},
+	{"PACKETSTORM:", "packetstorm"},
+	{"MSF:", "metasploit"},
+	{"GHSA-", "ghsa"},
+	{"1337DAY-ID-", "zdt"},
+	{"SSV:", "seebug"},
+	{"CNVD-", "cnvd"},
+}
+
+var cveRegex = regexp.MustCompile(`(?i)CVE-\d{4}-\d{4,}`)
+
+func classifyVulnID(vulnID, url string) string {
+	upper := strings.ToUpper(vulnID)
+	for _, p := range vulnIDPrefixes {
+		if strings.HasPrefix(upper, p.prefix) {
+			return p.kind
+		}
+	}
+
+	// e.g. https://vulners.com/githubexploit/5E6968B4-...
+	if rest, ok := strings.CutPrefix(url, "https://vulners.com/"); ok {
+		if kind, _, found := strings.Cut(rest, "/"); found && kind != "" {
+			return strings.ToLower(kind)
+		}
+	}
+	return "other"
+}
+
+// cveAlias links a vulners entry to the CVE it refers to, if the ID, URL or description names one
+func cveAlias(vulnID, url, desc string) string {
+	for _, s := range []string{vulnID, url, desc} {
+		if m := cveRegex.FindString(s); m != "" {
+			return strings.ToUpper(m)
+		}
+	}
+	return ""
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
 func ParseVulnersOutput(rawOutput string) []Vulnerability {
 	cleaned := html.UnescapeString(rawOutput)
 	var vulns []Vulnerability
@@ -336,13 +443,24 @@ func ParseVulnersOutput(rawOutput string) []Vulnerability {
 		}
 		url := fields[2]
 
+		// Trailing fields are either the *EXPLOIT* marker or a free-form description
 		desc := ""
-		if len(fields) > 3 {
-			desc = fields[3]
+		exploit := false
+		for _, f := range fields[3:] {
+			f = strings.TrimSpace(f)
+			switch {
+			case f == "*EXPLOIT*":
+				exploit = true
+			case f != "" && desc == "":
+				desc = f
+			}
 		}
 
 		vulns = append(vulns, Vulnerability{
 			VulnID:      vulnID,
+			Type:        classifyVulnID(vulnID, url),
+			CVE:         cveAlias(vulnID, url, desc),
+			Exploit:     exploit,
 			Score:       score,
 			URL:         url,
 			Description: desc,
@@ -431,19 +549,27 @@ func StartAutoScan(ctx context.Context) {
 					continue
 				}
 
-				res, err := db.DB.Exec("INSERT INTO scans (created_at) VALUES (datetime('now'))")
-				if err != nil {
-					log.Println("Can't create scan record, skipping scan:", err)
+				if err := health.ScanPreflight(ctx); err != nil {
+					log.Println("Skipping scheduled scan:", err)
 					continue
 				}
 
-				scanID, err := res.LastInsertId()
+				scanCtx, done, err := Track()
+				if err != nil {
+					return
+				}
+
+				scanID, err := db.CreateScan(db.DB)
 				if err != nil {
-					log.Println("No scan ID returned, skipping scan.")
+					done()
+					log.Println("Can't create scan record, skipping scan:", err)
 					continue
 				}
 
-				RunFullScan(int(scanID), targets)
+				audit.Scheduled(audit.ScanStarted, fmt.Sprintf("scan:%d", scanID), map[string]string{"targets": targets, "trigger": "schedule"})
+
+				RunFullScan(scanCtx, scanID, targets)
+				done()
 				_ = updateTicker()
 
 			case <-ctx.Done():
@@ -3,6 +3,8 @@ import { useState, useEffect } from 'react'
 import Home from './pages/Home'
 import Config from './pages/Config'
 import Scans from './pages/Scans'
+import Login, { type SessionResponse } from './pages/Login'
+import { apiFetch, setCSRFToken, UNAUTHORIZED_EVENT } from './api'
 
 export const Page = {
 	Home: "Home",
@@ -21,8 +23,13 @@ const pageComponents: Record<Page, React.FC> = {
 const STORAGE_KEY = 'lastPageBookmark';
 
 function App() {
-	// Initialize page from localStorage if available
+	// Initialize page from the ?page= link parameter or localStorage if available
 	const [pageOpen, setPageOpen] = useState<Page>(() => {
+		const linkedPage = new URLSearchParams(window.location.search).get('page');
+		if (linkedPage && Object.values(Page).includes(linkedPage as Page)) {
+			return linkedPage as Page;
+		}
+
 		const savedPage = localStorage.getItem(STORAGE_KEY);
 		if (savedPage && Object.values(Page).includes(savedPage as Page)) {
 			return savedPage as Page;
@@ -30,11 +37,42 @@ function App() {
 		return Page.Home;
 	});
 
+	// undefined while the existing session is being checked, null when signed out
+	const [session, setSession] = useState<SessionResponse | null | undefined>(undefined);
+
 	useEffect(() => {
 		// Save page to localStorage when it changes
 		localStorage.setItem(STORAGE_KEY, pageOpen);
 	}, [pageOpen]);
 
+	useEffect(() => {
+		const signedOut = () => setSession(null);
+		window.addEventListener(UNAUTHORIZED_EVENT, signedOut);
+
+		apiFetch('/api/auth/session')
+			.then(response => response.ok ? response.json() : null)
+			.then(setSession)
+			.catch(signedOut);
+
+		return () => window.removeEventListener(UNAUTHORIZED_EVENT, signedOut);
+	}, []);
+
+	useEffect(() => {
+		setCSRFToken(session?.csrf_token ?? '');
+	}, [session]);
+
+	const logout = async () => {
+		try {
+			await apiFetch('/api/auth/logout', { method: 'POST' });
+		} catch (err) {
+			console.error('Logout failed:', err);
+		}
+		setSession(null);
+	}
+
+	if (session === undefined) return null;
+	if (session === null) return <Login onLogin={setSession}/>;
+
 	const CurrentPageComponent = pageComponents[pageOpen];
 
 	return (
@@ -50,6 +88,12 @@ function App() {
 						{pageValue}
 					</div>
 				))}
+				<div className='ml-auto flex items-center gap-4 text-sm'>
+					<span>{session.user.username}</span>
+					<div onClick={() => logout()} className='cursor-pointer font-semibold rounded-3xl px-4 py-1.5 border border-black'>
+						Sign out
+					</div>
+				</div>
 			</nav>
 
 			<main className='w-full rounded-3xl p-2'>
@@ -0,0 +1,32 @@
+// Requests go to the same origin as the UI; the session cookie is sent automatically
+// and state-changing requests carry the CSRF token handed out at login.
+
+let csrfToken = '';
+
+export const setCSRFToken = (token: string) => {
+    csrfToken = token;
+};
+
+export const UNAUTHORIZED_EVENT = 'sentry:unauthorized';
+
+const safeMethods = ['GET', 'HEAD', 'OPTIONS'];
+
+export async function apiFetch(path: string, init: RequestInit = {}): Promise<Response> {
+    const headers = new Headers(init.headers);
+    const method = (init.method ?? 'GET').toUpperCase();
+
+    if (!safeMethods.includes(method) && csrfToken) {
+        headers.set('X-CSRF-Token', csrfToken);
+    }
+    if (init.body && !headers.has('Content-Type')) {
+        headers.set('Content-Type', 'application/json');
+    }
+
+    const response = await fetch(path, { ...init, headers, credentials: 'same-origin' });
+
+    // An expired or revoked session sends the user back to the login form
+    if (response.status === 401 && path !== '/api/auth/login') {
+        window.dispatchEvent(new Event(UNAUTHORIZED_EVENT));
+    }
+    return response;
+}
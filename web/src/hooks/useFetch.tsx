@@ -1,4 +1,5 @@
 import { useEffect, useState, useCallback } from 'react';
+import { apiFetch } from '../api';
 
 function useFetch<T>(url: string) {
     const [data, setData] = useState<T | null>(null);
@@ -9,12 +10,7 @@ function useFetch<T>(url: string) {
         setLoading(true);  // Ensure loading is true when refetching
         setError(null);    // Reset error before retry
         try {
-            const username = 'admin';
-            const password = 'secret';
-            const headers = new Headers();
-            headers.set('Authorization', 'Basic ' + btoa(username + ':' + password));
-
-            const response = await fetch(url, { headers });
+            const response = await apiFetch(url);
             if (!response.ok) 
                 throw new Error('Network error');
 
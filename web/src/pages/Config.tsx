@@ -1,5 +1,6 @@
 import { useState, useEffect } from "react"
 import useFetch from "../hooks/useFetch"
+import { apiFetch } from "../api"
 
 interface ConfigInterface {
     email: string,
@@ -12,7 +13,7 @@ const Config = () => {
     const [freq, setFreq] = useState<string>("")
     const [network, setNetwork] = useState<string>("")
 
-    const { data, loading, error, refetch } = useFetch<ConfigInterface>('http://localhost:8080/api/config');
+    const { data, loading, error, refetch } = useFetch<ConfigInterface>('/api/config');
 
     useEffect(() => {
         if (!loading && data) {
@@ -28,15 +29,8 @@ const Config = () => {
 
     const updateConfig = async () => {
         try {
-            const username = 'admin';
-            const password = 'secret';
-            const headers = new Headers();
-            headers.set('Authorization', 'Basic ' + btoa(username + ':' + password));
-            headers.set('Content-Type', 'application/json');
-
-            const response = await fetch('http://localhost:8080/api/config', {
+            const response = await apiFetch('/api/config', {
                 method: 'PUT',
-                headers,
                 body: JSON.stringify({
                     email: email,
                     scan_frequency: Number(freq),
@@ -1,5 +1,6 @@
 import { useState } from "react";
 import useFetch from "../hooks/useFetch";
+import { apiFetch } from "../api";
 
 interface Scan {
     id: number,
@@ -35,7 +36,7 @@ function formatDate(rawDate:string) {
 const Home = () => {
     const [run, setRun] = useState<boolean>(false)
 
-    const { data, loading, error } = useFetch<Scan[]>('http://localhost:8080/api/scans');
+    const { data, loading, error } = useFetch<Scan[]>('/api/scans');
 
     if (loading) return <div>Loading...</div>;
     if (error) return <div>Error: {error}</div>;
@@ -65,16 +66,7 @@ const Home = () => {
 
     const runScan = async () => {
         try {
-            const username = 'admin';
-            const password = 'secret';
-            const headers = new Headers();
-            headers.set('Authorization', 'Basic ' + btoa(username + ':' + password));
-            headers.set('Content-Type', 'application/json');
-
-            const response = await fetch('http://localhost:8080/api/scan/run', {
-                method: 'GET',
-                headers,
-            });
+            const response = await apiFetch('/api/scan/run', { method: 'POST' });
 
             if (!response.ok) {
                 throw new Error(`HTTP error! status: ${response.status}`);
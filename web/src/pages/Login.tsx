@@ -0,0 +1,108 @@
+import { useState, useEffect } from "react"
+import { apiFetch } from "../api"
+
+export interface SessionUser {
+    id: number,
+    username: string,
+    role: string,
+    totp_enabled: boolean
+}
+
+export interface SessionResponse {
+    user: SessionUser,
+    csrf_token?: string,
+    expires_at?: string
+}
+
+interface LoginProps {
+    onLogin: (session: SessionResponse) => void
+}
+
+const Login = ({ onLogin }: LoginProps) => {
+    const [username, setUsername] = useState<string>("")
+    const [password, setPassword] = useState<string>("")
+    const [code, setCode] = useState<string>("")
+    const [needCode, setNeedCode] = useState<boolean>(false)
+    const [error, setError] = useState<string | null>(null)
+    const [sso, setSSO] = useState<{ enabled: boolean, name: string } | null>(null)
+
+    useEffect(() => {
+        // A failed single sign-on comes back as ?sso_error=
+        const params = new URLSearchParams(window.location.search)
+        const ssoError = params.get('sso_error')
+        if (ssoError) {
+            setError(ssoError)
+            params.delete('sso_error')
+            const query = params.toString()
+            window.history.replaceState(null, '', window.location.pathname + (query ? '?' + query : ''))
+        }
+
+        apiFetch('/api/auth/oidc')
+            .then(response => response.ok ? response.json() : null)
+            .then(setSSO)
+            .catch(() => setSSO(null))
+    }, [])
+
+    const login = async () => {
+        setError(null)
+        try {
+            const response = await apiFetch('/api/auth/login', {
+                method: 'POST',
+                body: JSON.stringify({ username, password, code }),
+            });
+
+            if (response.headers.get('X-Sentry-OTP') === 'required') {
+                if (needCode) {
+                    setError((await response.text()).trim())
+                }
+                setNeedCode(true)
+                return
+            }
+            if (!response.ok) {
+                setError((await response.text()).trim())
+                return
+            }
+
+            onLogin(await response.json())
+        } catch (err) {
+            console.error('Login failed:', err);
+            setError('Login failed')
+        }
+    }
+
+    return(
+        <form className="m-8 flex flex-col gap-2" onSubmit={e => { e.preventDefault(); login() }}>
+            <h3 className="font-bold text-xl mb-4">Sign in</h3>
+            <div className="flex flex-col w-full md:w-72">
+                <label htmlFor="username" className="text-xs p-1">username</label>
+                <input id="username" type="text" autoComplete="username" className="border border-black px-3 py-1 rounded-xl focus:outline-0" onChange={e => setUsername(e.target.value)} value={username}/>
+            </div>
+            <div className="flex flex-col w-full md:w-72">
+                <label htmlFor="password" className="text-xs p-1">password</label>
+                <input id="password" type="password" autoComplete="current-password" className="border border-black px-3 py-1 rounded-xl focus:outline-0" onChange={e => setPassword(e.target.value)} value={password}/>
+            </div>
+            {
+                needCode &&
+                <div className="flex flex-col w-full md:w-72">
+                    <label htmlFor="code" className="text-xs p-1">authenticator or recovery code</label>
+                    <input id="code" type="text" autoComplete="one-time-code" className="border border-black px-3 py-1 rounded-xl focus:outline-0" onChange={e => setCode(e.target.value)} value={code} autoFocus/>
+                </div>
+            }
+            {
+                error &&
+                <p className="text-sm text-red-600">{error}</p>
+            }
+            <button type="submit" className="bg-black text-white font-semibold rounded-xl px-3 py-2 w-full md:w-72 text-sm text-center cursor-pointer my-2">
+                Sign in
+            </button>
+            {
+                sso?.enabled &&
+                <a href="/api/auth/oidc/login" className="border border-black font-semibold rounded-xl px-3 py-2 w-full md:w-72 text-sm text-center">
+                    Sign in with {sso.name}
+                </a>
+            }
+        </form>
+    )
+}
+
+export default Login
@@ -11,7 +11,10 @@ interface Scan {
             protocol: string,
             state: string
             vulnerabilities: [{
-                cve: string,
+                id: string,
+                type: string,
+                cve?: string,
+                exploit: boolean,
 	            description: string,
                 score: string,
                 url: string
@@ -32,7 +35,7 @@ function formatDate(rawDate:string) {
 }
 
 const Stats = () => {
-    const { data, loading, error } = useFetch<Scan[]>('http://localhost:8080/api/scans');
+    const { data, loading, error } = useFetch<Scan[]>('/api/scans');
 
     if (loading) return <p>Loading...</p>;
     if (error) return <p>Error: {error}</p>;
@@ -77,8 +80,8 @@ const Stats = () => {
                                                                             {
                                                                                 port.vulnerabilities.slice(0, 5).map(vuln => {
                                                                                     return(
-                                                                                        <div key={host.address + port.port_num + vuln.cve}>
-                                                                                            <p>{vuln.score} <a href={vuln.url} target="_blank">{vuln.cve}</a></p>
+                                                                                        <div key={host.address + port.port_num + vuln.id}>
+                                                                                            <p>{vuln.score} <a href={vuln.url} target="_blank">{vuln.id}</a>{vuln.exploit && " (exploit)"}</p>
                                                                                         </div>
                                                                                     )
                                                                                 })
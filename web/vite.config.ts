@@ -5,6 +5,13 @@ import tailwindcss from '@tailwindcss/vite'
 // https://vite.dev/config/
 export default defineConfig({
   plugins: [react(), tailwindcss()],
+  server: {
+    // The API sets a same-origin session cookie, so the dev server forwards to it
+    proxy: {
+      // secure: false accepts the API's self-signed certificate
+      '/api': { target: 'https://localhost:8443', secure: false },
+    },
+  },
   build: {
     terserOptions: {
       compress: {